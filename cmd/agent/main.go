@@ -5,10 +5,12 @@ import (
 	"final3/internal/agent"
 	"final3/internal/config"
 	"final3/internal/logger"
+	"final3/internal/tracing"
 	"os"
 	"os/signal"
 	"sync"
 	"syscall"
+	"time"
 )
 
 func main() {
@@ -26,6 +28,10 @@ func main() {
 		panic(err)
 	}
 
+	if err := tracing.Init(cfg, "agent"); err != nil {
+		panic(err)
+	}
+
 	agent, err := agent.NewAgent(&cfg.Agent)
 	if err != nil {
 		return
@@ -49,18 +55,68 @@ func main() {
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
-	select {
-	case sig := <-sigChan:
-		logger.Info("Receiver signal. Shutting down...", "signal", sig)
-		cancel()
-		wg.Wait()
-		logger.Info("Agent shut down gracefully")
-		os.Exit(0)
-	case err := <-errChan:
-		logger.Error("Agent failed", "error", err)
-		cancel()
-		wg.Wait()
-		logger.Info("Agent shut down with error")
-		os.Exit(1)
+	reloadChan := make(chan os.Signal, 1)
+	signal.Notify(reloadChan, syscall.SIGHUP)
+
+	for {
+		select {
+		case <-reloadChan:
+			reloadConfig(agent)
+		case sig := <-sigChan:
+			logger.Info("Receiver signal. Shutting down...", "signal", sig)
+			cancel()
+			wg.Wait()
+			shutdownTracing()
+			logger.Info("Agent shut down gracefully")
+			os.Exit(0)
+		case err := <-errChan:
+			logger.Error("Agent failed", "error", err)
+			cancel()
+			wg.Wait()
+			shutdownTracing()
+			logger.Info("Agent shut down with error")
+			os.Exit(1)
+		}
+	}
+}
+
+// Повторное чтение конфига по SIGHUP и применение нового количества воркеров
+func reloadConfig(a *agent.Agent) {
+	logger.Info("Received SIGHUP, reloading config")
+
+	cfg, err := config.LoadConfig("", "agent")
+	if err != nil {
+		logger.Error("Failed to reload config", "error", err)
+		return
+	}
+
+	// При автомасштабировании количество воркеров задаёт автомасштабирование в новых границах
+	if a.Autoscaling() {
+		if err := a.SetAutoscaleBounds(int(cfg.Agent.MinWorkers), int(cfg.Agent.MaxWorkers)); err != nil {
+			logger.Error("Failed to apply reloaded config", "error", err)
+			return
+		}
+
+		logger.Info("Config reloaded",
+			"min_workers", cfg.Agent.MinWorkers,
+			"max_workers", cfg.Agent.MaxWorkers)
+		return
+	}
+
+	if err := a.SetWorkers(int(cfg.Agent.ComputingPower)); err != nil {
+		logger.Error("Failed to apply reloaded config", "error", err)
+		return
+	}
+
+	logger.Info("Config reloaded", "computing_power", cfg.Agent.ComputingPower)
+}
+
+// Отправка накопленных спанов перед выходом
+func shutdownTracing() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := tracing.Shutdown(ctx); err != nil {
+		logger.Error("Failed to shut down tracing", "error", err)
 	}
 }
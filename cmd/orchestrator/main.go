@@ -2,26 +2,54 @@ package main
 
 import (
 	"context"
+	"final3/internal/auth"
 	"final3/internal/config"
 	"final3/internal/logger"
 	"final3/internal/orchestrator"
+	"final3/internal/tracing"
+	"flag"
+	"fmt"
 	"os"
 	"os/signal"
 	"sync"
 	"syscall"
+	"time"
 )
 
 func main() {
+	agentToken := flag.String("agent-token", "", "print token for the agent with this ID (from agent_secret) and exit")
+	flag.Parse()
+
 	cfg, err := config.LoadConfig("", "orchestrator")
 	if err != nil {
 		panic(err)
 	}
 
+	if *agentToken != "" {
+		if cfg.Orchestrator.AgentSecret == "" {
+			fmt.Fprintln(os.Stderr, "agent_secret is not configured")
+			os.Exit(1)
+		}
+		fmt.Println(auth.AgentToken(cfg.Orchestrator.AgentSecret, *agentToken))
+		return
+	}
+
 	if err := logger.Init(cfg); err != nil {
 		panic(err)
 	}
 
-	o := orchestrator.NewOrchestrator(cfg)
+	if err := logger.InitAudit(cfg); err != nil {
+		panic(err)
+	}
+
+	if err := tracing.Init(cfg, "orchestrator"); err != nil {
+		panic(err)
+	}
+
+	o, err := orchestrator.NewOrchestrator(cfg)
+	if err != nil {
+		panic(err)
+	}
 	errChan := make(chan error, 1)
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -45,13 +73,27 @@ func main() {
 		logger.Info("Receiver signal. Shutting down...", "signal", sig)
 		cancel()
 		wg.Wait()
+		shutdownTracing()
+		logger.CloseAudit()
 		logger.Info("Orchestrator shut down gracefully")
 		os.Exit(0)
 	case err := <-errChan:
 		logger.Error("Orchestrator failed", "error", err)
 		cancel()
 		wg.Wait()
+		shutdownTracing()
+		logger.CloseAudit()
 		logger.Info("Orchestrator shut down with error")
 		os.Exit(1)
 	}
 }
+
+// Отправка накопленных спанов перед выходом
+func shutdownTracing() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := tracing.Shutdown(ctx); err != nil {
+		logger.Error("Failed to shut down tracing", "error", err)
+	}
+}
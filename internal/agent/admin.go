@@ -0,0 +1,98 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"final3/internal/logger"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// Логер воркеров агента с отдельно настраиваемым уровнем
+func workerLog() *slog.Logger { return logger.Component(logger.ComponentWorker) }
+
+// Обработчики локального административного HTTP-сервера агента
+func (a *Agent) adminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/workers", a.WorkersHandler)
+	mux.HandleFunc("/log-level", logger.LevelHandler)
+	return mux
+}
+
+// Запуск вспомогательного HTTP-сервера агента до отмены ctx
+func serveHTTP(ctx context.Context, name, addr string, handler http.Handler) {
+	server := &http.Server{
+		Addr:    addr,
+		Handler: handler,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	logger.Info("Starting agent HTTP server", "server", name, "addr", addr)
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		logger.Error("Agent HTTP server error", "server", name, "error", err)
+	}
+}
+
+// Просмотр (GET) и изменение (PUT) количества воркеров агента
+func (a *Agent) WorkersHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Debug("Received workers admin request",
+		"remote_addr", r.RemoteAddr,
+		"method", r.Method)
+
+	type workersBody struct {
+		Workers int `json:"workers"`
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		if r.Header.Get("Content-Type") != "application/json" {
+			http.Error(w, "Wrong content-type, expected JSON", http.StatusUnprocessableEntity)
+			return
+		}
+		defer r.Body.Close()
+
+		var req workersBody
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+
+		if req.Workers <= 0 {
+			http.Error(w, "Workers count must be positive", http.StatusUnprocessableEntity)
+			return
+		}
+
+		if err := a.SetWorkers(req.Workers); err != nil {
+			logger.Warn("Failed to resize worker pool",
+				"workers", req.Workers,
+				"error", err)
+			status := http.StatusConflict
+			if err == errResizeUnsupported {
+				status = http.StatusNotImplemented
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+
+		logger.Info("Worker count changed via admin endpoint",
+			"workers", req.Workers,
+			"remote_addr", r.RemoteAddr)
+	default:
+		logger.Warn("Wrong method for workers admin endpoint",
+			"method", r.Method,
+			"remote_addr", r.RemoteAddr)
+		http.Error(w, "Wrong method, expected GET or PUT", http.StatusUnprocessableEntity)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(workersBody{Workers: a.Workers()})
+}
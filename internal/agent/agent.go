@@ -4,17 +4,56 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"final3/internal/config"
 	"final3/internal/logger"
+	"final3/internal/models"
+	"final3/internal/mtls"
+	"final3/internal/tracing"
+	"final3/internal/version"
 	"fmt"
 	"io"
 	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	agentIDHeader = "X-Agent-ID"
+	traceIDHeader = "X-Trace-ID" // Сквозной идентификатор выражения из задачи, передаётся оркестратору с результатом
+	backlogHeader = "X-Backlog"  // Количество готовых к выполнению задач у оркестратора
+
+	pollInterval             = 500 * time.Millisecond // Пауза между запросами при отсутствии задач
+	releaseTimeout           = 5 * time.Second        // Время на возврат задачи оркестратору при завершении работы
+	defaultAutoscaleInterval = 5 * time.Second
 )
 
 type Agent struct {
-	cfg    *config.AgentConfig
-	client *http.Client
+	id      string
+	cfg     *config.AgentConfig
+	client  *http.Client
+	dialer  *websocket.Dialer
+	retry   retryPolicy
+	breaker *circuitBreaker
+
+	executors *Registry
+
+	poolMu  sync.Mutex
+	pool    *workerPool
+	busy    atomic.Int64 // Количество воркеров, выполняющих задачу
+	backlog atomic.Int64 // Последнее известное количество готовых задач у оркестратора
+
+	minWorkers atomic.Int64 // Границы автомасштабирования
+	maxWorkers atomic.Int64
+
+	running   atomic.Bool // Агент запущен и не находится в режиме завершения
+	connected atomic.Bool // Последний запрос к оркестратору был успешным
+	metrics   *agentMetrics
 }
 
 // Создание нового агента с заданным конфигом
@@ -28,274 +67,517 @@ func NewAgent(cfg *config.AgentConfig) (*Agent, error) {
 		"orchestrator_url", cfg.OrchestratorURL,
 		"computing_power", cfg.ComputingPower)
 
-	return &Agent{
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "agent"
+	}
+
+	id := cfg.ID
+	if id == "" {
+		id = fmt.Sprintf("%s-%d", hostname, os.Getpid())
+	}
+
+	tlsConfig, err := mtls.ClientConfig(cfg.TLS)
+	if err != nil {
+		logger.Error("Failed to create agent", "error", err)
+		return nil, fmt.Errorf("tls: %w", err)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	dialer := *websocket.DefaultDialer
+	dialer.TLSClientConfig = tlsConfig
+
+	a := &Agent{
+		id:  id,
 		cfg: cfg,
 		client: &http.Client{
-			Timeout: 30 * time.Second,
+			Transport: transport,
+			Timeout:   30 * time.Second,
 		},
-	}, nil
+		dialer:    &dialer,
+		retry:     newRetryPolicy(cfg),
+		breaker:   newCircuitBreaker(cfg),
+		executors: DefaultRegistry,
+	}
+	a.minWorkers.Store(cfg.MinWorkers)
+	a.maxWorkers.Store(cfg.MaxWorkers)
+	a.metrics = newAgentMetrics(a)
+
+	return a, nil
 }
 
 // Запуск агента
+//
+// Ошибки связи с оркестратором не завершают работу агента: воркеры повторяют запросы
+// с экспоненциальной задержкой, а общий выключатель приостанавливает обращения к недоступному оркестратору.
+//
+// После отмены ctx агент переходит в режим завершения: новые задачи не запрашиваются, начатые
+// выполняются не дольше DrainTimeoutMS, а не успевшие завершиться возвращаются оркестратору
 func (a *Agent) Run(ctx context.Context) error {
 	logger.Info("Starting agent",
+		"version", version.Version,
 		"orchestrator_url", a.cfg.OrchestratorURL,
-		"computing_power", a.cfg.ComputingPower)
+		"computing_power", a.cfg.ComputingPower,
+		"transport", a.cfg.Transport)
 
-	workersErrChan := make(chan error, a.cfg.ComputingPower)
-	agentErrChan := make(chan error, 1)
+	workCtx, cancelWork := a.drainContext(ctx)
+	defer cancelWork()
 
-	ctx, cancel := context.WithCancel(ctx)
-	defer cancel()
+	a.running.Store(true)
+	stopRunning := context.AfterFunc(ctx, func() {
+		a.running.Store(false)
+	})
+	defer stopRunning()
 
-	for i := int64(0); i < a.cfg.ComputingPower; i++ {
-		logger.Debug("Starting worker", "worker_id", i)
-		go a.worker(ctx, workersErrChan, i)
+	if a.cfg.AdminAddr != "" {
+		go serveHTTP(ctx, "admin", a.cfg.AdminAddr, a.adminHandler())
 	}
 
-	go func() {
-		for err := range workersErrChan {
-			logger.Error("Worker error received", "error", err)
+	if a.cfg.StatusAddr != "" {
+		go serveHTTP(ctx, "status", a.cfg.StatusAddr, a.statusHandler())
+	}
 
-			select {
-			case agentErrChan <- err:
-				logger.Debug("Error forwarded to agent error channel")
-			default:
-				logger.Debug("Agent error channel full, dropping error")
-			}
-		}
-	}()
+	if a.cfg.Transport == config.TransportWebSocket {
+		return a.runWebSocket(ctx, workCtx)
+	}
+
+	pool := newWorkerPool(ctx, workCtx, a.worker)
+
+	size := a.cfg.ComputingPower
+	if a.cfg.Autoscale {
+		size = min(max(size, a.minWorkers.Load()), a.maxWorkers.Load())
+		go a.autoscale(ctx, pool)
+	}
+	pool.Resize(int(size))
+
+	a.poolMu.Lock()
+	a.pool = pool
+	a.poolMu.Unlock()
+
+	<-ctx.Done()
+	logger.Info("Received shutdown signal")
+	pool.Wait()
+
+	a.poolMu.Lock()
+	a.pool = nil
+	a.poolMu.Unlock()
+
+	logger.Info("Agent shut down complete")
+	return nil
+}
 
-	select {
-	case <-ctx.Done():
-		logger.Info("Received shutdown signal")
+// Контекст выполнения начатых задач: отменяется через DrainTimeoutMS после отмены ctx
+func (a *Agent) drainContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	workCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	drainTimeout := time.Duration(a.cfg.DrainTimeoutMS) * time.Millisecond
+
+	stop := context.AfterFunc(ctx, func() {
+		logger.Info("Draining agent, waiting for in-flight tasks",
+			"drain_timeout_ms", drainTimeout.Milliseconds())
+		time.AfterFunc(drainTimeout, cancel)
+	})
+
+	return workCtx, func() {
+		stop()
 		cancel()
-		logger.Info("Agent shut down complete")
-		return nil
-	case err := <-agentErrChan:
-		logger.Error("Agent error occurred", "error", err)
-		return err
 	}
 }
 
-type Task struct {
-	ID            int           `json:"id"`
-	Arg1          float64       `json:"arg1"`
-	Arg2          float64       `json:"arg2"`
-	Operation     string        `json:"operation"`
-	OperationTime time.Duration `json:"operation_time"`
+// Задача, получаемая от оркестратора
+type Task = models.Task
+
+var errNoTasks = errors.New("no tasks available")
+
+// Ошибочный HTTP-статус ответа оркестратора
+type statusError struct {
+	code int
+	body string
 }
 
-func (a *Agent) worker(ctx context.Context, errChan chan error, workerId int64) {
-	logger.Info("Worker started", "worker_id", workerId)
+func (e *statusError) Error() string {
+	return fmt.Sprintf("unexpected status code %d: %s", e.code, e.body)
+}
+
+// Результат отброшен оркестратором как устаревший: задача отменена или передана другому агенту
+func isDiscarded(err error) bool {
+	var se *statusError
+	return errors.As(err, &se) && (se.code == http.StatusNotFound || se.code == http.StatusConflict)
+}
 
+// Стоит ли повторять запрос после ошибки err
+func isRetryable(err error) bool {
+	var se *statusError
+	if errors.As(err, &se) {
+		return se.code >= http.StatusInternalServerError
+	}
+	return true
+}
+
+// Выполнение запроса op с повторами согласно политике агента
+//
+// - Возвращает ошибку, если она не подлежит повтору или контекст был отменён
+func (a *Agent) withRetry(ctx context.Context, b *backoff, op func() error) error {
 	for {
-		select {
-		case <-ctx.Done():
-			logger.Info("Worker stopping due to context done", "worker_id", workerId)
-			return
-		default:
-			logger.Debug("Worker requesting task", "worker_id", workerId)
-
-			req, err := http.NewRequest("GET", a.cfg.OrchestratorURL+"/internal/task", nil)
-			if err != nil {
-				logger.Error("Error creating request",
-					"worker_id", workerId,
-					"error", err)
-				errChan <- err
-				time.Sleep(time.Second)
-				continue
+		if !a.breaker.Allow() {
+			if !sleepContext(ctx, b.Next()) {
+				return ctx.Err()
 			}
+			continue
+		}
 
-			resp, err := a.client.Do(req)
-			if err != nil {
-				logger.Error("Error sending GET request",
-					"worker_id", workerId,
-					"url", a.cfg.OrchestratorURL+"/internal/task",
-					"error", err)
-				errChan <- err
-				time.Sleep(time.Second)
-				continue
-			}
+		err := op()
+		if err != nil && ctx.Err() != nil {
+			return ctx.Err()
+		}
 
-			if resp.StatusCode != http.StatusOK {
-				bodyBytes, _ := io.ReadAll(resp.Body)
-				resp.Body.Close()
-
-				if resp.StatusCode == http.StatusNotFound {
-					logger.Debug("No tasks available",
-						"worker_id", workerId,
-						"status_code", resp.StatusCode,
-						"response", string(bodyBytes))
-					time.Sleep(500 * time.Millisecond)
-					continue
-				}
-
-				logger.Warn("Non-OK status code from orchestrator",
-					"worker_id", workerId,
-					"status_code", resp.StatusCode,
-					"response", string(bodyBytes))
-				time.Sleep(500 * time.Millisecond)
-				continue
-			}
+		if err == nil || !isRetryable(err) {
+			a.breaker.Success()
+			a.connected.Store(true)
+			b.Reset()
+			return err
+		}
 
-			var task Task
-			if err := json.NewDecoder(resp.Body).Decode(&task); err != nil {
-				logger.Error("Error decoding task response",
-					"worker_id", workerId,
-					"error", err)
-				errChan <- err
-				resp.Body.Close()
-				continue
-			}
+		a.breaker.Failure()
+		a.connected.Store(false)
+		a.metrics.requestErrors.Inc()
+		delay := b.Next()
+		logger.Warn("Orchestrator request failed, retrying",
+			"error", err,
+			"retry_in_ms", delay.Milliseconds())
 
-			resp.Body.Close()
+		if !sleepContext(ctx, delay) {
+			return ctx.Err()
+		}
+	}
+}
 
-			logger.Info("Task received",
-				"worker_id", workerId,
-				"task_id", task.ID,
-				"arg1", task.Arg1,
-				"operation", task.Operation,
-				"arg2", task.Arg2,
-				"operation_time_ms", task.OperationTime.Milliseconds())
+// Идентификатор и токен агента для внутренних эндпоинтов оркестратора
+func (a *Agent) authorize(header http.Header) {
+	header.Set(agentIDHeader, a.id)
+	if a.cfg.Token != "" {
+		header.Set("Authorization", "Bearer "+a.cfg.Token)
+	}
+}
 
-			var result float64
-			var calcErr error
+// Получение задачи от оркестратора
+func (a *Agent) fetchTask(ctx context.Context) (*Task, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.cfg.OrchestratorURL+"/internal/task", nil)
+	if err != nil {
+		return nil, err
+	}
+	a.authorize(req.Header)
 
-			timer := time.NewTimer(task.OperationTime)
+	start := time.Now()
+	resp, err := a.client.Do(req)
+	a.metrics.fetchLatency.Observe(time.Since(start).Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
 
-			logger.Debug("Starting task calculation",
-				"worker_id", workerId,
-				"task_id", task.ID)
-
-			switch task.Operation {
-			case "+":
-				result = task.Arg1 + task.Arg2
-				logger.Debug("Addition performed",
-					"worker_id", workerId,
-					"task_id", task.ID,
-					"result", result)
-			case "-":
-				result = task.Arg1 - task.Arg2
-				logger.Debug("Subtraction performed",
-					"worker_id", workerId,
-					"task_id", task.ID,
-					"result", result)
-			case "*":
-				result = task.Arg1 * task.Arg2
-				logger.Debug("Multiplication performed",
-					"worker_id", workerId,
-					"task_id", task.ID,
-					"result", result)
-			case "/":
-				if task.Arg2 == 0 {
-					logger.Error("Division by zero",
-						"worker_id", workerId,
-						"task_id", task.ID)
-					calcErr = fmt.Errorf("division by zero")
-				} else {
-					result = task.Arg1 / task.Arg2
-					logger.Debug("Division performed",
-						"worker_id", workerId,
-						"task_id", task.ID,
-						"result", result)
-				}
-			default:
-				logger.Error("Unknown operation",
-					"worker_id", workerId,
-					"task_id", task.ID,
-					"operation", task.Operation)
-				calcErr = fmt.Errorf("unknown operation: %s", task.Operation)
-			}
+	if backlog, err := strconv.ParseInt(resp.Header.Get(backlogHeader), 10, 64); err == nil {
+		a.backlog.Store(backlog)
+	}
 
-			logger.Debug("Waiting for operation time to complete",
-				"worker_id", workerId,
-				"task_id", task.ID,
-				"wait_time_ms", task.OperationTime.Milliseconds())
-
-			select {
-			case <-ctx.Done():
-				logger.Info("Worker stopping during task execution", "worker_id", workerId)
-				return
-			case <-timer.C:
-				logger.Debug("Operation time completed",
-					"worker_id", workerId,
-					"task_id", task.ID)
-			}
+	if resp.StatusCode == http.StatusNotFound {
+		io.Copy(io.Discard, resp.Body)
+		return nil, errNoTasks
+	}
 
-			answer := struct {
-				ID     int     `json:"id"`
-				Result float64 `json:"result"`
-				Error  string  `json:"error,omitempty"`
-			}{
-				ID:     task.ID,
-				Result: result,
-			}
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, &statusError{code: resp.StatusCode, body: string(bodyBytes)}
+	}
 
-			if calcErr != nil {
-				answer.Error = calcErr.Error()
-				logger.Warn("Task calculation error",
-					"worker_id", workerId,
-					"task_id", task.ID,
-					"error", calcErr.Error())
-			}
+	var task Task
+	if err := json.NewDecoder(resp.Body).Decode(&task); err != nil {
+		return nil, &statusError{code: http.StatusBadGateway, body: err.Error()}
+	}
 
-			jsonAnswer, err := json.Marshal(answer)
-			if err != nil {
-				logger.Error("Error marshaling task result",
-					"worker_id", workerId,
-					"task_id", task.ID,
-					"error", err)
-				errChan <- err
-				continue
-			}
+	return &task, nil
+}
 
-			logger.Debug("Sending task result to orchestrator",
-				"worker_id", workerId,
-				"task_id", task.ID,
-				"result", result,
-				"error", answer.Error)
-
-			postReq, err := http.NewRequest("POST", a.cfg.OrchestratorURL+"/internal/task", bytes.NewReader(jsonAnswer))
-			if err != nil {
-				logger.Error("Error creating POST request for result",
-					"worker_id", workerId,
-					"task_id", task.ID,
-					"error", err)
-				errChan <- err
-				continue
-			}
-			postReq.Header.Set("Content-Type", "application/json")
-
-			postResp, err := a.client.Do(postReq)
-			if err != nil {
-				logger.Error("Error sending POST request with result",
-					"worker_id", workerId,
-					"task_id", task.ID,
-					"error", err)
-				errChan <- err
-				continue
-			}
+// Отправка результата задачи оркестратору
+func (a *Agent) sendResult(ctx context.Context, answer models.TaskResult) error {
+	jsonAnswer, err := json.Marshal(answer)
+	if err != nil {
+		return err
+	}
 
-			bodyBytes, _ := io.ReadAll(postResp.Body)
-			postResp.Body.Close()
-
-			if postResp.StatusCode != http.StatusOK {
-				logger.Error("POST response status not OK",
-					"worker_id", workerId,
-					"task_id", task.ID,
-					"status_code", postResp.StatusCode,
-					"response", string(bodyBytes))
-				errChan <- fmt.Errorf("post response status not OK: %d, body: %s", postResp.StatusCode, string(bodyBytes))
-				continue
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.cfg.OrchestratorURL+"/internal/task", bytes.NewReader(jsonAnswer))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	a.authorize(req.Header)
+	if traceID := logger.TraceIDFromContext(ctx); traceID != "" {
+		req.Header.Set(traceIDHeader, traceID)
+	}
+	if traceparent := tracing.Traceparent(tracing.SpanFromContext(ctx).SpanContext()); traceparent != "" {
+		req.Header.Set(tracing.TraceparentHeader, traceparent)
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	bodyBytes, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return &statusError{code: resp.StatusCode, body: string(bodyBytes)}
+	}
+
+	return nil
+}
+
+// Возврат невыполненной задачи оркестратору при завершении работы агента
+func (a *Agent) releaseTask(task Task) error {
+	ctx, cancel := context.WithTimeout(context.Background(), releaseTimeout)
+	defer cancel()
+
+	body, err := json.Marshal(struct {
+		ID           int   `json:"id"`
+		ExpressionID int32 `json:"expression_id"`
+	}{
+		ID:           task.ID,
+		ExpressionID: task.ExpressionID,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.cfg.OrchestratorURL+"/internal/task/release", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	a.authorize(req.Header)
+	if task.TraceID != "" {
+		req.Header.Set(traceIDHeader, task.TraceID)
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	bodyBytes, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return &statusError{code: resp.StatusCode, body: string(bodyBytes)}
+	}
+
+	return nil
+}
+
+// Контекст логов задачи: сквозной идентификатор выражения, идентификаторы выражения и задачи
+func taskContext(ctx context.Context, task Task) context.Context {
+	if task.TraceID != "" {
+		ctx = logger.ContextWithTraceID(ctx, task.TraceID)
+	}
+	ctx = logger.ContextWithExpressionID(ctx, task.ExpressionID)
+	return logger.ContextWithTaskID(ctx, task.ID)
+}
+
+// Спан выполнения задачи агентом, дочерний к спану выдачи задачи оркестратором
+func (a *Agent) startTaskSpan(ctx context.Context, workerId int64, task Task) (context.Context, *tracing.Span) {
+	if parent, err := tracing.ParseTraceparent(task.Traceparent); err == nil {
+		ctx = tracing.ContextWithRemoteSpanContext(ctx, parent)
+	}
+
+	return tracing.Start(ctx, "execute "+task.Operation,
+		tracing.WithAttributes(
+			"agent_id", a.id,
+			"worker_id", workerId,
+			"expression_id", task.ExpressionID,
+			"task_id", task.ID,
+			"operation", task.Operation))
+}
+
+// Воркер запрашивает задачи, пока не отменён ctx, и выполняет их, пока не отменён workCtx
+func (a *Agent) worker(ctx, workCtx context.Context, workerId int64) {
+	workerLog().Info("Worker started", "worker_id", workerId)
+	defer workerLog().Info("Worker stopping due to context done", "worker_id", workerId)
+
+	b := newBackoff(a.retry)
+
+	for ctx.Err() == nil {
+		workerLog().Debug("Worker requesting task", "worker_id", workerId)
+
+		var task *Task
+		err := a.withRetry(ctx, b, func() error {
+			var err error
+			// Запрос выполняется в workCtx, чтобы не потерять задачу, выданную в момент остановки
+			task, err = a.fetchTask(workCtx)
+			if err == errNoTasks {
+				return nil
 			}
+			return err
+		})
+		if task == nil && ctx.Err() != nil {
+			return
+		}
 
-			logger.Info("Task completed successfully",
+		if err != nil {
+			workerLog().Warn("Non-OK status code from orchestrator",
 				"worker_id", workerId,
-				"task_id", task.ID,
-				"result", result)
+				"error", err)
+			sleepContext(ctx, pollInterval)
+			continue
+		}
 
-			time.Sleep(100 * time.Millisecond)
+		if task == nil {
+			workerLog().Debug("No tasks available", "worker_id", workerId)
+			sleepContext(ctx, pollInterval)
+			continue
 		}
+
+		workerLog().InfoContext(taskContext(ctx, *task), "Task received",
+			"worker_id", workerId,
+			"task_id", task.ID,
+			"expression_id", task.ExpressionID,
+			"arg1", task.Arg1,
+			"operation", task.Operation,
+			"arg2", task.Arg2,
+			"operation_time_ms", task.OperationTime.Milliseconds())
+
+		a.busy.Add(1)
+		ok := a.handleTask(workCtx, b, workerId, *task)
+		a.busy.Add(-1)
+		if !ok {
+			return
+		}
+
+		sleepContext(ctx, 100*time.Millisecond)
+	}
+}
+
+// Выполнение задачи и отправка результата оркестратору
+//
+// - Возвращает false, если выполнение прервано по истечении времени на завершение работы
+func (a *Agent) handleTask(workCtx context.Context, b *backoff, workerId int64, task Task) bool {
+	workCtx, span := a.startTaskSpan(taskContext(workCtx, task), workerId, task)
+	defer span.End()
+
+	answer, ok := a.process(workCtx, workerId, task)
+	if !ok {
+		a.releaseOnShutdown(workCtx, workerId, task)
+		return false
+	}
+
+	workerLog().DebugContext(workCtx, "Sending task result to orchestrator",
+		"worker_id", workerId,
+		"task_id", task.ID,
+		"result", answer.Result,
+		"error", answer.Error)
+
+	err := a.withRetry(workCtx, b, func() error {
+		return a.sendResult(workCtx, answer)
+	})
+	if workCtx.Err() != nil {
+		a.releaseOnShutdown(workCtx, workerId, task)
+		return false
+	}
+
+	if isDiscarded(err) {
+		workerLog().InfoContext(workCtx, "Orchestrator discarded task result",
+			"worker_id", workerId,
+			"task_id", task.ID,
+			"error", err)
+		return true
+	}
+
+	if err != nil {
+		workerLog().ErrorContext(workCtx, "Orchestrator rejected task result",
+			"worker_id", workerId,
+			"task_id", task.ID,
+			"error", err)
+		return true
 	}
+
+	workerLog().InfoContext(workCtx, "Task completed successfully",
+		"worker_id", workerId,
+		"task_id", task.ID,
+		"result", answer.Result)
+	return true
+}
+
+// Возврат задачи, которую агент не выполнит из-за завершения работы
+func (a *Agent) releaseOnShutdown(ctx context.Context, workerId int64, task Task) {
+	if err := a.releaseTask(task); err != nil {
+		workerLog().ErrorContext(ctx, "Failed to release task",
+			"worker_id", workerId,
+			"task_id", task.ID,
+			"expression_id", task.ExpressionID,
+			"error", err)
+		return
+	}
+
+	workerLog().InfoContext(ctx, "Task released to orchestrator",
+		"worker_id", workerId,
+		"task_id", task.ID,
+		"expression_id", task.ExpressionID)
+}
+
+// Вычисление задачи исполнителем операции с имитацией времени выполнения
+//
+// - Возвращает результат и false, если выполнение было прервано через ctx
+func (a *Agent) process(ctx context.Context, workerId int64, task Task) (models.TaskResult, bool) {
+	answer := models.TaskResult{
+		ID:           task.ID,
+		ExpressionID: task.ExpressionID,
+	}
+
+	executor, ok := a.executors.Get(task.Operation)
+	if !ok {
+		workerLog().ErrorContext(ctx, "Unknown operation",
+			"worker_id", workerId,
+			"task_id", task.ID,
+			"operation", task.Operation)
+		// Операция может поддерживаться другими агентами, поэтому задачу можно повторить
+		answer.Error = fmt.Sprintf("unknown operation: %s", task.Operation)
+		answer.ErrorClass = models.ErrorTransient
+		a.metrics.taskErrors.WithLabelValues(task.Operation).Inc()
+		tracing.SpanFromContext(ctx).RecordError(errors.New(answer.Error))
+		return answer, true
+	}
+
+	workerLog().DebugContext(ctx, "Starting task calculation",
+		"worker_id", workerId,
+		"task_id", task.ID,
+		"wait_time_ms", task.OperationTime.Milliseconds())
+
+	result, err := WithDelay(executor, task.OperationTime).Execute(ctx, task.Arg1, task.Arg2)
+	if ctx.Err() != nil {
+		workerLog().InfoContext(ctx, "Task execution interrupted", "worker_id", workerId, "task_id", task.ID)
+		tracing.SpanFromContext(ctx).AddEvent("interrupted")
+		return models.TaskResult{}, false
+	}
+
+	if err != nil {
+		answer.Error = err.Error()
+		answer.ErrorClass = ErrorClassOf(err)
+		a.metrics.taskErrors.WithLabelValues(task.Operation).Inc()
+		tracing.SpanFromContext(ctx).RecordError(err)
+		workerLog().WarnContext(ctx, "Task calculation error",
+			"worker_id", workerId,
+			"task_id", task.ID,
+			"error", err.Error(),
+			"error_class", answer.ErrorClass)
+		return answer, true
+	}
+
+	answer.Result = result
+	a.metrics.tasksCompleted.WithLabelValues(task.Operation).Inc()
+	tracing.SpanFromContext(ctx).SetAttribute("result", result)
+	workerLog().DebugContext(ctx, "Operation performed",
+		"worker_id", workerId,
+		"task_id", task.ID,
+		"operation", task.Operation,
+		"result", result)
+
+	return answer, true
 }
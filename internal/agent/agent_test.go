@@ -3,12 +3,24 @@ package agent
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"final3/internal/config"
+	"final3/internal/models"
+	"final3/internal/tracing"
+	"fmt"
+	"math"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 func TestAgent(t *testing.T) {
@@ -100,3 +112,955 @@ func TestAgent(t *testing.T) {
 		t.Errorf("Expected 5 tasks to be processed, got %d", completedTaskCount)
 	}
 }
+
+func TestAgentWebSocket(t *testing.T) {
+	results := make(chan models.TaskResult, 3)
+
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/internal/ws" {
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+
+		if r.URL.Query().Get("capacity") != "2" {
+			t.Errorf("Expected capacity 2, got %q", r.URL.Query().Get("capacity"))
+		}
+
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("Failed to upgrade: %v", err)
+			return
+		}
+		defer conn.Close()
+
+		for i := 1; i <= 3; i++ {
+			task := Task{
+				ID:            i,
+				ExpressionID:  7,
+				Arg1:          float64(i),
+				Arg2:          3.0,
+				Operation:     "*",
+				OperationTime: 10 * time.Millisecond,
+			}
+			if err := conn.WriteJSON(task); err != nil {
+				t.Errorf("Failed to send task: %v", err)
+				return
+			}
+		}
+
+		for {
+			var result models.TaskResult
+			if err := conn.ReadJSON(&result); err != nil {
+				return
+			}
+			results <- result
+		}
+	}))
+	defer server.Close()
+
+	cfg := &config.AgentConfig{
+		OrchestratorURL: server.URL,
+		ComputingPower:  2,
+		Transport:       config.TransportWebSocket,
+	}
+
+	agent, err := NewAgent(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create agent: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- agent.Run(ctx)
+	}()
+
+	for i := 0; i < 3; i++ {
+		select {
+		case result := <-results:
+			if result.ExpressionID != 7 {
+				t.Errorf("Task %d: expected expression ID 7, got %d", result.ID, result.ExpressionID)
+			}
+			if expected := float64(result.ID) * 3.0; result.Result != expected {
+				t.Errorf("Task %d: expected result %f, got %f", result.ID, expected, result.Result)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Timed out waiting for result %d", i+1)
+		}
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Agent.Run returned unexpected error: %v", err)
+	}
+}
+
+func TestAgentWebSocketReleasesWhileDraining(t *testing.T) {
+	released := make(chan int, 2)
+	received := make(chan struct{})
+
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/internal/task/release" {
+			var task Task
+			if err := json.NewDecoder(r.Body).Decode(&task); err != nil {
+				t.Errorf("Failed to decode released task: %v", err)
+			}
+			released <- task.ID
+			return
+		}
+
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("Failed to upgrade: %v", err)
+			return
+		}
+		defer conn.Close()
+
+		// Вторая задача ждёт свободного воркера, пока первая выполняется
+		for i := 1; i <= 2; i++ {
+			conn.WriteJSON(Task{ID: i, ExpressionID: 1, Arg1: 1, Arg2: 1, Operation: "+", OperationTime: 5 * time.Second})
+		}
+		close(received)
+
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer server.Close()
+
+	agent, err := NewAgent(&config.AgentConfig{
+		OrchestratorURL: server.URL,
+		ComputingPower:  1,
+		Transport:       config.TransportWebSocket,
+		DrainTimeoutMS:  50,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create agent: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- agent.Run(ctx)
+	}()
+
+	<-received
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	select {
+	case id := <-released:
+		if id != 2 {
+			t.Errorf("Expected task 2 to be released, got %d", id)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Task received while draining was not released")
+	}
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Agent did not shut down")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	b := newBackoff(retryPolicy{
+		initial:    10 * time.Millisecond,
+		max:        50 * time.Millisecond,
+		multiplier: 2,
+	})
+
+	expected := []time.Duration{10, 20, 40, 50, 50}
+	for i, want := range expected {
+		if got := b.Next(); got != want*time.Millisecond {
+			t.Errorf("Attempt %d: expected delay %v, got %v", i+1, want*time.Millisecond, got)
+		}
+	}
+
+	b.Reset()
+	if got := b.Next(); got != 10*time.Millisecond {
+		t.Errorf("Expected delay to reset to 10ms, got %v", got)
+	}
+
+	jittered := newBackoff(retryPolicy{
+		initial:    100 * time.Millisecond,
+		max:        time.Second,
+		multiplier: 2,
+		jitter:     0.5,
+	})
+	for i := 0; i < 20; i++ {
+		jittered.Reset()
+		if got := jittered.Next(); got < 50*time.Millisecond || got > 150*time.Millisecond {
+			t.Errorf("Jittered delay %v out of range", got)
+		}
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	cb := newCircuitBreaker(&config.AgentConfig{
+		BreakerFailureThreshold: 2,
+		BreakerOpenTimeoutMS:    50,
+	})
+
+	cb.Failure()
+	if !cb.Allow() {
+		t.Fatal("Breaker should stay closed below threshold")
+	}
+
+	cb.Failure()
+	if cb.Allow() {
+		t.Fatal("Breaker should be open after reaching threshold")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if !cb.Allow() {
+		t.Fatal("Breaker should allow a probe after open timeout")
+	}
+	if cb.Allow() {
+		t.Fatal("Breaker should allow only one probe while half-open")
+	}
+
+	cb.Failure()
+	if cb.Allow() {
+		t.Fatal("Breaker should reopen after failed probe")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	cb.Allow()
+	cb.Success()
+	if !cb.Allow() || !cb.Allow() {
+		t.Fatal("Breaker should be closed after successful probe")
+	}
+}
+
+func TestAgentRidesOutOrchestratorOutage(t *testing.T) {
+	var mu sync.Mutex
+	failures := 0
+	completed := false
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if failures < 3 {
+			failures++
+			http.Error(w, "Orchestrator restarting", http.StatusServiceUnavailable)
+			return
+		}
+
+		if r.Method == http.MethodGet {
+			if completed {
+				http.Error(w, "No tasks available", http.StatusNotFound)
+				return
+			}
+			json.NewEncoder(w).Encode(Task{ID: 1, Arg1: 1, Arg2: 2, Operation: "+"})
+			return
+		}
+
+		completed = true
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	agent, err := NewAgent(&config.AgentConfig{
+		OrchestratorURL:         server.URL,
+		ComputingPower:          1,
+		RetryInitialIntervalMS:  10,
+		RetryMaxIntervalMS:      20,
+		BreakerFailureThreshold: 2,
+		BreakerOpenTimeoutMS:    20,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create agent: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	if err := agent.Run(ctx); err != nil {
+		t.Errorf("Agent.Run returned unexpected error: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if !completed {
+		t.Error("Expected task to be completed after orchestrator recovered")
+	}
+}
+
+func TestAgentDrain(t *testing.T) {
+	tests := []struct {
+		name          string
+		operationTime time.Duration
+		drainTimeout  int64
+		expectResult  bool
+		expectRelease bool
+	}{
+		{
+			name:          "FinishesInFlightTask",
+			operationTime: 100 * time.Millisecond,
+			drainTimeout:  1000,
+			expectResult:  true,
+		},
+		{
+			name:          "ReleasesUnfinishedTask",
+			operationTime: 5 * time.Second,
+			drainTimeout:  50,
+			expectRelease: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			fetched := make(chan struct{})
+			gotResult := false
+			gotRelease := false
+			served := false
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+
+				switch {
+				case r.URL.Path == "/internal/task" && r.Method == http.MethodGet:
+					if served {
+						http.Error(w, "No tasks available", http.StatusNotFound)
+						return
+					}
+					served = true
+					json.NewEncoder(w).Encode(Task{ID: 1, ExpressionID: 1, Arg1: 1, Arg2: 1, Operation: "+", OperationTime: tt.operationTime})
+					close(fetched)
+				case r.URL.Path == "/internal/task" && r.Method == http.MethodPost:
+					gotResult = true
+				case r.URL.Path == "/internal/task/release":
+					if r.Header.Get(agentIDHeader) == "" {
+						t.Error("Release request without agent ID")
+					}
+					gotRelease = true
+				}
+			}))
+			defer server.Close()
+
+			agent, err := NewAgent(&config.AgentConfig{
+				OrchestratorURL: server.URL,
+				ComputingPower:  1,
+				DrainTimeoutMS:  tt.drainTimeout,
+			})
+			if err != nil {
+				t.Fatalf("Failed to create agent: %v", err)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan error, 1)
+			go func() {
+				done <- agent.Run(ctx)
+			}()
+
+			select {
+			case <-fetched:
+			case <-time.After(time.Second):
+				t.Fatal("Agent did not fetch a task")
+			}
+			cancel()
+
+			select {
+			case err := <-done:
+				if err != nil {
+					t.Errorf("Agent.Run returned unexpected error: %v", err)
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatal("Agent did not shut down within drain timeout")
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if gotResult != tt.expectResult {
+				t.Errorf("Expected result sent: %v, got %v", tt.expectResult, gotResult)
+			}
+			if gotRelease != tt.expectRelease {
+				t.Errorf("Expected release sent: %v, got %v", tt.expectRelease, gotRelease)
+			}
+		})
+	}
+}
+
+func TestWorkerPoolResize(t *testing.T) {
+	var running atomic.Int64
+
+	ctx, cancel := context.WithCancel(context.Background())
+	pool := newWorkerPool(ctx, context.Background(), func(ctx, workCtx context.Context, workerId int64) {
+		running.Add(1)
+		defer running.Add(-1)
+		<-ctx.Done()
+	})
+
+	waitRunning := func(expected int64) {
+		t.Helper()
+		deadline := time.Now().Add(time.Second)
+		for running.Load() != expected && time.Now().Before(deadline) {
+			time.Sleep(5 * time.Millisecond)
+		}
+		if got := running.Load(); got != expected {
+			t.Fatalf("Expected %d running workers, got %d", expected, got)
+		}
+	}
+
+	pool.Resize(3)
+	waitRunning(3)
+
+	if old := pool.Resize(1); old != 3 {
+		t.Errorf("Expected previous size 3, got %d", old)
+	}
+	waitRunning(1)
+
+	pool.Resize(4)
+	waitRunning(4)
+
+	cancel()
+	pool.Wait()
+	waitRunning(0)
+}
+
+func TestWorkersHandler(t *testing.T) {
+	agent, err := NewAgent(&config.AgentConfig{
+		OrchestratorURL: "http://localhost:0",
+		ComputingPower:  2,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create agent: %v", err)
+	}
+
+	put := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/workers", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		agent.WorkersHandler(rec, req)
+		return rec
+	}
+
+	if rec := put(`{"workers": 3}`); rec.Code != http.StatusConflict {
+		t.Errorf("Expected status %d before agent is running, got %d", http.StatusConflict, rec.Code)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		agent.Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for agent.SetWorkers(2) != nil && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if rec := put(`{"workers": 0}`); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected status %d for zero workers, got %d", http.StatusUnprocessableEntity, rec.Code)
+	}
+
+	rec := put(`{"workers": 5}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+
+	var resp struct {
+		Workers int `json:"workers"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if resp.Workers != 5 || agent.Workers() != 5 {
+		t.Errorf("Expected 5 workers, got %d (response %d)", agent.Workers(), resp.Workers)
+	}
+}
+
+func TestWorkersHandlerWebSocket(t *testing.T) {
+	agent, err := NewAgent(&config.AgentConfig{
+		OrchestratorURL: "http://localhost:0",
+		ComputingPower:  2,
+		Transport:       config.TransportWebSocket,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create agent: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPut, "/workers", strings.NewReader(`{"workers": 3}`))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	agent.WorkersHandler(rec, req)
+
+	if rec.Code != http.StatusNotImplemented {
+		t.Errorf("Expected status %d, got %d", http.StatusNotImplemented, rec.Code)
+	}
+	if agent.Workers() != 2 {
+		t.Errorf("Expected workers count to stay 2, got %d", agent.Workers())
+	}
+}
+
+func TestAgentAutoscale(t *testing.T) {
+	var mu sync.Mutex
+	backlog := 10
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		w.Header().Set(backlogHeader, strconv.Itoa(backlog))
+		if r.Method == http.MethodGet && backlog > 0 {
+			json.NewEncoder(w).Encode(Task{ID: backlog, Arg1: 1, Arg2: 1, Operation: "+", OperationTime: 50 * time.Millisecond})
+			return
+		}
+		if r.Method == http.MethodGet {
+			http.Error(w, "No tasks available", http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	agent, err := NewAgent(&config.AgentConfig{
+		OrchestratorURL:     server.URL,
+		ComputingPower:      1,
+		Autoscale:           true,
+		MinWorkers:          1,
+		MaxWorkers:          4,
+		AutoscaleIntervalMS: 20,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create agent: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		agent.Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	waitWorkers := func(expected int) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for agent.Workers() != expected && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if got := agent.Workers(); got != expected {
+			t.Fatalf("Expected %d workers, got %d", expected, got)
+		}
+	}
+
+	waitWorkers(4)
+
+	if err := agent.SetAutoscaleBounds(1, 2); err != nil {
+		t.Fatalf("Failed to set autoscale bounds: %v", err)
+	}
+	if got := agent.Workers(); got != 2 {
+		t.Errorf("Expected workers to be clamped to 2, got %d", got)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if got := agent.Workers(); got != 2 {
+		t.Errorf("Expected autoscale to respect new bounds, got %d workers", got)
+	}
+
+	mu.Lock()
+	backlog = 0
+	mu.Unlock()
+
+	waitWorkers(1)
+}
+
+func TestDefaultExecutors(t *testing.T) {
+	tests := []struct {
+		operation   string
+		arg1, arg2  float64
+		expected    float64
+		expectError error
+	}{
+		{operation: "+", arg1: 2, arg2: 3, expected: 5},
+		{operation: "-", arg1: 2, arg2: 3, expected: -1},
+		{operation: "*", arg1: 2, arg2: 3, expected: 6},
+		{operation: "/", arg1: 3, arg2: 2, expected: 1.5},
+		{operation: "/", arg1: 3, arg2: 0, expectError: ErrDivisionByZero},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.operation, func(t *testing.T) {
+			executor, ok := DefaultRegistry.Get(tt.operation)
+			if !ok {
+				t.Fatalf("Executor for %q is not registered", tt.operation)
+			}
+
+			result, err := executor.Execute(context.Background(), tt.arg1, tt.arg2)
+			if err != tt.expectError {
+				t.Fatalf("Expected error %v, got %v", tt.expectError, err)
+			}
+			if err == nil && result != tt.expected {
+				t.Errorf("Expected result %f, got %f", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	registry := NewRegistry()
+	pow := ExecutorFunc(func(_ context.Context, arg1, arg2 float64) (float64, error) {
+		return math.Pow(arg1, arg2), nil
+	})
+
+	if err := registry.Register("^", pow); err != nil {
+		t.Fatalf("Failed to register executor: %v", err)
+	}
+
+	if err := registry.Register("^", pow); err == nil {
+		t.Error("Expected error on duplicate registration")
+	}
+
+	if ops := registry.Operations(); len(ops) != 1 || ops[0] != "^" {
+		t.Errorf("Unexpected operations list: %v", ops)
+	}
+
+	agent, err := NewAgent(&config.AgentConfig{OrchestratorURL: "http://localhost:0", ComputingPower: 1})
+	if err != nil {
+		t.Fatalf("Failed to create agent: %v", err)
+	}
+	agent.executors = registry
+
+	answer, ok := agent.process(context.Background(), 0, Task{ID: 1, Arg1: 2, Arg2: 10, Operation: "^"})
+	if !ok || answer.Error != "" || answer.Result != 1024 {
+		t.Errorf("Unexpected answer for custom operation: %+v", answer)
+	}
+
+	answer, ok = agent.process(context.Background(), 0, Task{ID: 2, Arg1: 2, Arg2: 10, Operation: "+"})
+	if !ok || answer.Error == "" {
+		t.Errorf("Expected error for unregistered operation, got %+v", answer)
+	}
+}
+
+func TestWithDelay(t *testing.T) {
+	executor, _ := DefaultRegistry.Get("+")
+
+	start := time.Now()
+	result, err := WithDelay(executor, 50*time.Millisecond).Execute(context.Background(), 1, 2)
+	if err != nil || result != 3 {
+		t.Fatalf("Unexpected result %f, error %v", result, err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("Expected delay of at least 50ms, got %v", elapsed)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := WithDelay(executor, time.Second).Execute(ctx, 1, 2); err != context.DeadlineExceeded {
+		t.Errorf("Expected %v, got %v", context.DeadlineExceeded, err)
+	}
+}
+
+func TestStatusHandler(t *testing.T) {
+	var fail atomic.Bool
+	var served atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail.Load() {
+			http.Error(w, "Unavailable", http.StatusServiceUnavailable)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			if served.Add(1) > 1 {
+				http.Error(w, "No tasks available", http.StatusNotFound)
+				return
+			}
+			json.NewEncoder(w).Encode(Task{ID: 1, ExpressionID: 1, Arg1: 1, Arg2: 0, Operation: "/"})
+		case http.MethodPost:
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer server.Close()
+
+	agent, err := NewAgent(&config.AgentConfig{
+		OrchestratorURL:        server.URL,
+		ComputingPower:         1,
+		RetryInitialIntervalMS: 10,
+		RetryMaxIntervalMS:     20,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create agent: %v", err)
+	}
+	handler := agent.statusHandler()
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	if rec := get("/healthz"); rec.Code != http.StatusOK {
+		t.Errorf("Expected /healthz status %d, got %d", http.StatusOK, rec.Code)
+	}
+	if rec := get("/readyz"); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected /readyz status %d before start, got %d", http.StatusServiceUnavailable, rec.Code)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		agent.Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	waitFor := func(what string, cond func() bool) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for !cond() {
+			if time.Now().After(deadline) {
+				t.Fatalf("Timed out waiting for %s", what)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	waitFor("agent readiness", func() bool { return get("/readyz").Code == http.StatusOK })
+	waitFor("task error metric", func() bool {
+		return strings.Contains(get("/metrics").Body.String(), `agent_task_errors_total{operation="/"} 1`)
+	})
+	// Ошибка учитывается до отправки результата, а задача перестаёт выполняться только после неё
+	waitFor("task completion", func() bool {
+		return strings.Contains(get("/metrics").Body.String(), "agent_tasks_in_flight 0\n")
+	})
+
+	body := get("/metrics").Body.String()
+	for _, want := range []string{
+		"agent_orchestrator_up 1",
+		"agent_fetch_duration_seconds_count",
+		"agent_circuit_breaker_open 0",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected metrics to contain %q", want)
+		}
+	}
+
+	fail.Store(true)
+	waitFor("orchestrator outage", func() bool { return get("/readyz").Code == http.StatusServiceUnavailable })
+	if body := get("/metrics").Body.String(); !strings.Contains(body, "agent_orchestrator_up 0") {
+		t.Errorf("Expected orchestrator to be reported down")
+	}
+}
+
+func TestAgentForwardsTraceID(t *testing.T) {
+	var served atomic.Bool
+	traceIDs := make(chan string, 1)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			if served.Swap(true) {
+				http.Error(w, "No tasks available", http.StatusNotFound)
+				return
+			}
+			json.NewEncoder(w).Encode(Task{ID: 1, ExpressionID: 7, Arg1: 1, Arg2: 2, Operation: "+", TraceID: "trace-7"})
+		case http.MethodPost:
+			traceIDs <- r.Header.Get(traceIDHeader)
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer server.Close()
+
+	agent, err := NewAgent(&config.AgentConfig{
+		OrchestratorURL: server.URL,
+		ComputingPower:  1,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create agent: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		agent.Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	select {
+	case got := <-traceIDs:
+		if got != "trace-7" {
+			t.Errorf("Expected trace ID %q on result, got %q", "trace-7", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for task result")
+	}
+}
+
+// Экспортёр в память, сохраняющий спаны после остановки трассировщика
+type memoryExporter struct {
+	*tracetest.InMemoryExporter
+}
+
+func (memoryExporter) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+func TestAgentTracing(t *testing.T) {
+	exporter := memoryExporter{tracetest.NewInMemoryExporter()}
+	tracer := tracing.NewTracer("agent", exporter)
+	tracing.SetTracer(tracer)
+	defer tracing.SetTracer(tracing.NewTracer("", nil))
+
+	const parent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	var served atomic.Bool
+	traceparents := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			if served.Swap(true) {
+				http.Error(w, "No tasks available", http.StatusNotFound)
+				return
+			}
+			json.NewEncoder(w).Encode(Task{ID: 1, ExpressionID: 1, Arg1: 1, Arg2: 2, Operation: "+", Traceparent: parent})
+		case http.MethodPost:
+			traceparents <- r.Header.Get(tracing.TraceparentHeader)
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer server.Close()
+
+	agent, err := NewAgent(&config.AgentConfig{
+		OrchestratorURL: server.URL,
+		ComputingPower:  1,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create agent: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		agent.Run(ctx)
+	}()
+
+	var got string
+	select {
+	case got = <-traceparents:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for task result")
+	}
+	cancel()
+	<-done
+
+	sc, err := tracing.ParseTraceparent(got)
+	if err != nil {
+		t.Fatalf("Invalid traceparent on result %q: %v", got, err)
+	}
+	if sc.TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" || sc.SpanID().String() == "00f067aa0ba902b7" {
+		t.Errorf("Expected agent span in the task trace, got %q", got)
+	}
+
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Failed to shut down tracer: %v", err)
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("Expected 1 span, got %d", len(spans))
+	}
+	span := spans[0]
+	if span.Name != "execute +" || span.Parent.SpanID().String() != "00f067aa0ba902b7" || span.SpanContext.SpanID() != sc.SpanID() {
+		t.Errorf("Unexpected agent span: %+v", span)
+	}
+	attrs := attribute.NewSet(span.Attributes...)
+	if result, _ := attrs.Value("result"); result.AsFloat64() != 3 {
+		t.Errorf("Expected result attribute 3, got %v", result.Emit())
+	}
+}
+
+func TestAgentAuthorization(t *testing.T) {
+	type credentials struct {
+		agentID       string
+		authorization string
+	}
+	requests := make(chan credentials, 2)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case requests <- credentials{r.Header.Get(agentIDHeader), r.Header.Get("Authorization")}:
+		default:
+		}
+		http.Error(w, "No tasks available", http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	agent, err := NewAgent(&config.AgentConfig{
+		OrchestratorURL: server.URL,
+		ComputingPower:  1,
+		ID:              "agent-1",
+		Token:           "agent-token",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create agent: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		agent.Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	select {
+	case got := <-requests:
+		want := credentials{"agent-1", "Bearer agent-token"}
+		if got != want {
+			t.Errorf("Expected credentials %+v, got %+v", want, got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for task request")
+	}
+}
+
+func TestErrorClassOf(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected models.ErrorClass
+	}{
+		{"DivisionByZero", ErrDivisionByZero, models.ErrorMath},
+		{"Transient", Transient(errors.New("device busy")), models.ErrorTransient},
+		{"WrappedTransient", fmt.Errorf("executor: %w", Transient(errors.New("device busy"))), models.ErrorTransient},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ErrorClassOf(tt.err); got != tt.expected {
+				t.Errorf("Expected class %s, got %s", tt.expected, got)
+			}
+		})
+	}
+
+	if Transient(nil) != nil {
+		t.Error("Expected Transient(nil) to be nil")
+	}
+}
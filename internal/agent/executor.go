@@ -0,0 +1,154 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"final3/internal/models"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+var ErrDivisionByZero = errors.New("division by zero")
+
+// Ошибка исполнителя, вызванная временным сбоем, а не аргументами операции:
+// оркестратор повторит такую задачу на другом агенте
+type TransientError struct {
+	Err error
+}
+
+func (e *TransientError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *TransientError) Unwrap() error {
+	return e.Err
+}
+
+// Пометка ошибки исполнителя как временного сбоя
+func Transient(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &TransientError{Err: err}
+}
+
+// Класс ошибки исполнителя: ошибки, помеченные Transient, - временные сбои, остальные - ошибки вычисления
+func ErrorClassOf(err error) models.ErrorClass {
+	var transient *TransientError
+	if errors.As(err, &transient) {
+		return models.ErrorTransient
+	}
+	return models.ErrorMath
+}
+
+// Исполнитель арифметической операции над двумя аргументами
+type Executor interface {
+	Execute(ctx context.Context, arg1, arg2 float64) (float64, error)
+}
+
+// Функция, реализующая Executor
+type ExecutorFunc func(ctx context.Context, arg1, arg2 float64) (float64, error)
+
+func (f ExecutorFunc) Execute(ctx context.Context, arg1, arg2 float64) (float64, error) {
+	return f(ctx, arg1, arg2)
+}
+
+// Реестр исполнителей по названию операции
+type Registry struct {
+	mu        sync.RWMutex
+	executors map[string]Executor
+}
+
+// Создание пустого реестра
+func NewRegistry() *Registry {
+	return &Registry{
+		executors: make(map[string]Executor),
+	}
+}
+
+// Регистрация исполнителя операции. Повторная регистрация той же операции - ошибка
+func (r *Registry) Register(operation string, executor Executor) error {
+	if operation == "" || executor == nil {
+		return fmt.Errorf("operation name and executor required")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.executors[operation]; ok {
+		return fmt.Errorf("executor for operation %q already registered", operation)
+	}
+
+	r.executors[operation] = executor
+	return nil
+}
+
+// Получение исполнителя операции
+func (r *Registry) Get(operation string) (Executor, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	executor, ok := r.executors[operation]
+	return executor, ok
+}
+
+// Список зарегистрированных операций
+func (r *Registry) Operations() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	operations := make([]string, 0, len(r.executors))
+	for operation := range r.executors {
+		operations = append(operations, operation)
+	}
+	sort.Strings(operations)
+	return operations
+}
+
+// Реестр, используемый агентами. Дополнительные операции можно зарегистрировать
+// в init() собственного пакета через Register
+var DefaultRegistry = NewRegistry()
+
+// Регистрация исполнителя в DefaultRegistry
+func Register(operation string, executor Executor) error {
+	return DefaultRegistry.Register(operation, executor)
+}
+
+func init() {
+	Register("+", ExecutorFunc(func(_ context.Context, arg1, arg2 float64) (float64, error) {
+		return arg1 + arg2, nil
+	}))
+	Register("-", ExecutorFunc(func(_ context.Context, arg1, arg2 float64) (float64, error) {
+		return arg1 - arg2, nil
+	}))
+	Register("*", ExecutorFunc(func(_ context.Context, arg1, arg2 float64) (float64, error) {
+		return arg1 * arg2, nil
+	}))
+	Register("/", ExecutorFunc(func(_ context.Context, arg1, arg2 float64) (float64, error) {
+		if arg2 == 0 {
+			return 0, ErrDivisionByZero
+		}
+		return arg1 / arg2, nil
+	}))
+}
+
+// Обёртка, имитирующая время выполнения операции: результат возвращается не раньше, чем через delay
+// после начала вычисления. При отмене ctx возвращается ctx.Err()
+func WithDelay(executor Executor, delay time.Duration) Executor {
+	return ExecutorFunc(func(ctx context.Context, arg1, arg2 float64) (float64, error) {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+
+		result, err := executor.Execute(ctx, arg1, arg2)
+
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-timer.C:
+		}
+
+		return result, err
+	})
+}
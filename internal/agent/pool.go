@@ -0,0 +1,189 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"final3/internal/config"
+	"final3/internal/logger"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	errPoolNotRunning    = errors.New("worker pool is not running")
+	errResizeUnsupported = errors.New("workers count cannot be changed with websocket transport")
+	errAutoscaleDisabled = errors.New("autoscale is disabled")
+)
+
+// Пул воркеров с изменяемым во время работы размером
+type workerPool struct {
+	mu      sync.Mutex
+	ctx     context.Context
+	workCtx context.Context
+	run     func(ctx, workCtx context.Context, workerId int64)
+	stops   []context.CancelFunc
+	nextID  int64
+	wg      sync.WaitGroup
+}
+
+// Создание пула: воркеры останавливаются при отмене ctx, начатые задачи выполняются в workCtx
+func newWorkerPool(ctx, workCtx context.Context, run func(ctx, workCtx context.Context, workerId int64)) *workerPool {
+	return &workerPool{
+		ctx:     ctx,
+		workCtx: workCtx,
+		run:     run,
+	}
+}
+
+// Изменение количества воркеров. Лишние воркеры перестают запрашивать задачи и
+// завершаются после выполнения текущей
+//
+// - Возвращает предыдущее количество воркеров
+func (p *workerPool) Resize(n int) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	old := len(p.stops)
+	if p.ctx.Err() != nil {
+		return old
+	}
+
+	for len(p.stops) < n {
+		workerCtx, stop := context.WithCancel(p.ctx)
+		workerId := p.nextID
+		p.nextID++
+		p.stops = append(p.stops, stop)
+
+		logger.Debug("Starting worker", "worker_id", workerId)
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			p.run(workerCtx, p.workCtx, workerId)
+		}()
+	}
+
+	for len(p.stops) > n {
+		last := len(p.stops) - 1
+		p.stops[last]()
+		p.stops = p.stops[:last]
+	}
+
+	if old != n {
+		logger.Info("Worker pool resized", "old_size", old, "new_size", n)
+	}
+
+	return old
+}
+
+// Текущее количество воркеров
+func (p *workerPool) Size() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return len(p.stops)
+}
+
+// Ожидание завершения всех воркеров
+func (p *workerPool) Wait() {
+	p.wg.Wait()
+}
+
+// Количество воркеров агента
+func (a *Agent) Workers() int {
+	a.poolMu.Lock()
+	defer a.poolMu.Unlock()
+
+	if a.pool == nil {
+		return int(a.cfg.ComputingPower)
+	}
+	return a.pool.Size()
+}
+
+// Изменение количества воркеров работающего агента. С транспортом websocket не поддерживается:
+// число одновременных задач передаётся оркестратору при подключении
+func (a *Agent) SetWorkers(n int) error {
+	if n <= 0 {
+		return errors.New("workers count must be positive")
+	}
+	if a.cfg.Transport == config.TransportWebSocket {
+		return errResizeUnsupported
+	}
+
+	a.poolMu.Lock()
+	defer a.poolMu.Unlock()
+
+	if a.pool == nil {
+		return errPoolNotRunning
+	}
+
+	a.pool.Resize(n)
+	return nil
+}
+
+// Агент сам подбирает количество воркеров
+func (a *Agent) Autoscaling() bool {
+	return a.cfg.Autoscale
+}
+
+// Изменение границ автомасштабирования; текущее количество воркеров приводится к новым границам
+func (a *Agent) SetAutoscaleBounds(minWorkers, maxWorkers int) error {
+	if !a.cfg.Autoscale {
+		return errAutoscaleDisabled
+	}
+	if minWorkers <= 0 || maxWorkers < minWorkers {
+		return fmt.Errorf("invalid autoscale bounds: min %d, max %d", minWorkers, maxWorkers)
+	}
+
+	a.poolMu.Lock()
+	defer a.poolMu.Unlock()
+
+	a.minWorkers.Store(int64(minWorkers))
+	a.maxWorkers.Store(int64(maxWorkers))
+
+	if a.pool != nil {
+		a.pool.Resize(min(max(a.pool.Size(), minWorkers), maxWorkers))
+	}
+	return nil
+}
+
+// Автоматическое масштабирование: пул растёт, пока у оркестратора есть готовые задачи и все
+// воркеры заняты, и уменьшается на одного воркера за интервал, пока задач нет и есть простаивающие
+func (a *Agent) autoscale(ctx context.Context, pool *workerPool) {
+	interval := time.Duration(a.cfg.AutoscaleIntervalMS) * time.Millisecond
+	if interval <= 0 {
+		interval = defaultAutoscaleInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		size := int64(pool.Size())
+		busy := a.busy.Load()
+		backlog := a.backlog.Load()
+
+		target := size
+		switch {
+		case backlog > 0 && busy >= size:
+			target = min(size+backlog, a.maxWorkers.Load())
+		case backlog == 0 && busy < size:
+			target = max(size-1, a.minWorkers.Load())
+		}
+
+		if target != size {
+			logger.Info("Autoscaling workers",
+				"backlog", backlog,
+				"busy", busy,
+				"old_size", size,
+				"new_size", target)
+			pool.Resize(int(target))
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package agent
+
+import (
+	"context"
+	"final3/internal/config"
+	"final3/internal/logger"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	defaultRetryInitialInterval = 500 * time.Millisecond
+	defaultRetryMaxInterval     = 30 * time.Second
+	defaultRetryMultiplier      = 2.0
+	defaultBreakerThreshold     = 5
+	defaultBreakerOpenTimeout   = 10 * time.Second
+)
+
+// Политика повторных попыток: экспоненциальная задержка со случайным разбросом
+type retryPolicy struct {
+	initial    time.Duration
+	max        time.Duration
+	multiplier float64
+	jitter     float64
+}
+
+// Создание политики повторов по конфигу агента (нулевые значения заменяются стандартными)
+func newRetryPolicy(cfg *config.AgentConfig) retryPolicy {
+	p := retryPolicy{
+		initial:    time.Duration(cfg.RetryInitialIntervalMS) * time.Millisecond,
+		max:        time.Duration(cfg.RetryMaxIntervalMS) * time.Millisecond,
+		multiplier: cfg.RetryMultiplier,
+		jitter:     cfg.RetryJitter,
+	}
+
+	if p.initial <= 0 {
+		p.initial = defaultRetryInitialInterval
+	}
+	if p.max <= 0 {
+		p.max = defaultRetryMaxInterval
+	}
+	if p.multiplier < 1 {
+		p.multiplier = defaultRetryMultiplier
+	}
+
+	return p
+}
+
+// Состояние повторов одного воркера
+type backoff struct {
+	policy  retryPolicy
+	current time.Duration
+}
+
+func newBackoff(policy retryPolicy) *backoff {
+	return &backoff{policy: policy}
+}
+
+// Следующая задержка перед повтором
+func (b *backoff) Next() time.Duration {
+	if b.current == 0 {
+		b.current = b.policy.initial
+	} else {
+		b.current = time.Duration(float64(b.current) * b.policy.multiplier)
+	}
+
+	if b.current > b.policy.max {
+		b.current = b.policy.max
+	}
+
+	delay := b.current
+	if b.policy.jitter > 0 {
+		delta := float64(delay) * b.policy.jitter
+		delay = time.Duration(float64(delay) - delta + rand.Float64()*2*delta)
+	}
+
+	return delay
+}
+
+// Сброс задержки после успешной попытки
+func (b *backoff) Reset() {
+	b.current = 0
+}
+
+type breakerState string
+
+const (
+	breakerClosed   breakerState = "closed"
+	breakerOpen     breakerState = "open"
+	breakerHalfOpen breakerState = "half_open"
+)
+
+// Автоматический выключатель, общий для всех воркеров агента. После threshold ошибок подряд
+// запросы к оркестратору прекращаются на openTimeout, затем пропускается один пробный запрос
+type circuitBreaker struct {
+	mu          sync.Mutex
+	state       breakerState
+	failures    int
+	threshold   int
+	openTimeout time.Duration
+	openedAt    time.Time
+	probing     bool
+}
+
+// Создание выключателя по конфигу агента (нулевые значения заменяются стандартными)
+func newCircuitBreaker(cfg *config.AgentConfig) *circuitBreaker {
+	cb := &circuitBreaker{
+		state:       breakerClosed,
+		threshold:   cfg.BreakerFailureThreshold,
+		openTimeout: time.Duration(cfg.BreakerOpenTimeoutMS) * time.Millisecond,
+	}
+
+	if cb.threshold <= 0 {
+		cb.threshold = defaultBreakerThreshold
+	}
+	if cb.openTimeout <= 0 {
+		cb.openTimeout = defaultBreakerOpenTimeout
+	}
+
+	return cb
+}
+
+// Разрешён ли запрос к оркестратору
+func (cb *circuitBreaker) Allow() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	switch cb.state {
+	case breakerOpen:
+		if time.Since(cb.openedAt) < cb.openTimeout {
+			return false
+		}
+		cb.state = breakerHalfOpen
+		cb.probing = true
+		logger.Info("Circuit breaker half-open, probing orchestrator")
+		return true
+	case breakerHalfOpen:
+		if cb.probing {
+			return false
+		}
+		cb.probing = true
+		return true
+	}
+
+	return true
+}
+
+// Учёт успешного запроса
+func (cb *circuitBreaker) Success() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.state != breakerClosed {
+		logger.Info("Circuit breaker closed, orchestrator is reachable")
+	}
+
+	cb.state = breakerClosed
+	cb.failures = 0
+	cb.probing = false
+}
+
+// Учёт неудачного запроса
+func (cb *circuitBreaker) Failure() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.failures++
+	cb.probing = false
+
+	if cb.state == breakerHalfOpen || (cb.state == breakerClosed && cb.failures >= cb.threshold) {
+		cb.state = breakerOpen
+		cb.openedAt = time.Now()
+		logger.Warn("Circuit breaker opened",
+			"failures", cb.failures,
+			"open_timeout_ms", cb.openTimeout.Milliseconds())
+	}
+}
+
+// Текущее состояние выключателя
+func (cb *circuitBreaker) State() breakerState {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	return cb.state
+}
+
+// Ожидание d с учётом отмены контекста
+//
+// - Возвращает false, если контекст был отменён
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
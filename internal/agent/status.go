@@ -0,0 +1,100 @@
+package agent
+
+import (
+	"encoding/json"
+	"final3/internal/logger"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Метрики агента в формате Prometheus
+type agentMetrics struct {
+	registry       *prometheus.Registry
+	tasksCompleted *prometheus.CounterVec
+	taskErrors     *prometheus.CounterVec
+	requestErrors  prometheus.Counter
+	fetchLatency   prometheus.Histogram
+}
+
+func newAgentMetrics(a *Agent) *agentMetrics {
+	gauge := func(name, help string, fn func() float64) prometheus.GaugeFunc {
+		return prometheus.NewGaugeFunc(prometheus.GaugeOpts{Name: name, Help: help}, fn)
+	}
+
+	m := &agentMetrics{
+		registry: prometheus.NewRegistry(),
+		tasksCompleted: prometheus.NewCounterVec(prometheus.CounterOpts{Name: "agent_tasks_completed_total",
+			Help: "Tasks calculated successfully."}, []string{"operation"}),
+		taskErrors: prometheus.NewCounterVec(prometheus.CounterOpts{Name: "agent_task_errors_total",
+			Help: "Tasks finished with a calculation error."}, []string{"operation"}),
+		requestErrors: prometheus.NewCounter(prometheus.CounterOpts{Name: "agent_orchestrator_request_errors_total",
+			Help: "Failed requests to the orchestrator."}),
+		fetchLatency: prometheus.NewHistogram(prometheus.HistogramOpts{Name: "agent_fetch_duration_seconds",
+			Help: "Latency of task requests to the orchestrator.", Buckets: prometheus.DefBuckets}),
+	}
+
+	m.registry.MustRegister(
+		m.tasksCompleted, m.taskErrors, m.requestErrors, m.fetchLatency,
+		gauge("agent_tasks_in_flight", "Tasks being calculated right now.", func() float64 {
+			return float64(a.busy.Load())
+		}),
+		gauge("agent_workers", "Current number of workers.", func() float64 {
+			return float64(a.Workers())
+		}),
+		gauge("agent_idle_workers", "Workers without a task.", func() float64 {
+			return float64(int64(a.Workers()) - a.busy.Load())
+		}),
+		gauge("agent_orchestrator_up", "Whether the last request to the orchestrator succeeded.", func() float64 {
+			return boolToFloat(a.connected.Load())
+		}),
+		gauge("agent_circuit_breaker_open", "Whether requests to the orchestrator are suspended.", func() float64 {
+			return boolToFloat(a.breaker.State() == breakerOpen)
+		}),
+	)
+
+	return m
+}
+
+func boolToFloat(v bool) float64 {
+	if v {
+		return 1
+	}
+	return 0
+}
+
+// Обработчики сервера состояния агента: проверки для платформы контейнеров и метрики
+func (a *Agent) statusHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", a.HealthzHandler)
+	mux.HandleFunc("/readyz", a.ReadyzHandler)
+	mux.Handle("/metrics", promhttp.HandlerFor(a.metrics.registry, promhttp.HandlerOpts{}))
+	return mux
+}
+
+// Проверка жизнеспособности: процесс работает
+func (a *Agent) HealthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// Проверка готовности: агент запущен, не завершает работу и связь с оркестратором есть
+func (a *Agent) ReadyzHandler(w http.ResponseWriter, r *http.Request) {
+	status := "ready"
+	switch {
+	case !a.running.Load():
+		status = "not running"
+	case a.breaker.State() == breakerOpen:
+		status = "circuit breaker open"
+	case !a.connected.Load():
+		status = "orchestrator unreachable"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if status != "ready" {
+		logger.Debug("Agent is not ready", "reason", status)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(map[string]string{"status": status})
+}
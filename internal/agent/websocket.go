@@ -0,0 +1,223 @@
+package agent
+
+import (
+	"context"
+	"final3/internal/logger"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	wsWriteWait = 10 * time.Second
+	wsReadWait  = 60 * time.Second // Оркестратор отправляет heartbeat чаще этого интервала
+)
+
+// Адрес WebSocket-канала оркестратора
+func websocketURL(orchestratorURL string, capacity int64) (string, error) {
+	u, err := url.Parse(orchestratorURL)
+	if err != nil {
+		return "", err
+	}
+
+	switch u.Scheme {
+	case "http":
+		u.Scheme = "ws"
+	case "https":
+		u.Scheme = "wss"
+	case "ws", "wss":
+	default:
+		return "", fmt.Errorf("unsupported orchestrator URL scheme: %s", u.Scheme)
+	}
+
+	u.Path += "/internal/ws"
+	u.RawQuery = url.Values{"capacity": {fmt.Sprint(capacity)}}.Encode()
+	return u.String(), nil
+}
+
+// Работа агента через WebSocket: оркестратор сам присылает задачи (не больше ComputingPower
+// одновременно), результаты отправляются по тому же соединению. При потере соединения агент
+// переподключается согласно политике повторов
+func (a *Agent) runWebSocket(ctx, workCtx context.Context) error {
+	wsURL, err := websocketURL(a.cfg.OrchestratorURL, a.cfg.ComputingPower)
+	if err != nil {
+		logger.Error("Invalid orchestrator URL", "error", err)
+		return err
+	}
+
+	b := newBackoff(a.retry)
+	for {
+		var conn *websocket.Conn
+		err := a.withRetry(ctx, b, func() error {
+			var err error
+			conn, err = a.dialWebSocket(ctx, wsURL)
+			return err
+		})
+		if ctx.Err() != nil {
+			logger.Info("Agent shut down complete")
+			return nil
+		}
+		if err != nil {
+			logger.Error("Orchestrator rejected websocket connection",
+				"url", wsURL,
+				"error", err)
+			return err
+		}
+
+		err = a.serveWebSocket(ctx, workCtx, conn)
+		a.connected.Store(false)
+		if ctx.Err() != nil {
+			logger.Info("Agent shut down complete")
+			return nil
+		}
+
+		logger.Warn("Connection to orchestrator websocket lost, reconnecting", "error", err)
+	}
+}
+
+// Установка WebSocket-соединения с оркестратором
+func (a *Agent) dialWebSocket(ctx context.Context, wsURL string) (*websocket.Conn, error) {
+	header := http.Header{}
+	a.authorize(header)
+
+	conn, resp, err := a.dialer.DialContext(ctx, wsURL, header)
+	if err != nil {
+		if resp != nil {
+			return nil, &statusError{code: resp.StatusCode, body: err.Error()}
+		}
+		return nil, err
+	}
+
+	logger.Info("Connected to orchestrator websocket", "url", wsURL)
+	return conn, nil
+}
+
+// Обработка задач, приходящих по WebSocket-соединению, до его закрытия
+//
+// После отмены ctx новые задачи не выполняются; соединение закрывается, когда завершатся начатые
+// задачи или будет отменён workCtx. Все задачи без результата оркестратор возвращает в очередь
+// при закрытии соединения
+func (a *Agent) serveWebSocket(ctx, workCtx context.Context, conn *websocket.Conn) error {
+	defer conn.Close()
+
+	var writeMu sync.Mutex
+
+	conn.SetReadDeadline(time.Now().Add(wsReadWait))
+	conn.SetPingHandler(func(data string) error {
+		conn.SetReadDeadline(time.Now().Add(wsReadWait))
+		err := conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(wsWriteWait))
+		if err == websocket.ErrCloseSent {
+			return nil
+		}
+		return err
+	})
+
+	workerIDs := make(chan int64, a.cfg.ComputingPower)
+	for i := int64(0); i < a.cfg.ComputingPower; i++ {
+		workerIDs <- i
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-stop:
+			return
+		}
+
+	drain:
+		for i := int64(0); i < a.cfg.ComputingPower; i++ {
+			select {
+			case <-workerIDs:
+			case <-workCtx.Done():
+				break drain
+			case <-stop:
+				return
+			}
+		}
+
+		logger.Info("Closing orchestrator websocket")
+		writeMu.Lock()
+		conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+			time.Now().Add(wsWriteWait))
+		writeMu.Unlock()
+		conn.Close()
+	}()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for {
+		var task Task
+		if err := conn.ReadJSON(&task); err != nil {
+			return fmt.Errorf("websocket read: %w", err)
+		}
+
+		workerId := int64(-1)
+		select {
+		case workerId = <-workerIDs:
+		case <-ctx.Done():
+		}
+
+		if ctx.Err() != nil {
+			if workerId >= 0 {
+				select {
+				case workerIDs <- workerId:
+				default:
+				}
+			}
+			a.releaseOnShutdown(taskContext(ctx, task), workerId, task)
+			continue
+		}
+
+		logger.InfoContext(taskContext(ctx, task), "Task received",
+			"worker_id", workerId,
+			"task_id", task.ID,
+			"expression_id", task.ExpressionID,
+			"arg1", task.Arg1,
+			"operation", task.Operation,
+			"arg2", task.Arg2,
+			"operation_time_ms", task.OperationTime.Milliseconds())
+
+		wg.Add(1)
+		go func(task Task, workerId int64) {
+			defer wg.Done()
+			defer func() { workerIDs <- workerId }()
+
+			a.busy.Add(1)
+			defer a.busy.Add(-1)
+
+			taskCtx, span := a.startTaskSpan(taskContext(workCtx, task), workerId, task)
+			defer span.End()
+
+			answer, ok := a.process(taskCtx, workerId, task)
+			if !ok {
+				return
+			}
+
+			writeMu.Lock()
+			conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			err := conn.WriteJSON(answer)
+			writeMu.Unlock()
+
+			if err != nil {
+				logger.ErrorContext(taskCtx, "Error sending task result via websocket",
+					"worker_id", workerId,
+					"task_id", task.ID,
+					"error", err)
+				return
+			}
+
+			logger.InfoContext(taskCtx, "Task completed successfully",
+				"worker_id", workerId,
+				"task_id", task.ID,
+				"result", answer.Result)
+		}(task, workerId)
+	}
+}
@@ -0,0 +1,14 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// Токен агента agentID, выпускаемый по общему секрету агентов (HMAC-SHA256 идентификатора)
+func AgentToken(secret, agentID string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(agentID))
+	return hex.EncodeToString(mac.Sum(nil))
+}
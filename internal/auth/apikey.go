@@ -0,0 +1,40 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// Префикс, по которому API-ключ отличается от JWT
+const APIKeyPrefix = "calc_"
+
+// Права API-ключа
+const (
+	ScopeSubmit = "submit" // Отправка выражений
+	ScopeRead   = "read"   // Просмотр выражений
+)
+
+// Генерация нового API-ключа
+func GenerateAPIKey() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return APIKeyPrefix + hex.EncodeToString(secret), nil
+}
+
+// Хеш API-ключа для хранения. Ключ случайный и длинный, поэтому медленный хеш не нужен
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func IsAPIKey(token string) bool {
+	return strings.HasPrefix(token, APIKeyPrefix)
+}
+
+func ValidScope(scope string) bool {
+	return scope == ScopeSubmit || scope == ScopeRead
+}
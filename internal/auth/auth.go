@@ -0,0 +1,106 @@
+// Учётные данные пользователей: хеширование паролей и JWT-токены доступа
+package auth
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrInvalidToken    = errors.New("invalid token")
+	ErrInvalidPassword = errors.New("invalid password")
+)
+
+// Хеширование пароля bcrypt
+func HashPassword(password string) ([]byte, error) {
+	return bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+}
+
+// Проверка пароля по хешу
+func CheckPassword(hash []byte, password string) error {
+	if err := bcrypt.CompareHashAndPassword(hash, []byte(password)); err != nil {
+		return ErrInvalidPassword
+	}
+	return nil
+}
+
+// Данные пользователя из токена
+type Claims struct {
+	UserID int32
+	Login  string
+}
+
+type tokenClaims struct {
+	Login string `json:"name"`
+	jwt.RegisteredClaims
+}
+
+// Выпуск и проверка JWT (HS256)
+type TokenManager struct {
+	secret []byte
+	ttl    time.Duration
+	now    func() time.Time
+}
+
+// Создание менеджера токенов; при пустом secret ключ генерируется случайно
+// (выданные токены перестают действовать после перезапуска)
+func NewTokenManager(secret string, ttl time.Duration) (*TokenManager, error) {
+	key := []byte(secret)
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+	}
+
+	return &TokenManager{secret: key, ttl: ttl, now: time.Now}, nil
+}
+
+// Выпуск токена пользователя; возвращает токен и время окончания его действия
+func (m *TokenManager) Issue(userID int32, login string) (string, time.Time, error) {
+	now := m.now()
+	expiresAt := now.Add(m.ttl)
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, tokenClaims{
+		Login: login,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   strconv.FormatInt(int64(userID), 10),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	})
+
+	signed, err := token.SignedString(m.secret)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return signed, expiresAt, nil
+}
+
+// Проверка подписи и срока действия токена
+func (m *TokenManager) Parse(token string) (Claims, error) {
+	var claims tokenClaims
+	_, err := jwt.ParseWithClaims(token, &claims, func(*jwt.Token) (any, error) {
+		return m.secret, nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithExpirationRequired(),
+		jwt.WithTimeFunc(m.now))
+	if err != nil {
+		return Claims{}, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	id, err := strconv.ParseInt(claims.Subject, 10, 32)
+	if err != nil {
+		return Claims{}, fmt.Errorf("%w: invalid subject %q", ErrInvalidToken, claims.Subject)
+	}
+
+	return Claims{UserID: int32(id), Login: claims.Login}, nil
+}
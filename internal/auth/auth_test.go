@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPassword(t *testing.T) {
+	hash, err := HashPassword("secret-password")
+	if err != nil {
+		t.Fatalf("HashPassword() error = %v", err)
+	}
+
+	if err := CheckPassword(hash, "secret-password"); err != nil {
+		t.Errorf("CheckPassword() with correct password error = %v", err)
+	}
+	if err := CheckPassword(hash, "wrong-password"); !errors.Is(err, ErrInvalidPassword) {
+		t.Errorf("CheckPassword() with wrong password error = %v, want %v", err, ErrInvalidPassword)
+	}
+}
+
+func TestTokenManager(t *testing.T) {
+	manager, err := NewTokenManager("secret", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := NewTokenManager("other-secret", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, expiresAt, err := manager.Issue(7, "alice")
+	if err != nil {
+		t.Fatalf("Issue() error = %v", err)
+	}
+	if d := time.Until(expiresAt); d <= 0 || d > time.Hour {
+		t.Errorf("unexpected expiration time %v", expiresAt)
+	}
+
+	expired, err := NewTokenManager("secret", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired.now = func() time.Time { return time.Now().Add(-2 * time.Hour) }
+	expiredToken, _, err := expired.Issue(7, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Подпись токена alice с данными другого пользователя
+	forged, _, err := manager.Issue(8, "mallory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged = forged[:strings.LastIndex(forged, ".")] + token[strings.LastIndex(token, "."):]
+
+	tests := []struct {
+		name    string
+		manager *TokenManager
+		token   string
+		wantErr bool
+	}{
+		{"Valid", manager, token, false},
+		{"WrongKey", other, token, true},
+		{"Tampered", manager, forged, true},
+		{"Expired", manager, expiredToken, true},
+		{"Garbage", manager, "not-a-token", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := tt.manager.Parse(tt.token)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidToken) {
+					t.Errorf("Parse() error = %v, want %v", err, ErrInvalidToken)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if claims.UserID != 7 || claims.Login != "alice" {
+				t.Errorf("Parse() = %+v, want user 7 alice", claims)
+			}
+		})
+	}
+}
+
+func TestAgentToken(t *testing.T) {
+	token := AgentToken("secret", "agent-1")
+	if token != AgentToken("secret", "agent-1") {
+		t.Error("AgentToken() is not deterministic")
+	}
+	if token == AgentToken("secret", "agent-2") || token == AgentToken("other-secret", "agent-1") {
+		t.Error("AgentToken() must depend on both secret and agent ID")
+	}
+	if strings.Contains(token, "secret") {
+		t.Errorf("AgentToken() = %q leaks the secret", token)
+	}
+}
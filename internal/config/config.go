@@ -1,6 +1,30 @@
 package config
 
-import "fmt"
+import (
+	"fmt"
+	"log/slog"
+)
+
+// Способы получения задач агентом
+const (
+	TransportHTTP      = "http"      // Периодический опрос /internal/task
+	TransportWebSocket = "websocket" // Постоянное WebSocket-соединение с /internal/ws
+)
+
+// Экспортёры трассировки
+const (
+	TracingNone   = "none"
+	TracingStdout = "stdout"
+	TracingFile   = "file"
+	TracingOTLP   = "otlp"
+)
+
+// Сертификаты TLS. Для взаимной аутентификации (mTLS) задаются все три файла
+type TLSConfig struct {
+	CertFile string `yaml:"cert_file"` // Сертификат в PEM
+	KeyFile  string `yaml:"key_file"`  // Закрытый ключ сертификата в PEM
+	CAFile   string `yaml:"ca_file"`   // Сертификаты доверенных центров в PEM для проверки другой стороны
+}
 
 type OrchestratorConfig struct {
 	Port                  int   `yaml:"port" env:"ORCHESTRATOR_PORT"`
@@ -8,11 +32,89 @@ type OrchestratorConfig struct {
 	TimeSubtractionMS     int64 `yaml:"time_subtraction_ms" env:"TIME_SUBTRACTION_MS"`
 	TimeMultiplicationsMS int64 `yaml:"time_multiplications_ms" env:"TIME_MULTIPLICATIONS_MS"`
 	TimeDivisionsMS       int64 `yaml:"time_divisions_ms" env:"TIME_DIVISIONS_MS"`
+	ShutdownDelayMS       int64 `yaml:"shutdown_delay_ms" env:"SHUTDOWN_DELAY_MS"` // Сколько /readyz отвечает 503 перед остановкой сервера
+
+	AdminAddr string `yaml:"admin_addr" env:"ORCHESTRATOR_ADMIN_ADDR"` // Адрес локального административного сервера (пусто - выключен)
+
+	JWTSecret       string `yaml:"jwt_secret" env:"JWT_SECRET"`               // Ключ подписи токенов (пусто - случайный при каждом запуске)
+	TokenTTLMinutes int64  `yaml:"token_ttl_minutes" env:"TOKEN_TTL_MINUTES"` // Время действия токена
+
+	// Внутренние эндпоинты для агентов (/internal/*) на отдельном порту
+	InternalPort int               `yaml:"internal_port" env:"ORCHESTRATOR_INTERNAL_PORT"`
+	AgentSecret  string            `yaml:"agent_secret" env:"AGENT_SECRET"` // Секрет, по которому выпускаются токены агентов
+	AgentTokens  map[string]string `yaml:"agent_tokens" env:"AGENT_TOKENS"` // Токены отдельных агентов: идентификатор агента -> токен
+
+	// Ограничения для пользователей и IP-адресов (0 - без ограничения)
+	RateLimitRPS           float64 `yaml:"rate_limit_rps" env:"RATE_LIMIT_RPS"`                       // Запросов в секунду от одного пользователя
+	RateLimitBurst         int     `yaml:"rate_limit_burst" env:"RATE_LIMIT_BURST"`                   // Допустимый всплеск запросов пользователя
+	IPRateLimitRPS         float64 `yaml:"ip_rate_limit_rps" env:"IP_RATE_LIMIT_RPS"`                 // Запросов в секунду с одного IP-адреса
+	IPRateLimitBurst       int     `yaml:"ip_rate_limit_burst" env:"IP_RATE_LIMIT_BURST"`             // Допустимый всплеск запросов с одного IP-адреса
+	MaxActiveExpressions   int     `yaml:"max_active_expressions" env:"MAX_ACTIVE_EXPRESSIONS"`       // Незавершённых выражений одного пользователя
+	IPMaxActiveExpressions int     `yaml:"ip_max_active_expressions" env:"IP_MAX_ACTIVE_EXPRESSIONS"` // Незавершённых выражений с одного IP-адреса
+	MaxExpressionNodes     int     `yaml:"max_expression_nodes" env:"MAX_EXPRESSION_NODES"`           // Узлов (чисел и операций) в выражении пользователя
+	IPMaxExpressionNodes   int     `yaml:"ip_max_expression_nodes" env:"IP_MAX_EXPRESSION_NODES"`     // Узлов в выражении с одного IP-адреса
+
+	// Ограничения очереди для всех пользователей (0 - без ограничения). При превышении
+	// новые выражения не принимаются - ответ 503 с Retry-After
+	MaxQueuedExpressions int `yaml:"max_queued_expressions" env:"MAX_QUEUED_EXPRESSIONS"` // Незавершённых выражений
+	MaxPendingNodes      int `yaml:"max_pending_nodes" env:"MAX_PENDING_NODES"`           // Невычисленных операций в них
+
+	// Срок вычисления выражения, если клиент его не указал, и максимальный срок (0 - без срока)
+	DefaultTimeoutMS int64 `yaml:"default_timeout_ms" env:"DEFAULT_TIMEOUT_MS"`
+	MaxTimeoutMS     int64 `yaml:"max_timeout_ms" env:"MAX_TIMEOUT_MS"`
+
+	PriorityAgingMS int64 `yaml:"priority_aging_ms" env:"PRIORITY_AGING_MS"` // Ожидание, повышающее приоритет выражения на единицу (0 - без старения)
+
+	// Повторов задачи на других агентах после временного сбоя агента (0 - сбой сразу завершает выражение ошибкой)
+	MaxTaskRetries int `yaml:"max_task_retries" env:"MAX_TASK_RETRIES"`
+
+	// Спекулятивное выполнение: задача, находящаяся у агента дольше времени операции, умноженного
+	// на коэффициент операции ("+" -> 3), дублируется свободному агенту и принимается первый результат.
+	// Операции без коэффициента не дублируются
+	SpeculationFactors    map[string]float64 `yaml:"speculation_factors" env:"SPECULATION_FACTORS"`
+	SpeculationMinDelayMS int64              `yaml:"speculation_min_delay_ms" env:"SPECULATION_MIN_DELAY_MS"` // Минимальное время у агента до дублирования
+
+	// Веса пользователей (логин -> вес, по умолчанию 1) при распределении задач между агентами:
+	// пользователь с весом 2 получает вдвое больше времени агентов, чем с весом 1
+	UserWeights map[string]int `yaml:"user_weights" env:"USER_WEIGHTS"`
+
+	// TLS внутреннего порта. С ca_file агенты обязаны предъявить сертификат, подписанный этим центром,
+	// а идентификатор агента берётся из сертификата (ORCHESTRATOR_TLS_CERT, ORCHESTRATOR_TLS_KEY, ORCHESTRATOR_TLS_CA)
+	TLS TLSConfig `yaml:"tls"`
 }
 
 type AgentConfig struct {
 	OrchestratorURL string `yaml:"orchestrator_url" env:"ORCHESTRATOR_URL"`
 	ComputingPower  int64  `yaml:"computing_power" env:"COMPUTING_POWER"` // Количество запускаемых горутин для каждого агента
+	Transport       string `yaml:"transport" env:"AGENT_TRANSPORT"`       // Способ получения задач: http или websocket
+
+	ID    string `yaml:"agent_id" env:"AGENT_ID"` // Идентификатор агента (по умолчанию - <hostname>-<pid>)
+	Token string `yaml:"token" env:"AGENT_TOKEN"` // Токен агента (orchestrator -agent-token <agent_id> или из agent_tokens)
+
+	// TLS соединения с оркестратором (orchestrator_url с https://): сертификат агента для mTLS
+	// и центр, которым подписан сертификат оркестратора, пусто - системные (AGENT_TLS_CERT, AGENT_TLS_KEY, AGENT_TLS_CA)
+	TLS TLSConfig `yaml:"tls"`
+
+	// Повторные запросы к оркестратору: экспоненциальная задержка со случайным разбросом
+	RetryInitialIntervalMS int64   `yaml:"retry_initial_interval_ms" env:"RETRY_INITIAL_INTERVAL_MS"`
+	RetryMaxIntervalMS     int64   `yaml:"retry_max_interval_ms" env:"RETRY_MAX_INTERVAL_MS"`
+	RetryMultiplier        float64 `yaml:"retry_multiplier" env:"RETRY_MULTIPLIER"`
+	RetryJitter            float64 `yaml:"retry_jitter" env:"RETRY_JITTER"` // Доля случайного разброса задержки (от 0 до 1)
+
+	// Автоматический выключатель, общий для всех воркеров агента
+	BreakerFailureThreshold int   `yaml:"breaker_failure_threshold" env:"BREAKER_FAILURE_THRESHOLD"` // Количество ошибок подряд до размыкания
+	BreakerOpenTimeoutMS    int64 `yaml:"breaker_open_timeout_ms" env:"BREAKER_OPEN_TIMEOUT_MS"`     // Время до пробного запроса после размыкания
+
+	DrainTimeoutMS int64 `yaml:"drain_timeout_ms" env:"DRAIN_TIMEOUT_MS"` // Время на завершение начатых задач при остановке агента
+
+	AdminAddr  string `yaml:"admin_addr" env:"AGENT_ADMIN_ADDR"`   // Адрес локального административного сервера агента (пусто - выключен)
+	StatusAddr string `yaml:"status_addr" env:"AGENT_STATUS_ADDR"` // Адрес сервера /healthz, /readyz и /metrics (пусто - выключен)
+
+	// Автоматическое масштабирование количества воркеров (только для транспорта http)
+	Autoscale           bool  `yaml:"autoscale" env:"AUTOSCALE"`
+	MinWorkers          int64 `yaml:"min_workers" env:"MIN_WORKERS"`
+	MaxWorkers          int64 `yaml:"max_workers" env:"MAX_WORKERS"`
+	AutoscaleIntervalMS int64 `yaml:"autoscale_interval_ms" env:"AUTOSCALE_INTERVAL_MS"`
 }
 
 type Config struct {
@@ -21,11 +123,30 @@ type Config struct {
 
 	Logging struct {
 		ToFile   bool   `yaml:"to_file" env:"TO_FILE"`
-		Dir      string `yaml:"logging_dir" env:"LOGGING_DIR"`       // Дирректория для логирования
-		Format   string `yaml:"logging_format" env:"LOGGING_FORMAT"` // Формат логирования, поддерживаемые форматы: json, текстовый (по стандарту - текстовый)
-		MaxSize  int    `yaml:"logging_file_max_size" env:"LOGGING_FILE_MAX_SIZE"`
-		MaxFiles int    `yaml:"logging_max_filex" env:"LOGGING_MAX_FILES"`
+		Dir      string `yaml:"logging_dir" env:"LOGGING_DIR"`        // Дирректория для логирования
+		Format   string `yaml:"format" env:"LOGGING_FORMAT"`          // Формат логирования, поддерживаемые форматы: json, текстовый (по стандарту - текстовый)
+		MaxSize  int    `yaml:"max_size" env:"LOGGING_FILE_MAX_SIZE"` // Размер файла лога в МБ, после которого начинается новый файл (0 - без ограничения)
+		MaxFiles int    `yaml:"max_files" env:"LOGGING_MAX_FILES"`    // Количество хранимых старых файлов лога (0 - хранить все)
+		Compress bool   `yaml:"compress" env:"LOGGING_COMPRESS"`      // Сжимать старые файлы лога gzip
+		Level    string `yaml:"level" env:"LOGGING_LEVEL"`            // Уровень логирования: debug, info, warn, error
+
+		// Уровни отдельных компонентов (parser, scheduler, worker), например "parser=debug,worker=warn"
+		ComponentLevels string `yaml:"component_levels" env:"LOGGING_COMPONENT_LEVELS"`
 	} `yaml:"logging" env:"LOGGING"`
+
+	// Журнал аудита действий с выражениями (только оркестратор)
+	Audit struct {
+		Enabled  bool   `yaml:"enabled" env:"AUDIT_ENABLED"`
+		Dir      string `yaml:"dir" env:"AUDIT_DIR"`           // Директория журнала (по умолчанию - logging_dir)
+		MaxSize  int    `yaml:"max_size" env:"AUDIT_MAX_SIZE"` // Размер файла в МБ, после которого начинается новый (0 - без ограничения)
+		Compress bool   `yaml:"compress" env:"AUDIT_COMPRESS"` // Сжимать старые файлы gzip
+	} `yaml:"audit" env:"AUDIT"`
+
+	Tracing struct {
+		Exporter string `yaml:"exporter" env:"TRACING_EXPORTER"` // Экспортёр спанов: none, stdout, file, otlp
+		File     string `yaml:"file" env:"TRACING_FILE"`         // Файл для экспортёра file
+		Endpoint string `yaml:"endpoint" env:"TRACING_ENDPOINT"` // Адрес OTLP/HTTP-приёмника, например http://otel-collector:4318
+	} `yaml:"tracing"`
 }
 
 func NewDefaultConfig() *Config {
@@ -36,14 +157,37 @@ func NewDefaultConfig() *Config {
 	cfg.Orchestrator.TimeSubtractionMS = 5000
 	cfg.Orchestrator.TimeMultiplicationsMS = 10000
 	cfg.Orchestrator.TimeDivisionsMS = 10000
+	cfg.Orchestrator.InternalPort = 8081
+	cfg.Orchestrator.MaxQueuedExpressions = 10000
+	cfg.Orchestrator.MaxPendingNodes = 1000000
+	cfg.Orchestrator.PriorityAgingMS = 10000
+	cfg.Orchestrator.MaxTaskRetries = 2
+	cfg.Orchestrator.SpeculationMinDelayMS = 1000
 
-	cfg.Agent.OrchestratorURL = "http://localhost:8080"
+	cfg.Agent.OrchestratorURL = "http://localhost:8081"
 	cfg.Agent.ComputingPower = 5
+	cfg.Agent.Transport = TransportHTTP
+	cfg.Agent.RetryInitialIntervalMS = 500
+	cfg.Agent.RetryMaxIntervalMS = 30000
+	cfg.Agent.RetryMultiplier = 2
+	cfg.Agent.RetryJitter = 0.2
+	cfg.Agent.BreakerFailureThreshold = 5
+	cfg.Agent.BreakerOpenTimeoutMS = 10000
+	cfg.Agent.DrainTimeoutMS = 30000
+	cfg.Agent.Autoscale = false
+	cfg.Agent.MinWorkers = 1
+	cfg.Agent.MaxWorkers = 50
+	cfg.Agent.AutoscaleIntervalMS = 5000
+
+	cfg.Orchestrator.TokenTTLMinutes = 24 * 60
 
 	cfg.Logging.ToFile = false
 	cfg.Logging.Format = "json"
 	cfg.Logging.MaxSize = 10
 	cfg.Logging.MaxFiles = 3
+	cfg.Logging.Level = "info"
+
+	cfg.Tracing.Exporter = TracingNone
 
 	return cfg
 }
@@ -53,6 +197,22 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("invalid orchestrator port: %d", c.Orchestrator.Port)
 	}
 
+	if c.Orchestrator.InternalPort <= 0 || c.Orchestrator.InternalPort > 65535 || c.Orchestrator.InternalPort == c.Orchestrator.Port {
+		return fmt.Errorf("invalid orchestrator internal port: %d", c.Orchestrator.InternalPort)
+	}
+
+	if err := c.Orchestrator.TLS.validate(); err != nil {
+		return fmt.Errorf("invalid orchestrator tls: %w", err)
+	}
+
+	if c.Orchestrator.TLS.CAFile != "" && c.Orchestrator.TLS.CertFile == "" {
+		return fmt.Errorf("invalid orchestrator tls: ca_file requires cert_file and key_file")
+	}
+
+	if c.Orchestrator.AgentSecret != "" && len(c.Orchestrator.AgentTokens) > 0 {
+		return fmt.Errorf("agent_secret and agent_tokens are mutually exclusive")
+	}
+
 	if c.Orchestrator.TimeAdditionMS <= 0 {
 		return fmt.Errorf("invalid time additions: %d", c.Orchestrator.TimeAdditionMS)
 	}
@@ -69,6 +229,81 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("invalid time substractions: %d", c.Orchestrator.TimeSubtractionMS)
 	}
 
+	if c.Orchestrator.TokenTTLMinutes < 0 {
+		return fmt.Errorf("invalid token ttl: %d", c.Orchestrator.TokenTTLMinutes)
+	}
+
+	if c.Orchestrator.RateLimitRPS < 0 || c.Orchestrator.RateLimitBurst < 0 {
+		return fmt.Errorf("invalid user rate limit: %v rps, burst %d", c.Orchestrator.RateLimitRPS, c.Orchestrator.RateLimitBurst)
+	}
+
+	if c.Orchestrator.IPRateLimitRPS < 0 || c.Orchestrator.IPRateLimitBurst < 0 {
+		return fmt.Errorf("invalid ip rate limit: %v rps, burst %d", c.Orchestrator.IPRateLimitRPS, c.Orchestrator.IPRateLimitBurst)
+	}
+
+	if c.Orchestrator.MaxActiveExpressions < 0 || c.Orchestrator.IPMaxActiveExpressions < 0 {
+		return fmt.Errorf("invalid max active expressions: %d, per ip %d", c.Orchestrator.MaxActiveExpressions, c.Orchestrator.IPMaxActiveExpressions)
+	}
+
+	if c.Orchestrator.MaxExpressionNodes < 0 || c.Orchestrator.IPMaxExpressionNodes < 0 {
+		return fmt.Errorf("invalid max expression nodes: %d, per ip %d", c.Orchestrator.MaxExpressionNodes, c.Orchestrator.IPMaxExpressionNodes)
+	}
+
+	if c.Orchestrator.MaxQueuedExpressions < 0 || c.Orchestrator.MaxPendingNodes < 0 {
+		return fmt.Errorf("invalid queue limits: %d expressions, %d pending nodes", c.Orchestrator.MaxQueuedExpressions, c.Orchestrator.MaxPendingNodes)
+	}
+
+	if c.Orchestrator.DefaultTimeoutMS < 0 || c.Orchestrator.MaxTimeoutMS < 0 {
+		return fmt.Errorf("invalid expression timeouts: default %d, max %d", c.Orchestrator.DefaultTimeoutMS, c.Orchestrator.MaxTimeoutMS)
+	}
+
+	if c.Orchestrator.PriorityAgingMS < 0 {
+		return fmt.Errorf("invalid priority aging: %d", c.Orchestrator.PriorityAgingMS)
+	}
+
+	if c.Orchestrator.MaxTaskRetries < 0 {
+		return fmt.Errorf("invalid max task retries: %d", c.Orchestrator.MaxTaskRetries)
+	}
+
+	for operator, factor := range c.Orchestrator.SpeculationFactors {
+		if factor < 1 {
+			return fmt.Errorf("invalid speculation factor for operator %s: %v", operator, factor)
+		}
+	}
+
+	if c.Orchestrator.SpeculationMinDelayMS < 0 {
+		return fmt.Errorf("invalid speculation min delay: %d", c.Orchestrator.SpeculationMinDelayMS)
+	}
+
+	for login, weight := range c.Orchestrator.UserWeights {
+		if weight <= 0 {
+			return fmt.Errorf("invalid weight for user %s: %d", login, weight)
+		}
+	}
+
+	if c.Orchestrator.ShutdownDelayMS < 0 {
+		return fmt.Errorf("invalid shutdown delay: %d", c.Orchestrator.ShutdownDelayMS)
+	}
+
+	if c.Logging.MaxSize < 0 {
+		return fmt.Errorf("invalid logging max size: %d", c.Logging.MaxSize)
+	}
+
+	if c.Logging.MaxFiles < 0 {
+		return fmt.Errorf("invalid logging max files: %d", c.Logging.MaxFiles)
+	}
+
+	if c.Audit.MaxSize < 0 {
+		return fmt.Errorf("invalid audit max size: %d", c.Audit.MaxSize)
+	}
+
+	if c.Logging.Level != "" {
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(c.Logging.Level)); err != nil {
+			return fmt.Errorf("invalid logging level: %s", c.Logging.Level)
+		}
+	}
+
 	if c.Agent.ComputingPower <= 0 {
 		return fmt.Errorf("invalid computing power: %d", c.Agent.ComputingPower)
 	}
@@ -77,5 +312,74 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("invalid orchestrator URL: %s", c.Agent.OrchestratorURL)
 	}
 
+	if err := c.Agent.TLS.validate(); err != nil {
+		return fmt.Errorf("invalid agent tls: %w", err)
+	}
+
+	if c.Agent.Transport != TransportHTTP && c.Agent.Transport != TransportWebSocket {
+		return fmt.Errorf("invalid agent transport: %s", c.Agent.Transport)
+	}
+
+	if c.Agent.RetryInitialIntervalMS <= 0 || c.Agent.RetryMaxIntervalMS < c.Agent.RetryInitialIntervalMS {
+		return fmt.Errorf("invalid retry intervals: initial %d, max %d", c.Agent.RetryInitialIntervalMS, c.Agent.RetryMaxIntervalMS)
+	}
+
+	if c.Agent.RetryMultiplier < 1 {
+		return fmt.Errorf("invalid retry multiplier: %v", c.Agent.RetryMultiplier)
+	}
+
+	if c.Agent.RetryJitter < 0 || c.Agent.RetryJitter > 1 {
+		return fmt.Errorf("invalid retry jitter: %v", c.Agent.RetryJitter)
+	}
+
+	if c.Agent.BreakerFailureThreshold <= 0 {
+		return fmt.Errorf("invalid breaker failure threshold: %d", c.Agent.BreakerFailureThreshold)
+	}
+
+	if c.Agent.BreakerOpenTimeoutMS <= 0 {
+		return fmt.Errorf("invalid breaker open timeout: %d", c.Agent.BreakerOpenTimeoutMS)
+	}
+
+	if c.Agent.DrainTimeoutMS < 0 {
+		return fmt.Errorf("invalid drain timeout: %d", c.Agent.DrainTimeoutMS)
+	}
+
+	if c.Agent.Autoscale {
+		// Через WebSocket задачи присылает оркестратор, заголовка X-Backlog нет
+		if c.Agent.Transport == TransportWebSocket {
+			return fmt.Errorf("autoscale is not supported with %s transport", TransportWebSocket)
+		}
+
+		if c.Agent.MinWorkers <= 0 || c.Agent.MaxWorkers < c.Agent.MinWorkers {
+			return fmt.Errorf("invalid autoscale bounds: min %d, max %d", c.Agent.MinWorkers, c.Agent.MaxWorkers)
+		}
+
+		if c.Agent.AutoscaleIntervalMS <= 0 {
+			return fmt.Errorf("invalid autoscale interval: %d", c.Agent.AutoscaleIntervalMS)
+		}
+	}
+
+	switch c.Tracing.Exporter {
+	case TracingNone, TracingStdout:
+	case TracingFile:
+		if c.Tracing.File == "" {
+			return fmt.Errorf("tracing file is required for exporter %s", c.Tracing.Exporter)
+		}
+	case TracingOTLP:
+		if c.Tracing.Endpoint == "" {
+			return fmt.Errorf("tracing endpoint is required for exporter %s", c.Tracing.Exporter)
+		}
+	default:
+		return fmt.Errorf("invalid tracing exporter: %s", c.Tracing.Exporter)
+	}
+
+	return nil
+}
+
+// Сертификат и ключ задаются только вместе
+func (t TLSConfig) validate() error {
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		return fmt.Errorf("cert_file and key_file must be set together")
+	}
 	return nil
 }
@@ -183,6 +183,62 @@ orchestrator:
 			},
 			expectError: true,
 		},
+		{
+			name:       "AgentSecretWithAgentTokens",
+			configPath: "explicit_config.yml",
+			configType: "orchestrator",
+			setupFunc: func() (string, func()) {
+				dir, err := os.MkdirTemp("", "config-test")
+				if err != nil {
+					t.Fatalf("Failed to create temp dir: %v", err)
+				}
+
+				configPath := filepath.Join(dir, "explicit_config.yml")
+				configContent := `
+orchestrator:
+  agent_secret: "secret"
+  agent_tokens:
+    agent-1: "token1"
+`
+				err = os.WriteFile(configPath, []byte(configContent), 0644)
+				if err != nil {
+					t.Fatalf("Failed to write config file: %v", err)
+				}
+
+				return configPath, func() {
+					os.RemoveAll(dir)
+				}
+			},
+			expectError: true,
+		},
+		{
+			name:       "AutoscaleWithWebSocket",
+			configPath: "explicit_config.yml",
+			configType: "agent",
+			setupFunc: func() (string, func()) {
+				dir, err := os.MkdirTemp("", "config-test")
+				if err != nil {
+					t.Fatalf("Failed to create temp dir: %v", err)
+				}
+
+				configPath := filepath.Join(dir, "explicit_config.yml")
+				configContent := `
+agent:
+  orchestrator_url: "http://localhost:8080"
+  transport: "websocket"
+  autoscale: true
+`
+				err = os.WriteFile(configPath, []byte(configContent), 0644)
+				if err != nil {
+					t.Fatalf("Failed to write config file: %v", err)
+				}
+
+				return configPath, func() {
+					os.RemoveAll(dir)
+				}
+			},
+			expectError: true,
+		},
 		{
 			name:       "NonExistentConfig",
 			configPath: "does_not_exist.yml",
@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"strconv"
+	"strings"
 )
 
 // Загрузка конфигурационных переменных из окружения
@@ -37,16 +38,280 @@ func LoadFromEnv(config *Config) error {
 		}
 	}
 
+	if env := os.Getenv("SHUTDOWN_DELAY_MS"); env != "" {
+		if val, err := strconv.ParseInt(env, 10, 64); err == nil {
+			config.Orchestrator.ShutdownDelayMS = val
+		}
+	}
+
+	if env := os.Getenv("ORCHESTRATOR_ADMIN_ADDR"); env != "" {
+		config.Orchestrator.AdminAddr = env
+	}
+
+	if env := os.Getenv("ORCHESTRATOR_INTERNAL_PORT"); env != "" {
+		if val, err := strconv.Atoi(env); err == nil {
+			config.Orchestrator.InternalPort = val
+		}
+	}
+
+	if env := os.Getenv("AGENT_SECRET"); env != "" {
+		config.Orchestrator.AgentSecret = env
+	}
+
+	// Формат: agent-1=token1,agent-2=token2
+	if env := os.Getenv("AGENT_TOKENS"); env != "" {
+		config.Orchestrator.AgentTokens = make(map[string]string)
+		for _, item := range strings.Split(env, ",") {
+			if id, token, ok := strings.Cut(strings.TrimSpace(item), "="); ok && id != "" {
+				config.Orchestrator.AgentTokens[id] = token
+			}
+		}
+	}
+
+	if env := os.Getenv("RATE_LIMIT_RPS"); env != "" {
+		if val, err := strconv.ParseFloat(env, 64); err == nil {
+			config.Orchestrator.RateLimitRPS = val
+		}
+	}
+
+	if env := os.Getenv("RATE_LIMIT_BURST"); env != "" {
+		if val, err := strconv.Atoi(env); err == nil {
+			config.Orchestrator.RateLimitBurst = val
+		}
+	}
+
+	if env := os.Getenv("IP_RATE_LIMIT_RPS"); env != "" {
+		if val, err := strconv.ParseFloat(env, 64); err == nil {
+			config.Orchestrator.IPRateLimitRPS = val
+		}
+	}
+
+	if env := os.Getenv("IP_RATE_LIMIT_BURST"); env != "" {
+		if val, err := strconv.Atoi(env); err == nil {
+			config.Orchestrator.IPRateLimitBurst = val
+		}
+	}
+
+	if env := os.Getenv("MAX_ACTIVE_EXPRESSIONS"); env != "" {
+		if val, err := strconv.Atoi(env); err == nil {
+			config.Orchestrator.MaxActiveExpressions = val
+		}
+	}
+
+	if env := os.Getenv("MAX_EXPRESSION_NODES"); env != "" {
+		if val, err := strconv.Atoi(env); err == nil {
+			config.Orchestrator.MaxExpressionNodes = val
+		}
+	}
+
+	if env := os.Getenv("IP_MAX_ACTIVE_EXPRESSIONS"); env != "" {
+		if val, err := strconv.Atoi(env); err == nil {
+			config.Orchestrator.IPMaxActiveExpressions = val
+		}
+	}
+
+	if env := os.Getenv("IP_MAX_EXPRESSION_NODES"); env != "" {
+		if val, err := strconv.Atoi(env); err == nil {
+			config.Orchestrator.IPMaxExpressionNodes = val
+		}
+	}
+
+	if env := os.Getenv("MAX_QUEUED_EXPRESSIONS"); env != "" {
+		if val, err := strconv.Atoi(env); err == nil {
+			config.Orchestrator.MaxQueuedExpressions = val
+		}
+	}
+
+	if env := os.Getenv("MAX_PENDING_NODES"); env != "" {
+		if val, err := strconv.Atoi(env); err == nil {
+			config.Orchestrator.MaxPendingNodes = val
+		}
+	}
+
+	if env := os.Getenv("DEFAULT_TIMEOUT_MS"); env != "" {
+		if val, err := strconv.ParseInt(env, 10, 64); err == nil {
+			config.Orchestrator.DefaultTimeoutMS = val
+		}
+	}
+
+	if env := os.Getenv("MAX_TIMEOUT_MS"); env != "" {
+		if val, err := strconv.ParseInt(env, 10, 64); err == nil {
+			config.Orchestrator.MaxTimeoutMS = val
+		}
+	}
+
+	if env := os.Getenv("PRIORITY_AGING_MS"); env != "" {
+		if val, err := strconv.ParseInt(env, 10, 64); err == nil {
+			config.Orchestrator.PriorityAgingMS = val
+		}
+	}
+
+	if env := os.Getenv("MAX_TASK_RETRIES"); env != "" {
+		if val, err := strconv.Atoi(env); err == nil {
+			config.Orchestrator.MaxTaskRetries = val
+		}
+	}
+
+	if env := os.Getenv("SPECULATION_FACTORS"); env != "" {
+		config.Orchestrator.SpeculationFactors = make(map[string]float64)
+		for _, item := range strings.Split(env, ",") {
+			operator, factor, ok := strings.Cut(strings.TrimSpace(item), "=")
+			if !ok || operator == "" {
+				continue
+			}
+			if val, err := strconv.ParseFloat(factor, 64); err == nil {
+				config.Orchestrator.SpeculationFactors[operator] = val
+			}
+		}
+	}
+
+	if env := os.Getenv("SPECULATION_MIN_DELAY_MS"); env != "" {
+		if val, err := strconv.ParseInt(env, 10, 64); err == nil {
+			config.Orchestrator.SpeculationMinDelayMS = val
+		}
+	}
+
+	if env := os.Getenv("USER_WEIGHTS"); env != "" {
+		config.Orchestrator.UserWeights = make(map[string]int)
+		for _, item := range strings.Split(env, ",") {
+			login, weight, ok := strings.Cut(strings.TrimSpace(item), "=")
+			if !ok || login == "" {
+				continue
+			}
+			if val, err := strconv.Atoi(weight); err == nil {
+				config.Orchestrator.UserWeights[login] = val
+			}
+		}
+	}
+
+	if env := os.Getenv("ORCHESTRATOR_TLS_CERT"); env != "" {
+		config.Orchestrator.TLS.CertFile = env
+	}
+
+	if env := os.Getenv("ORCHESTRATOR_TLS_KEY"); env != "" {
+		config.Orchestrator.TLS.KeyFile = env
+	}
+
+	if env := os.Getenv("ORCHESTRATOR_TLS_CA"); env != "" {
+		config.Orchestrator.TLS.CAFile = env
+	}
+
+	if env := os.Getenv("JWT_SECRET"); env != "" {
+		config.Orchestrator.JWTSecret = env
+	}
+
+	if env := os.Getenv("TOKEN_TTL_MINUTES"); env != "" {
+		if val, err := strconv.ParseInt(env, 10, 64); err == nil {
+			config.Orchestrator.TokenTTLMinutes = val
+		}
+	}
+
 	if env := os.Getenv("ORCHESTRATOR_URL"); env != "" {
 		config.Agent.OrchestratorURL = env
 	}
 
+	if env := os.Getenv("AGENT_TLS_CERT"); env != "" {
+		config.Agent.TLS.CertFile = env
+	}
+
+	if env := os.Getenv("AGENT_TLS_KEY"); env != "" {
+		config.Agent.TLS.KeyFile = env
+	}
+
+	if env := os.Getenv("AGENT_TLS_CA"); env != "" {
+		config.Agent.TLS.CAFile = env
+	}
+
+	if env := os.Getenv("AGENT_ID"); env != "" {
+		config.Agent.ID = env
+	}
+
+	if env := os.Getenv("AGENT_TOKEN"); env != "" {
+		config.Agent.Token = env
+	}
+
 	if env := os.Getenv("COMPUTING_POWER"); env != "" {
 		if val, err := strconv.ParseInt(env, 10, 64); err == nil {
 			config.Agent.ComputingPower = val
 		}
 	}
 
+	if env := os.Getenv("AGENT_TRANSPORT"); env != "" {
+		config.Agent.Transport = env
+	}
+
+	if env := os.Getenv("RETRY_INITIAL_INTERVAL_MS"); env != "" {
+		if val, err := strconv.ParseInt(env, 10, 64); err == nil {
+			config.Agent.RetryInitialIntervalMS = val
+		}
+	}
+
+	if env := os.Getenv("RETRY_MAX_INTERVAL_MS"); env != "" {
+		if val, err := strconv.ParseInt(env, 10, 64); err == nil {
+			config.Agent.RetryMaxIntervalMS = val
+		}
+	}
+
+	if env := os.Getenv("RETRY_MULTIPLIER"); env != "" {
+		if val, err := strconv.ParseFloat(env, 64); err == nil {
+			config.Agent.RetryMultiplier = val
+		}
+	}
+
+	if env := os.Getenv("RETRY_JITTER"); env != "" {
+		if val, err := strconv.ParseFloat(env, 64); err == nil {
+			config.Agent.RetryJitter = val
+		}
+	}
+
+	if env := os.Getenv("BREAKER_FAILURE_THRESHOLD"); env != "" {
+		if val, err := strconv.Atoi(env); err == nil {
+			config.Agent.BreakerFailureThreshold = val
+		}
+	}
+
+	if env := os.Getenv("BREAKER_OPEN_TIMEOUT_MS"); env != "" {
+		if val, err := strconv.ParseInt(env, 10, 64); err == nil {
+			config.Agent.BreakerOpenTimeoutMS = val
+		}
+	}
+
+	if env := os.Getenv("DRAIN_TIMEOUT_MS"); env != "" {
+		if val, err := strconv.ParseInt(env, 10, 64); err == nil {
+			config.Agent.DrainTimeoutMS = val
+		}
+	}
+
+	if env := os.Getenv("AGENT_ADMIN_ADDR"); env != "" {
+		config.Agent.AdminAddr = env
+	}
+
+	if env := os.Getenv("AGENT_STATUS_ADDR"); env != "" {
+		config.Agent.StatusAddr = env
+	}
+
+	if env := os.Getenv("AUTOSCALE"); env != "" {
+		config.Agent.Autoscale = env == "true"
+	}
+
+	if env := os.Getenv("MIN_WORKERS"); env != "" {
+		if val, err := strconv.ParseInt(env, 10, 64); err == nil {
+			config.Agent.MinWorkers = val
+		}
+	}
+
+	if env := os.Getenv("MAX_WORKERS"); env != "" {
+		if val, err := strconv.ParseInt(env, 10, 64); err == nil {
+			config.Agent.MaxWorkers = val
+		}
+	}
+
+	if env := os.Getenv("AUTOSCALE_INTERVAL_MS"); env != "" {
+		if val, err := strconv.ParseInt(env, 10, 64); err == nil {
+			config.Agent.AutoscaleIntervalMS = val
+		}
+	}
+
 	if env := os.Getenv("TO_FILE"); env != "" {
 		config.Logging.ToFile = env == "true"
 	}
@@ -55,5 +320,63 @@ func LoadFromEnv(config *Config) error {
 		config.Logging.Dir = env
 	}
 
+	if env := os.Getenv("LOGGING_FORMAT"); env != "" {
+		config.Logging.Format = env
+	}
+
+	if env := os.Getenv("LOGGING_FILE_MAX_SIZE"); env != "" {
+		if val, err := strconv.Atoi(env); err == nil {
+			config.Logging.MaxSize = val
+		}
+	}
+
+	if env := os.Getenv("LOGGING_MAX_FILES"); env != "" {
+		if val, err := strconv.Atoi(env); err == nil {
+			config.Logging.MaxFiles = val
+		}
+	}
+
+	if env := os.Getenv("LOGGING_COMPRESS"); env != "" {
+		config.Logging.Compress = env == "true"
+	}
+
+	if env := os.Getenv("LOGGING_LEVEL"); env != "" {
+		config.Logging.Level = env
+	}
+
+	if env := os.Getenv("LOGGING_COMPONENT_LEVELS"); env != "" {
+		config.Logging.ComponentLevels = env
+	}
+
+	if env := os.Getenv("AUDIT_ENABLED"); env != "" {
+		config.Audit.Enabled = env == "true"
+	}
+
+	if env := os.Getenv("AUDIT_DIR"); env != "" {
+		config.Audit.Dir = env
+	}
+
+	if env := os.Getenv("AUDIT_MAX_SIZE"); env != "" {
+		if val, err := strconv.Atoi(env); err == nil {
+			config.Audit.MaxSize = val
+		}
+	}
+
+	if env := os.Getenv("AUDIT_COMPRESS"); env != "" {
+		config.Audit.Compress = env == "true"
+	}
+
+	if env := os.Getenv("TRACING_EXPORTER"); env != "" {
+		config.Tracing.Exporter = env
+	}
+
+	if env := os.Getenv("TRACING_FILE"); env != "" {
+		config.Tracing.File = env
+	}
+
+	if env := os.Getenv("TRACING_ENDPOINT"); env != "" {
+		config.Tracing.Endpoint = env
+	}
+
 	return nil
 }
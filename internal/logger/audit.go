@@ -0,0 +1,144 @@
+package logger
+
+import (
+	"context"
+	"encoding/json"
+	"final3/internal/config"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Действия с выражениями, попадающие в журнал аудита
+const (
+	AuditRegister = "register" // Регистрация пользователя
+	AuditLogin    = "login"    // Вход пользователя
+	AuditSubmit   = "submit"   // Отправка выражения на вычисление
+	AuditList     = "list"     // Просмотр списка выражений
+	AuditView     = "view"     // Просмотр выражения
+	AuditCancel   = "cancel"   // Отмена выражения
+	AuditDelete   = "delete"   // Удаление выражения
+
+	AuditAPIKeys      = "api_keys"       // Создание или просмотр API-ключей
+	AuditRevokeAPIKey = "revoke_api_key" // Отзыв API-ключа
+)
+
+// Итог действия
+const (
+	OutcomeSuccess = "success"
+	OutcomeDenied  = "denied"  // Отказ в доступе (401, 403)
+	OutcomeFailure = "failure" // Прочие ошибки
+)
+
+// Запись журнала аудита
+type AuditRecord struct {
+	Time         time.Time `json:"time"`
+	Action       string    `json:"action"`
+	User         string    `json:"user,omitempty"`
+	APIKeyID     int32     `json:"api_key_id,omitempty"` // Ключ, которым выполнен запрос
+	ExpressionID int32     `json:"expression_id,omitempty"`
+	RemoteAddr   string    `json:"remote_addr"`
+	Status       int       `json:"status"`
+	Outcome      string    `json:"outcome"`
+	RequestID    string    `json:"request_id,omitempty"`
+	TraceID      string    `json:"trace_id,omitempty"`
+}
+
+var (
+	auditMu     sync.Mutex
+	auditWriter io.Writer
+	auditCloser io.Closer
+)
+
+// Включение журнала аудита: файлы audit-<дата>.log в формате JSON lines, которые никогда не удаляются
+func InitAudit(cfg *config.Config) error {
+	if !cfg.Audit.Enabled {
+		return nil
+	}
+
+	dir := cfg.Audit.Dir
+	if dir == "" {
+		dir = cfg.Logging.Dir
+	}
+	if dir == "" {
+		dir = "./logs"
+	}
+
+	writer, err := NewRotatingWriter(RotateOptions{
+		Dir:       dir,
+		Prefix:    "audit-",
+		MaxSizeMB: cfg.Audit.MaxSize,
+		Compress:  cfg.Audit.Compress,
+	})
+	if err != nil {
+		return err
+	}
+
+	setAuditWriter(writer, writer)
+	Info("Audit log initialized", "dir", dir)
+	return nil
+}
+
+func setAuditWriter(w io.Writer, closer io.Closer) {
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	auditWriter = w
+	auditCloser = closer
+}
+
+// Закрытие журнала аудита
+func CloseAudit() error {
+	auditMu.Lock()
+	defer auditMu.Unlock()
+
+	var err error
+	if auditCloser != nil {
+		err = auditCloser.Close()
+	}
+	auditWriter, auditCloser = nil, nil
+	return err
+}
+
+// Итог действия по коду HTTP-ответа
+func auditOutcome(status int) string {
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return OutcomeDenied
+	case status >= 400:
+		return OutcomeFailure
+	}
+	return OutcomeSuccess
+}
+
+// Запись в журнал аудита с временем, итогом и идентификаторами запроса из ctx
+func Audit(ctx context.Context, record AuditRecord) {
+	if record.Time.IsZero() {
+		record.Time = time.Now().UTC()
+	}
+	if record.Outcome == "" {
+		record.Outcome = auditOutcome(record.Status)
+	}
+	if record.RequestID == "" {
+		record.RequestID = RequestIDFromContext(ctx)
+	}
+	if record.TraceID == "" {
+		record.TraceID = TraceIDFromContext(ctx)
+	}
+
+	auditMu.Lock()
+	defer auditMu.Unlock()
+
+	if auditWriter == nil {
+		return
+	}
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		ErrorContext(ctx, "Failed to encode audit record", "error", err)
+		return
+	}
+	if _, err := auditWriter.Write(append(line, '\n')); err != nil {
+		ErrorContext(ctx, "Failed to write audit record", "error", err)
+	}
+}
@@ -0,0 +1,95 @@
+package logger
+
+import (
+	"context"
+	"log/slog"
+)
+
+type contextKey int
+
+const (
+	requestIDKey contextKey = iota
+	traceIDKey
+	expressionIDKey
+	taskIDKey
+)
+
+// Поля контекста, автоматически добавляемые к записям *Context-функций
+var contextFields = []struct {
+	key  contextKey
+	name string
+}{
+	{requestIDKey, "request_id"},
+	{traceIDKey, "trace_id"},
+	{expressionIDKey, "expression_id"},
+	{taskIDKey, "task_id"},
+}
+
+// Идентификатор HTTP-запроса
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// Сквозной идентификатор выражения: идентификатор запроса, которым оно было создано
+func ContextWithTraceID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, traceIDKey, id)
+}
+
+func TraceIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(traceIDKey).(string)
+	return id
+}
+
+func ContextWithExpressionID(ctx context.Context, id int32) context.Context {
+	return context.WithValue(ctx, expressionIDKey, id)
+}
+
+func ContextWithTaskID(ctx context.Context, id int) context.Context {
+	return context.WithValue(ctx, taskIDKey, id)
+}
+
+// Хендлер, дополняющий записи полями из контекста (если они не указаны явно)
+type contextHandler struct {
+	slog.Handler
+}
+
+func newContextHandler(handler slog.Handler) slog.Handler {
+	return &contextHandler{Handler: handler}
+}
+
+func (h *contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if ctx == nil {
+		return h.Handler.Handle(ctx, r)
+	}
+
+	for _, field := range contextFields {
+		value := ctx.Value(field.key)
+		if value == nil || hasAttr(r, field.name) {
+			continue
+		}
+		r.AddAttrs(slog.Any(field.name, value))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithGroup(name)}
+}
+
+func hasAttr(r slog.Record, key string) bool {
+	found := false
+	r.Attrs(func(a slog.Attr) bool {
+		found = a.Key == key
+		return !found
+	})
+	return found
+}
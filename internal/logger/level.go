@@ -0,0 +1,249 @@
+package logger
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Компоненты с отдельно настраиваемым уровнем логирования
+const (
+	ComponentParser    = "parser"    // Разбор выражений
+	ComponentScheduler = "scheduler" // Выдача задач и обработка результатов в оркестраторе
+	ComponentWorker    = "worker"    // Воркеры агента
+)
+
+var (
+	level slog.LevelVar // Общий уровень логирования
+
+	componentsMu    sync.RWMutex
+	componentLevels = make(map[string]slog.Level) // Уровни компонентов, отличные от общего
+	components      = make(map[string]componentLogger)
+)
+
+// Разбор уровня логирования: debug, info, warn, error (допускается смещение, например debug+2)
+func ParseLevel(s string) (slog.Level, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("invalid log level %q", s)
+	}
+	return l, nil
+}
+
+// Изменение общего уровня логирования
+func SetLevel(l slog.Level) {
+	level.Set(l)
+}
+
+func GetLevel() slog.Level {
+	return level.Level()
+}
+
+// Изменение уровня логирования компонента
+func SetComponentLevel(component string, l slog.Level) {
+	componentsMu.Lock()
+	defer componentsMu.Unlock()
+	componentLevels[component] = l
+}
+
+// Возврат компонента к общему уровню логирования
+func ResetComponentLevel(component string) {
+	componentsMu.Lock()
+	defer componentsMu.Unlock()
+	delete(componentLevels, component)
+}
+
+// Уровни компонентов, отличные от общего
+func ComponentLevels() map[string]slog.Level {
+	componentsMu.RLock()
+	defer componentsMu.RUnlock()
+
+	levels := make(map[string]slog.Level, len(componentLevels))
+	for component, l := range componentLevels {
+		levels[component] = l
+	}
+	return levels
+}
+
+// Применение уровней из конфига: общего (пусто - info) и компонентов в формате "parser=debug,worker=warn"
+func configureLevels(global, perComponent string) error {
+	l := slog.LevelInfo
+	if global != "" {
+		var err error
+		if l, err = ParseLevel(global); err != nil {
+			return err
+		}
+	}
+
+	levels := make(map[string]slog.Level)
+	for _, item := range strings.Split(perComponent, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		component, value, ok := strings.Cut(item, "=")
+		if !ok {
+			return fmt.Errorf("invalid component log level %q, expected component=level", item)
+		}
+
+		componentLevel, err := ParseLevel(strings.TrimSpace(value))
+		if err != nil {
+			return err
+		}
+		levels[strings.TrimSpace(component)] = componentLevel
+	}
+
+	SetLevel(l)
+	componentsMu.Lock()
+	componentLevels = levels
+	componentsMu.Unlock()
+	return nil
+}
+
+// Логер компонента, созданный поверх хендлера base
+type componentLogger struct {
+	base   slog.Handler
+	logger *slog.Logger
+}
+
+// Уровень компонента: собственный, если задан, иначе общий
+type componentLeveler string
+
+func (c componentLeveler) Level() slog.Level {
+	componentsMu.RLock()
+	l, ok := componentLevels[string(c)]
+	componentsMu.RUnlock()
+
+	if ok {
+		return l
+	}
+	return level.Level()
+}
+
+// Хендлер, отбрасывающий записи ниже заданного уровня
+type levelHandler struct {
+	slog.Handler
+	level slog.Leveler
+}
+
+func (h *levelHandler) Enabled(ctx context.Context, l slog.Level) bool {
+	return l >= h.level.Level() && h.Handler.Enabled(ctx, l)
+}
+
+func (h *levelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &levelHandler{Handler: h.Handler.WithAttrs(attrs), level: h.level}
+}
+
+func (h *levelHandler) WithGroup(name string) slog.Handler {
+	return &levelHandler{Handler: h.Handler.WithGroup(name), level: h.level}
+}
+
+// Логер компонента: записи помечаются атрибутом component, уровень настраивается
+// отдельно через SetComponentLevel. Атрибуты не группируются под именем компонента,
+// чтобы поля контекста (request_id, trace_id и др.) оставались на верхнем уровне записи
+func Component(name string) *slog.Logger {
+	base := getBaseHandler()
+
+	componentsMu.RLock()
+	c, ok := components[name]
+	componentsMu.RUnlock()
+	if ok && c.base == base {
+		return c.logger
+	}
+
+	c = componentLogger{
+		base:   base,
+		logger: slog.New(&levelHandler{Handler: base.WithAttrs([]slog.Attr{slog.String("component", name)}), level: componentLeveler(name)}),
+	}
+
+	componentsMu.Lock()
+	components[name] = c
+	componentsMu.Unlock()
+	return c.logger
+}
+
+// HTTP-обработчик просмотра (GET) и изменения (PUT) уровней логирования.
+// Тело PUT: {"level": "debug", "components": {"parser": "warn", "worker": ""}},
+// пустой уровень компонента возвращает его к общему
+func LevelHandler(w http.ResponseWriter, r *http.Request) {
+	type levelsBody struct {
+		Level      string            `json:"level,omitempty"`
+		Components map[string]string `json:"components,omitempty"`
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		if r.Header.Get("Content-Type") != "application/json" {
+			http.Error(w, "Wrong content-type, expected JSON", http.StatusUnprocessableEntity)
+			return
+		}
+		defer r.Body.Close()
+
+		var req levelsBody
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+
+		var global *slog.Level
+		if req.Level != "" {
+			l, err := ParseLevel(req.Level)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+				return
+			}
+			global = &l
+		}
+
+		updates := make(map[string]*slog.Level, len(req.Components))
+		for component, value := range req.Components {
+			if value == "" {
+				updates[component] = nil
+				continue
+			}
+
+			l, err := ParseLevel(value)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+				return
+			}
+			updates[component] = &l
+		}
+
+		if global != nil {
+			SetLevel(*global)
+		}
+		for component, l := range updates {
+			if l == nil {
+				ResetComponentLevel(component)
+			} else {
+				SetComponentLevel(component, *l)
+			}
+		}
+
+		Info("Log levels changed via admin endpoint",
+			"level", GetLevel().String(),
+			"components", req.Components,
+			"remote_addr", r.RemoteAddr)
+	default:
+		http.Error(w, "Wrong method, expected GET or PUT", http.StatusUnprocessableEntity)
+		return
+	}
+
+	resp := levelsBody{
+		Level:      GetLevel().String(),
+		Components: make(map[string]string),
+	}
+	for component, l := range ComponentLevels() {
+		resp.Components[component] = l.String()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
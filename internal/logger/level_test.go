@@ -0,0 +1,215 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Подмена хендлера записи на буфер с восстановлением уровней после теста
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+
+	buf := &bytes.Buffer{}
+	baseMu.Lock()
+	oldBase := base
+	base = newContextHandler(slog.NewJSONHandler(buf, &slog.HandlerOptions{Level: minLevel}))
+	baseMu.Unlock()
+
+	oldLevel := GetLevel()
+	t.Cleanup(func() {
+		baseMu.Lock()
+		base = oldBase
+		baseMu.Unlock()
+		configureLevels(oldLevel.String(), "")
+	})
+	return buf
+}
+
+func TestConfigureLevels(t *testing.T) {
+	t.Cleanup(func() { configureLevels("info", "") })
+
+	tests := []struct {
+		name        string
+		global      string
+		components  string
+		wantLevel   slog.Level
+		wantLevels  map[string]slog.Level
+		expectError bool
+	}{
+		{
+			name:       "Default",
+			wantLevel:  slog.LevelInfo,
+			wantLevels: map[string]slog.Level{},
+		},
+		{
+			name:       "WithComponents",
+			global:     "warn",
+			components: "parser=debug, worker=error",
+			wantLevel:  slog.LevelWarn,
+			wantLevels: map[string]slog.Level{
+				ComponentParser: slog.LevelDebug,
+				ComponentWorker: slog.LevelError,
+			},
+		},
+		{
+			name:        "InvalidGlobal",
+			global:      "verbose",
+			expectError: true,
+		},
+		{
+			name:        "InvalidComponent",
+			global:      "info",
+			components:  "parser",
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configureLevels("info", "")
+
+			err := configureLevels(tt.global, tt.components)
+			if (err != nil) != tt.expectError {
+				t.Fatalf("configureLevels() error = %v, expectError %v", err, tt.expectError)
+			}
+			if tt.expectError {
+				return
+			}
+
+			if got := GetLevel(); got != tt.wantLevel {
+				t.Errorf("GetLevel() = %v, want %v", got, tt.wantLevel)
+			}
+			got := ComponentLevels()
+			if len(got) != len(tt.wantLevels) {
+				t.Fatalf("ComponentLevels() = %v, want %v", got, tt.wantLevels)
+			}
+			for component, l := range tt.wantLevels {
+				if got[component] != l {
+					t.Errorf("level of %s = %v, want %v", component, got[component], l)
+				}
+			}
+		})
+	}
+}
+
+func TestComponentLevels(t *testing.T) {
+	buf := captureLogs(t)
+	if err := configureLevels("info", "parser=debug"); err != nil {
+		t.Fatal(err)
+	}
+
+	Component(ComponentParser).Debug("parser debug")
+	Component(ComponentWorker).Debug("worker debug")
+	Component(ComponentWorker).Info("worker info")
+
+	out := buf.String()
+	if !strings.Contains(out, "parser debug") {
+		t.Error("parser debug record was filtered out")
+	}
+	if strings.Contains(out, "worker debug") {
+		t.Error("worker debug record was not filtered out")
+	}
+	if !strings.Contains(out, `"component":"worker"`) {
+		t.Errorf("component attribute is missing: %s", out)
+	}
+
+	// Изменение уровня применяется к уже созданным логерам
+	buf.Reset()
+	worker := Component(ComponentWorker)
+	SetComponentLevel(ComponentWorker, slog.LevelDebug)
+	worker.Debug("worker debug")
+	ResetComponentLevel(ComponentParser)
+	Component(ComponentParser).Debug("parser debug")
+
+	out = buf.String()
+	if !strings.Contains(out, "worker debug") {
+		t.Error("worker debug record was filtered out after SetComponentLevel")
+	}
+	if strings.Contains(out, "parser debug") {
+		t.Error("parser debug record was not filtered out after ResetComponentLevel")
+	}
+}
+
+func TestLevelHandler(t *testing.T) {
+	captureLogs(t)
+	if err := configureLevels("info", "worker=warn"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name           string
+		method         string
+		body           string
+		wantStatus     int
+		wantLevel      string
+		wantComponents map[string]string
+	}{
+		{
+			name:           "Get",
+			method:         http.MethodGet,
+			wantStatus:     http.StatusOK,
+			wantLevel:      "INFO",
+			wantComponents: map[string]string{"worker": "WARN"},
+		},
+		{
+			name:           "SetLevels",
+			method:         http.MethodPut,
+			body:           `{"level": "debug", "components": {"parser": "error", "worker": ""}}`,
+			wantStatus:     http.StatusOK,
+			wantLevel:      "DEBUG",
+			wantComponents: map[string]string{"parser": "ERROR"},
+		},
+		{
+			name:       "InvalidLevel",
+			method:     http.MethodPut,
+			body:       `{"level": "loud"}`,
+			wantStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:       "WrongMethod",
+			method:     http.MethodPost,
+			wantStatus: http.StatusUnprocessableEntity,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/log-level", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			rec := httptest.NewRecorder()
+
+			LevelHandler(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+
+			var resp struct {
+				Level      string            `json:"level"`
+				Components map[string]string `json:"components"`
+			}
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if resp.Level != tt.wantLevel {
+				t.Errorf("level = %s, want %s", resp.Level, tt.wantLevel)
+			}
+			if len(resp.Components) != len(tt.wantComponents) {
+				t.Fatalf("components = %v, want %v", resp.Components, tt.wantComponents)
+			}
+			for component, l := range tt.wantComponents {
+				if resp.Components[component] != l {
+					t.Errorf("level of %s = %s, want %s", component, resp.Components[component], l)
+				}
+			}
+		})
+	}
+}
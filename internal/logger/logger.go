@@ -4,14 +4,18 @@ import (
 	"context"
 	"final3/internal/config"
 	"log/slog"
+	"math"
 	"os"
-	"path/filepath"
 	"sync"
-	"time"
 )
 
+// Хендлеры записи пропускают все уровни, фильтрация выполняется levelHandler
+const minLevel = slog.Level(math.MinInt)
+
 var (
 	instance *slog.Logger
+	base     slog.Handler // Хендлер без фильтрации по уровню, общий для основного логера и компонентов
+	baseMu   sync.Mutex
 	once     sync.Once
 )
 
@@ -25,15 +29,19 @@ func Init(cfg *config.Config) error {
 
 // Создание нового логера с заданным конфигом
 func newLogger(cfg *config.Config) (*slog.Logger, error) {
+	if err := configureLevels(cfg.Logging.Level, cfg.Logging.ComponentLevels); err != nil {
+		return nil, err
+	}
+
 	var handler slog.Handler
 
 	if cfg.Logging.Format == "json" {
 		handler = slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
-			Level: slog.LevelInfo,
+			Level: minLevel,
 		})
 	} else {
 		handler = slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
-			Level: slog.LevelInfo,
+			Level: minLevel,
 		})
 	}
 
@@ -46,13 +54,19 @@ func newLogger(cfg *config.Config) (*slog.Logger, error) {
 		handler = newMultiHandler(handler, fileHandler)
 	}
 
-	logger := slog.New(handler)
+	baseMu.Lock()
+	base = newContextHandler(handler)
+	baseMu.Unlock()
+
+	logger := slog.New(&levelHandler{Handler: base, level: &level})
 
 	slog.SetDefault(logger)
 
 	logger.Info("Logger initialized",
 		"format", cfg.Logging.Format,
 		"to_file", cfg.Logging.ToFile,
+		"level", GetLevel().String(),
+		"component_levels", cfg.Logging.ComponentLevels,
 	)
 	return logger, nil
 }
@@ -70,20 +84,24 @@ func createFileHandler(cfg *config.Config) (slog.Handler, error) {
 		return nil, err
 	}
 
-	fileName := filepath.Join(cfg.Logging.Dir, time.Now().Format("2006-01-02"+".log"))
-	file, err := os.OpenFile(fileName, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	file, err := NewRotatingWriter(RotateOptions{
+		Dir:       logDir,
+		MaxSizeMB: cfg.Logging.MaxSize,
+		MaxFiles:  cfg.Logging.MaxFiles,
+		Compress:  cfg.Logging.Compress,
+	})
 	if err != nil {
 		return nil, err
 	}
 
 	if cfg.Logging.Format == "json" {
 		return slog.NewJSONHandler(file, &slog.HandlerOptions{
-			Level: slog.LevelInfo,
+			Level: minLevel,
 		}), nil
 	}
 
 	return slog.NewTextHandler(file, &slog.HandlerOptions{
-		Level: slog.LevelInfo,
+		Level: minLevel,
 	}), nil
 }
 
@@ -146,6 +164,22 @@ func Error(msg string, args ...any) {
 	getLogger().Error(msg, args...)
 }
 
+func DebugContext(ctx context.Context, msg string, args ...any) {
+	getLogger().DebugContext(ctx, msg, args...)
+}
+
+func InfoContext(ctx context.Context, msg string, args ...any) {
+	getLogger().InfoContext(ctx, msg, args...)
+}
+
+func WarnContext(ctx context.Context, msg string, args ...any) {
+	getLogger().WarnContext(ctx, msg, args...)
+}
+
+func ErrorContext(ctx context.Context, msg string, args ...any) {
+	getLogger().ErrorContext(ctx, msg, args...)
+}
+
 func WithGroup(name string) *slog.Logger {
 	return getLogger().WithGroup(name)
 }
@@ -156,7 +190,17 @@ func With(args ...any) *slog.Logger {
 
 func getLogger() *slog.Logger {
 	if instance == nil {
-		instance = slog.Default()
+		instance = slog.New(&levelHandler{Handler: getBaseHandler(), level: &level})
 	}
 	return instance
 }
+
+func getBaseHandler() slog.Handler {
+	baseMu.Lock()
+	defer baseMu.Unlock()
+
+	if base == nil {
+		base = newContextHandler(slog.Default().Handler())
+	}
+	return base
+}
@@ -0,0 +1,247 @@
+package logger
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	dateLayout   = "2006-01-02"
+	backupLayout = "150405.000"
+)
+
+// Параметры ротации файлов лога
+type RotateOptions struct {
+	Dir       string // Директория файлов
+	Prefix    string // Префикс имени файла: <prefix><дата>.log
+	MaxSizeMB int    // Размер файла, после которого начинается новый (0 - без ограничения)
+	MaxFiles  int    // Количество хранимых старых файлов (0 - хранить все)
+	Compress  bool   // Сжимать старые файлы gzip
+}
+
+// Запись в файл <prefix><дата>.log со сменой файла при смене даты и превышении размера.
+// Старые файлы (<prefix><дата>.<время>.log при ротации по размеру) по желанию сжимаются,
+// сверх MaxFiles - удаляются
+type RotatingWriter struct {
+	opts    RotateOptions
+	maxSize int64
+	pattern *regexp.Regexp
+	now     func() time.Time
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+	day  string
+
+	wg sync.WaitGroup // Фоновые сжатие и удаление старых файлов
+}
+
+func NewRotatingWriter(opts RotateOptions) (*RotatingWriter, error) {
+	return newRotatingWriter(opts, time.Now)
+}
+
+func newRotatingWriter(opts RotateOptions, now func() time.Time) (*RotatingWriter, error) {
+	if err := os.MkdirAll(opts.Dir, 0755); err != nil {
+		return nil, err
+	}
+
+	w := &RotatingWriter{
+		opts:    opts,
+		maxSize: int64(opts.MaxSizeMB) * 1024 * 1024,
+		pattern: regexp.MustCompile(`^` + regexp.QuoteMeta(opts.Prefix) + `\d{4}-\d{2}-\d{2}(\.\d{6}\.\d{3})?\.log(\.gz)?$`),
+		now:     now,
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *RotatingWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return 0, os.ErrClosed
+	}
+
+	if day := w.now().Format(dateLayout); day != w.day {
+		if err := w.rotate(""); err != nil {
+			return 0, err
+		}
+	} else if w.maxSize > 0 && w.size > 0 && w.size+int64(len(p)) > w.maxSize {
+		if err := w.rotate(w.backupName()); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// Закрытие текущего файла с ожиданием фоновых операций
+func (w *RotatingWriter) Close() error {
+	w.mu.Lock()
+	var err error
+	if w.file != nil {
+		err = w.file.Close()
+		w.file = nil
+	}
+	w.mu.Unlock()
+
+	w.wg.Wait()
+	return err
+}
+
+func (w *RotatingWriter) activeName(day string) string {
+	return filepath.Join(w.opts.Dir, w.opts.Prefix+day+".log")
+}
+
+func (w *RotatingWriter) backupName() string {
+	return filepath.Join(w.opts.Dir, w.opts.Prefix+w.day+"."+w.now().Format(backupLayout)+".log")
+}
+
+// Открытие файла текущей даты (вызывается под w.mu)
+func (w *RotatingWriter) open() error {
+	day := w.now().Format(dateLayout)
+	file, err := os.OpenFile(w.activeName(day), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	w.file = file
+	w.size = info.Size()
+	w.day = day
+	return nil
+}
+
+// Закрытие текущего файла и открытие нового. Если backup не пуст, текущий файл
+// переименовывается в него (ротация по размеру) (вызывается под w.mu)
+func (w *RotatingWriter) rotate(backup string) error {
+	old := w.activeName(w.day)
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+	w.file = nil
+
+	if backup != "" {
+		if err := os.Rename(old, backup); err != nil {
+			return err
+		}
+		old = backup
+	}
+
+	if err := w.open(); err != nil {
+		return err
+	}
+
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		w.cleanup(old)
+	}()
+	return nil
+}
+
+// Сжатие старого файла и удаление файлов сверх MaxFiles
+func (w *RotatingWriter) cleanup(old string) {
+	if w.opts.Compress {
+		if err := compressFile(old); err != nil {
+			fmt.Fprintln(os.Stderr, "Error compressing log file:", err)
+		}
+	}
+
+	if w.opts.MaxFiles <= 0 {
+		return
+	}
+
+	w.mu.Lock()
+	active := ""
+	if w.file != nil {
+		active = filepath.Base(w.file.Name())
+	}
+	w.mu.Unlock()
+
+	entries, err := os.ReadDir(w.opts.Dir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error reading log directory:", err)
+		return
+	}
+
+	var backups []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || name == active || !w.pattern.MatchString(name) {
+			continue
+		}
+		// Несжатый файл, который ещё сжимается, учитывается как его будущий .gz
+		if w.opts.Compress && !strings.HasSuffix(name, ".gz") {
+			continue
+		}
+		backups = append(backups, name)
+	}
+
+	// Имена упорядочены по времени: <дата>.<время>.log раньше <дата>.log той же даты
+	sort.Slice(backups, func(i, j int) bool {
+		return strings.TrimSuffix(backups[i], ".gz") < strings.TrimSuffix(backups[j], ".gz")
+	})
+
+	for len(backups) > w.opts.MaxFiles {
+		if err := os.Remove(filepath.Join(w.opts.Dir, backups[0])); err != nil && !os.IsNotExist(err) {
+			fmt.Fprintln(os.Stderr, "Error removing old log file:", err)
+		}
+		backups = backups[1:]
+	}
+}
+
+// Сжатие файла path в path.gz с удалением исходного
+func compressFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(dst)
+	if _, err := io.Copy(gz, src); err != nil {
+		gz.Close()
+		dst.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		dst.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+
+	src.Close()
+	return os.Remove(path)
+}
@@ -0,0 +1,136 @@
+package logger
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Управляемые часы для RotatingWriter
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Add(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func newTestWriter(t *testing.T, opts RotateOptions) (*RotatingWriter, *fakeClock) {
+	t.Helper()
+
+	clock := &fakeClock{now: time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC)}
+	opts.Dir = t.TempDir()
+
+	w, err := newRotatingWriter(opts, clock.Now)
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+
+	return w, clock
+}
+
+func listFiles(t *testing.T, dir string) []string {
+	t.Helper()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("Failed to read dir: %v", err)
+	}
+
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestRotatingWriter(t *testing.T) {
+	line := []byte(strings.Repeat("x", 400*1024) + "\n")
+
+	t.Run("RotateBySize", func(t *testing.T) {
+		w, clock := newTestWriter(t, RotateOptions{Prefix: "app-", MaxSizeMB: 1})
+
+		for i := 0; i < 3; i++ {
+			w.Write(line)
+			clock.Add(time.Second)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("Close failed: %v", err)
+		}
+
+		files := listFiles(t, w.opts.Dir)
+		expected := []string{"app-2025-03-01.100002.000.log", "app-2025-03-01.log"}
+		if strings.Join(files, ",") != strings.Join(expected, ",") {
+			t.Errorf("Expected files %v, got %v", expected, files)
+		}
+	})
+
+	t.Run("RotateByDate", func(t *testing.T) {
+		w, clock := newTestWriter(t, RotateOptions{})
+
+		w.Write([]byte("first day\n"))
+		clock.Add(24 * time.Hour)
+		w.Write([]byte("second day\n"))
+		w.Close()
+
+		files := listFiles(t, w.opts.Dir)
+		expected := []string{"2025-03-01.log", "2025-03-02.log"}
+		if strings.Join(files, ",") != strings.Join(expected, ",") {
+			t.Errorf("Expected files %v, got %v", expected, files)
+		}
+
+		data, _ := os.ReadFile(filepath.Join(w.opts.Dir, "2025-03-02.log"))
+		if string(data) != "second day\n" {
+			t.Errorf("Unexpected content of new file: %q", data)
+		}
+	})
+
+	t.Run("CompressAndRetention", func(t *testing.T) {
+		w, clock := newTestWriter(t, RotateOptions{MaxFiles: 2, Compress: true})
+
+		for day := 0; day < 4; day++ {
+			w.Write([]byte("day\n"))
+			w.wg.Wait()
+			clock.Add(24 * time.Hour)
+		}
+		w.Write([]byte("today\n"))
+		w.Close()
+
+		files := listFiles(t, w.opts.Dir)
+		expected := []string{"2025-03-03.log.gz", "2025-03-04.log.gz", "2025-03-05.log"}
+		if strings.Join(files, ",") != strings.Join(expected, ",") {
+			t.Fatalf("Expected files %v, got %v", expected, files)
+		}
+
+		file, err := os.Open(filepath.Join(w.opts.Dir, "2025-03-04.log.gz"))
+		if err != nil {
+			t.Fatalf("Failed to open compressed file: %v", err)
+		}
+		defer file.Close()
+
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			t.Fatalf("Failed to read gzip: %v", err)
+		}
+		data, _ := io.ReadAll(gz)
+		if string(data) != "day\n" {
+			t.Errorf("Unexpected compressed content: %q", data)
+		}
+	})
+}
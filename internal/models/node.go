@@ -32,3 +32,11 @@ func NewNode(value string) *Node {
 		Status:       StatusCreated,
 	}
 }
+
+// Готов ли узел-операция к вычислению (оба аргумента уже вычислены)
+func (n *Node) IsReady() bool {
+	if len(n.Dependencies) < 2 {
+		return false
+	}
+	return n.Dependencies[0].Type == Number && n.Dependencies[1].Type == Number
+}
@@ -0,0 +1,34 @@
+package models
+
+import "time"
+
+// Задача, отправляемая оркестратором агенту
+type Task struct {
+	ID            int           `json:"id"`
+	ExpressionID  int32         `json:"expression_id"`
+	Arg1          float64       `json:"arg1"`
+	Arg2          float64       `json:"arg2"`
+	Operation     string        `json:"operation"`
+	OperationTime time.Duration `json:"operation_time"`
+	TraceID       string        `json:"trace_id,omitempty"`    // Сквозной идентификатор выражения для логов
+	Traceparent   string        `json:"traceparent,omitempty"` // W3C-контекст спана задачи
+}
+
+// Класс ошибки выполнения задачи
+type ErrorClass string
+
+const (
+	// Детерминированная ошибка вычисления (например, деление на ноль): повтор даст тот же результат
+	ErrorMath ErrorClass = "math"
+	// Временный сбой агента: задачу можно повторить на другом агенте
+	ErrorTransient ErrorClass = "transient"
+)
+
+// Результат выполнения задачи, возвращаемый агентом оркестратору
+type TaskResult struct {
+	ID           int        `json:"id"`
+	ExpressionID int32      `json:"expression_id"`
+	Result       float64    `json:"result"`
+	Error        string     `json:"error,omitempty"`
+	ErrorClass   ErrorClass `json:"error_class,omitempty"` // Без класса ошибка считается ErrorMath
+}
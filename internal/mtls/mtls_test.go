@@ -0,0 +1,205 @@
+package mtls
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"final3/internal/config"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Временный центр сертификации, выпускающий сертификаты в каталог теста
+type testCA struct {
+	t    *testing.T
+	dir  string
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	file string
+}
+
+func newTestCA(t *testing.T, dir, name string) *testCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate CA key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create CA certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Failed to parse CA certificate: %v", err)
+	}
+
+	ca := &testCA{t: t, dir: dir, cert: cert, key: key, file: filepath.Join(dir, name+".pem")}
+	writePEM(t, ca.file, "CERTIFICATE", der)
+	return ca
+}
+
+// Выпуск сертификата с заданным Common Name, возвращает пути к сертификату и ключу
+func (ca *testCA) issue(name string, usage x509.ExtKeyUsage) (string, string) {
+	ca.t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		ca.t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		ca.t.Fatalf("Failed to generate serial: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		DNSNames:     []string{"localhost"},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		ca.t.Fatalf("Failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		ca.t.Fatalf("Failed to marshal key: %v", err)
+	}
+
+	certFile := filepath.Join(ca.dir, name+".crt")
+	keyFile := filepath.Join(ca.dir, name+".key")
+	writePEM(ca.t, certFile, "CERTIFICATE", der)
+	writePEM(ca.t, keyFile, "EC PRIVATE KEY", keyDER)
+	return certFile, keyFile
+}
+
+func writePEM(t *testing.T, path, blockType string, der []byte) {
+	t.Helper()
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("Failed to write %s: %v", path, err)
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, dir, "test-ca")
+	otherCA := newTestCA(t, dir, "other-ca")
+
+	serverCert, serverKey := ca.issue("orchestrator", x509.ExtKeyUsageServerAuth)
+	agentCert, agentKey := ca.issue("agent-1", x509.ExtKeyUsageClientAuth)
+	strangerCert, strangerKey := otherCA.issue("agent-2", x509.ExtKeyUsageClientAuth)
+
+	serverConfig, err := ServerConfig(config.TLSConfig{CertFile: serverCert, KeyFile: serverKey, CAFile: ca.file})
+	if err != nil {
+		t.Fatalf("Failed to create server TLS config: %v", err)
+	}
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, PeerIdentity(r.TLS))
+	}))
+	server.TLS = serverConfig
+	server.StartTLS()
+	defer server.Close()
+
+	tests := []struct {
+		name        string
+		cfg         config.TLSConfig
+		identity    string
+		expectError bool
+	}{
+		{"ClientCertificate", config.TLSConfig{CertFile: agentCert, KeyFile: agentKey, CAFile: ca.file}, "agent-1", false},
+		{"NoClientCertificate", config.TLSConfig{CAFile: ca.file}, "", true},
+		{"UntrustedClientCertificate", config.TLSConfig{CertFile: strangerCert, KeyFile: strangerKey, CAFile: ca.file}, "", true},
+		{"UntrustedServer", config.TLSConfig{CertFile: agentCert, KeyFile: agentKey, CAFile: otherCA.file}, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientConfig, err := ClientConfig(tt.cfg)
+			if err != nil {
+				t.Fatalf("Failed to create client TLS config: %v", err)
+			}
+
+			client := &http.Client{
+				Transport: &http.Transport{TLSClientConfig: clientConfig},
+				Timeout:   5 * time.Second,
+			}
+			defer client.CloseIdleConnections()
+
+			resp, err := client.Get(server.URL)
+			if tt.expectError {
+				if err == nil {
+					resp.Body.Close()
+					t.Error("Expected TLS handshake error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+			defer resp.Body.Close()
+
+			body, _ := io.ReadAll(resp.Body)
+			if string(body) != tt.identity {
+				t.Errorf("Expected identity %q, got %q", tt.identity, body)
+			}
+		})
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, dir, "test-ca")
+	certFile, keyFile := ca.issue("orchestrator", x509.ExtKeyUsageServerAuth)
+
+	notPEM := filepath.Join(dir, "not-pem.txt")
+	if err := os.WriteFile(notPEM, []byte("not a certificate"), 0600); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	if _, err := ServerConfig(config.TLSConfig{CertFile: certFile, KeyFile: filepath.Join(dir, "missing.key")}); err == nil {
+		t.Error("Expected error for missing key file")
+	}
+
+	if _, err := ServerConfig(config.TLSConfig{CertFile: certFile, KeyFile: keyFile, CAFile: notPEM}); !errors.Is(err, ErrNoCertificates) {
+		t.Errorf("Expected ErrNoCertificates, got %v", err)
+	}
+
+	if _, err := ClientConfig(config.TLSConfig{CAFile: filepath.Join(dir, "missing.pem")}); err == nil {
+		t.Error("Expected error for missing CA file")
+	}
+
+	if identity := PeerIdentity(nil); identity != "" {
+		t.Errorf("Expected empty identity without TLS, got %q", identity)
+	}
+}
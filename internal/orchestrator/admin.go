@@ -0,0 +1,40 @@
+package orchestrator
+
+import (
+	"context"
+	"final3/internal/logger"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// Логеры компонентов оркестратора с отдельно настраиваемым уровнем
+func parserLog() *slog.Logger    { return logger.Component(logger.ComponentParser) }
+func schedulerLog() *slog.Logger { return logger.Component(logger.ComponentScheduler) }
+
+// Обработчики локального административного HTTP-сервера оркестратора
+func (o *Orchestrator) adminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/log-level", logger.LevelHandler)
+	return mux
+}
+
+// Запуск административного сервера до отмены ctx
+func (o *Orchestrator) serveAdmin(ctx context.Context, addr string) {
+	server := &http.Server{
+		Addr:    addr,
+		Handler: o.adminHandler(),
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	logger.Info("Starting admin server", "addr", addr)
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		logger.Error("Admin server error", "addr", addr, "error", err)
+	}
+}
@@ -0,0 +1,87 @@
+package orchestrator
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"final3/internal/auth"
+	"final3/internal/logger"
+	"final3/internal/mtls"
+	"net/http"
+)
+
+type agentKey struct{}
+
+var errNoAgentCredentials = errors.New("agent credentials are not configured")
+
+// Проверка подлинности агента по клиентскому сертификату mTLS или токену из Authorization
+func (o *Orchestrator) requireAgent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		agentID := r.Header.Get(agentIDHeader)
+
+		if certID := mtls.PeerIdentity(r.TLS); certID != "" {
+			if agentID != "" && agentID != certID {
+				logger.WarnContext(r.Context(), "Agent ID does not match client certificate",
+					"agent_id", agentID,
+					"certificate_id", certID,
+					"remote_addr", r.RemoteAddr)
+				http.Error(w, "Agent ID does not match client certificate", http.StatusForbidden)
+				return
+			}
+			next(w, r.WithContext(context.WithValue(r.Context(), agentKey{}, certID)))
+			return
+		}
+
+		if agentID == "" {
+			agentID = r.RemoteAddr
+		}
+
+		if !o.agentAuthenticated(agentID, r) {
+			logger.WarnContext(r.Context(), "Rejected unauthenticated agent",
+				"agent_id", agentID,
+				"remote_addr", r.RemoteAddr)
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Agent authentication required", http.StatusUnauthorized)
+			return
+		}
+
+		next(w, r.WithContext(context.WithValue(r.Context(), agentKey{}, agentID)))
+	}
+}
+
+// Токен агента: из agent_tokens или выпущенный по agent_secret. Без них агенты не принимаются
+func (o *Orchestrator) agentAuthenticated(agentID string, r *http.Request) bool {
+	token, ok := bearerToken(r)
+	if !ok {
+		return false
+	}
+
+	var expected string
+	switch {
+	case len(o.Config.AgentTokens) > 0:
+		expected = o.Config.AgentTokens[agentID]
+	case o.Config.AgentSecret != "":
+		expected = auth.AgentToken(o.Config.AgentSecret, agentID)
+	}
+	return expected != "" && subtle.ConstantTimeCompare([]byte(token), []byte(expected)) == 1
+}
+
+// Маршруты внутреннего HTTP-сервера для агентов
+func (o *Orchestrator) internalHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/internal/ws", o.instrument("agent_ws", o.requireAgent(o.AgentWebSocketHandler)))
+	mux.HandleFunc("/internal/task/release", o.instrument("task_release", o.requireAgent(o.ReleaseTaskHandler)))
+	mux.HandleFunc("/internal/task", o.instrument("task", o.requireAgent(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			o.GetTaskHandler(w, r)
+		} else if r.Method == http.MethodPost {
+			o.PostTaskHandler(w, r)
+		} else {
+			logger.Warn("Wrong method for /internal/task",
+				"method", r.Method,
+				"remote_addr", r.RemoteAddr)
+			http.Error(w, `{"error":"Wrong Method"}`, http.StatusMethodNotAllowed)
+		}
+	})))
+	return withRequestID(mux)
+}
@@ -0,0 +1,308 @@
+package orchestrator
+
+import (
+	"encoding/json"
+	"errors"
+	"final3/internal/auth"
+	"final3/internal/logger"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const maxAPIKeyNameLen = 128
+
+var (
+	errAPIKeyNotFound = errors.New("api key not found")
+	errAPIKeyInvalid  = errors.New("api key is revoked or expired")
+)
+
+// API-ключ пользователя. Хранится только хеш ключа
+type APIKey struct {
+	ID         int32
+	OwnerID    int32
+	Name       string
+	Hash       string
+	Hint       string   // Начало ключа для отображения в списке
+	Scopes     []string // Пусто - все права
+	CreatedAt  time.Time
+	ExpiresAt  time.Time // Нулевое значение - бессрочный
+	LastUsedAt time.Time
+	RevokedAt  time.Time
+}
+
+// Право выполнять действие scope
+func (k *APIKey) allows(scope string) bool {
+	if len(k.Scopes) == 0 {
+		return true
+	}
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func (k *APIKey) active(now time.Time) bool {
+	return k.RevokedAt.IsZero() && (k.ExpiresAt.IsZero() || now.Before(k.ExpiresAt))
+}
+
+// Создание ключа пользователя owner; возвращает ключ в открытом виде (показывается один раз)
+func (o *Orchestrator) createAPIKey(owner *User, name string, scopes []string, expiresAt time.Time) (*APIKey, string, error) {
+	key, err := auth.GenerateAPIKey()
+	if err != nil {
+		return nil, "", err
+	}
+
+	o.DataBase.mu.Lock()
+	defer o.DataBase.mu.Unlock()
+
+	o.DataBase.PrevAPIKeyID++
+	apiKey := &APIKey{
+		ID:        o.DataBase.PrevAPIKeyID,
+		OwnerID:   owner.ID,
+		Name:      name,
+		Hash:      auth.HashAPIKey(key),
+		Hint:      key[:len(auth.APIKeyPrefix)+6],
+		Scopes:    scopes,
+		CreatedAt: time.Now(),
+		ExpiresAt: expiresAt,
+	}
+	o.DataBase.APIKeys[apiKey.ID] = apiKey
+	o.DataBase.apiKeyHashes[apiKey.Hash] = apiKey
+	return apiKey, key, nil
+}
+
+// Проверка API-ключа с отметкой времени его использования
+func (o *Orchestrator) useAPIKey(key string) (*APIKey, *User, error) {
+	now := time.Now()
+
+	o.DataBase.mu.Lock()
+	defer o.DataBase.mu.Unlock()
+
+	apiKey, ok := o.DataBase.apiKeyHashes[auth.HashAPIKey(key)]
+	if !ok {
+		return nil, nil, errAPIKeyNotFound
+	}
+	if !apiKey.active(now) {
+		return nil, nil, errAPIKeyInvalid
+	}
+
+	user := o.DataBase.Users[apiKey.OwnerID]
+	if user == nil {
+		return nil, nil, errAPIKeyNotFound
+	}
+
+	apiKey.LastUsedAt = now
+	return apiKey, user, nil
+}
+
+// Отзыв ключа id пользователя ownerID
+func (o *Orchestrator) revokeAPIKey(ownerID, id int32) (*APIKey, error) {
+	o.DataBase.mu.Lock()
+	defer o.DataBase.mu.Unlock()
+
+	apiKey, ok := o.DataBase.APIKeys[id]
+	if !ok || apiKey.OwnerID != ownerID {
+		return nil, errAPIKeyNotFound
+	}
+
+	if apiKey.RevokedAt.IsZero() {
+		apiKey.RevokedAt = time.Now()
+	}
+	return apiKey, nil
+}
+
+// Описание ключа в ответах API
+type apiKeyView struct {
+	ID         int32      `json:"id"`
+	Name       string     `json:"name"`
+	Hint       string     `json:"hint"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// Вызывается под DataBase.mu
+func newAPIKeyView(k *APIKey) apiKeyView {
+	scopes := k.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{auth.ScopeSubmit, auth.ScopeRead}
+	}
+
+	return apiKeyView{
+		ID:         k.ID,
+		Name:       k.Name,
+		Hint:       k.Hint,
+		Scopes:     scopes,
+		CreatedAt:  k.CreatedAt,
+		ExpiresAt:  optionalTime(k.ExpiresAt),
+		LastUsedAt: optionalTime(k.LastUsedAt),
+		RevokedAt:  optionalTime(k.RevokedAt),
+	}
+}
+
+// Создание (POST) и просмотр (GET) API-ключей пользователя
+func (o *Orchestrator) APIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	logger.DebugContext(r.Context(), "Received api keys request",
+		"remote_addr", r.RemoteAddr,
+		"method", r.Method)
+
+	user := userFromContext(r.Context())
+	if user == nil {
+		http.Error(w, "Authorization required", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		o.listAPIKeys(w, user)
+	case http.MethodPost:
+		o.createAPIKeyHandler(w, r, user)
+	default:
+		http.Error(w, "Wrong method, expected GET or POST", http.StatusUnprocessableEntity)
+	}
+}
+
+func (o *Orchestrator) listAPIKeys(w http.ResponseWriter, user *User) {
+	o.DataBase.mu.Lock()
+	keys := make([]apiKeyView, 0)
+	for _, k := range o.DataBase.APIKeys {
+		if k.OwnerID == user.ID {
+			keys = append(keys, newAPIKeyView(k))
+		}
+	}
+	o.DataBase.mu.Unlock()
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].ID < keys[j].ID
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Keys []apiKeyView `json:"keys"`
+	}{
+		Keys: keys,
+	})
+}
+
+func (o *Orchestrator) createAPIKeyHandler(w http.ResponseWriter, r *http.Request, user *User) {
+	if r.Header.Get("Content-Type") != "application/json" {
+		http.Error(w, "Wrong content-type, expected JSON", http.StatusUnprocessableEntity)
+		return
+	}
+	defer r.Body.Close()
+
+	var req struct {
+		Name      string     `json:"name"`
+		Scopes    []string   `json:"scopes"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if len(req.Name) > maxAPIKeyNameLen {
+		http.Error(w, "Key name is too long", http.StatusUnprocessableEntity)
+		return
+	}
+
+	for _, scope := range req.Scopes {
+		if !auth.ValidScope(scope) {
+			http.Error(w, "Unknown scope: "+scope, http.StatusUnprocessableEntity)
+			return
+		}
+	}
+
+	var expiresAt time.Time
+	if req.ExpiresAt != nil {
+		if !req.ExpiresAt.After(time.Now()) {
+			http.Error(w, "Expiration time must be in the future", http.StatusUnprocessableEntity)
+			return
+		}
+		expiresAt = *req.ExpiresAt
+	}
+
+	apiKey, key, err := o.createAPIKey(user, req.Name, req.Scopes, expiresAt)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "Failed to create api key", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	logger.InfoContext(r.Context(), "API key created",
+		"user_id", user.ID,
+		"api_key_id", apiKey.ID,
+		"scopes", req.Scopes)
+
+	o.DataBase.mu.Lock()
+	view := newAPIKeyView(apiKey)
+	o.DataBase.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(struct {
+		Key string `json:"key"`
+		apiKeyView
+	}{
+		Key:        key,
+		apiKeyView: view,
+	})
+}
+
+// Отзыв API-ключа: DELETE /api/v1/keys/:id
+func (o *Orchestrator) RevokeAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	logger.DebugContext(r.Context(), "Received revoke api key request",
+		"path", r.URL.Path,
+		"remote_addr", r.RemoteAddr,
+		"method", r.Method)
+
+	user := userFromContext(r.Context())
+	if user == nil {
+		http.Error(w, "Authorization required", http.StatusUnauthorized)
+		return
+	}
+
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Wrong method, expected DELETE", http.StatusUnprocessableEntity)
+		return
+	}
+
+	stringID := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/api/v1/keys/"), ":")
+	id, err := strconv.ParseInt(stringID, 10, 32)
+	if err != nil {
+		http.Error(w, "Failed to parse ID", http.StatusNotFound)
+		return
+	}
+
+	apiKey, err := o.revokeAPIKey(user.ID, int32(id))
+	if err != nil {
+		http.Error(w, "API key not found", http.StatusNotFound)
+		return
+	}
+
+	logger.InfoContext(r.Context(), "API key revoked",
+		"user_id", user.ID,
+		"api_key_id", apiKey.ID)
+
+	o.DataBase.mu.Lock()
+	view := newAPIKeyView(apiKey)
+	o.DataBase.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(view)
+}
@@ -0,0 +1,80 @@
+package orchestrator
+
+import (
+	"context"
+	"final3/internal/logger"
+	"net/http"
+)
+
+type auditKey struct{}
+
+// Сведения для журнала аудита, заполняемые обработчиком запроса
+type auditEntry struct {
+	ctx          context.Context // Контекст обработчика с его trace_id
+	user         string
+	apiKeyID     int32
+	expressionID int32
+}
+
+// Запись действия action в журнал аудита после обработки запроса
+func audited(action string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		entry := &auditEntry{}
+		rec := &statusRecorder{ResponseWriter: w}
+
+		defer func() {
+			status := rec.Status()
+			p := recover()
+			if p != nil {
+				status = http.StatusInternalServerError
+			}
+
+			ctx := r.Context()
+			if entry.ctx != nil {
+				ctx = entry.ctx
+			}
+			logger.Audit(ctx, logger.AuditRecord{
+				Action:       action,
+				User:         entry.user,
+				APIKeyID:     entry.apiKeyID,
+				ExpressionID: entry.expressionID,
+				RemoteAddr:   r.RemoteAddr,
+				Status:       status,
+			})
+
+			if p != nil {
+				panic(p)
+			}
+		}()
+
+		next(rec, r.WithContext(context.WithValue(r.Context(), auditKey{}, entry)))
+	}
+}
+
+// Контекст обработчика, из которого берутся идентификаторы записи аудита
+func setAuditContext(ctx context.Context) {
+	if entry, ok := ctx.Value(auditKey{}).(*auditEntry); ok {
+		entry.ctx = ctx
+	}
+}
+
+// Выражение, к которому относится действие запроса
+func setAuditExpression(ctx context.Context, id int32) {
+	if entry, ok := ctx.Value(auditKey{}).(*auditEntry); ok {
+		entry.expressionID = id
+	}
+}
+
+// Пользователь, выполняющий действие запроса
+func setAuditUser(ctx context.Context, login string) {
+	if entry, ok := ctx.Value(auditKey{}).(*auditEntry); ok {
+		entry.user = login
+	}
+}
+
+// API-ключ, которым выполнен запрос
+func setAuditAPIKey(ctx context.Context, id int32) {
+	if entry, ok := ctx.Value(auditKey{}).(*auditEntry); ok {
+		entry.apiKeyID = id
+	}
+}
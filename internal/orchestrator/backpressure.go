@@ -0,0 +1,64 @@
+package orchestrator
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const queueRetryAfter = time.Second // Retry-After при переполнении очереди
+
+// Причины отказа в приёме выражения при переполнении очереди
+const (
+	limitQueuedExpressions = "queued_expressions"
+	limitPendingNodes      = "pending_nodes"
+)
+
+var (
+	errQueueFull      = errors.New("too many expressions in queue")
+	errTooManyPending = errors.New("too many pending operations in queue")
+)
+
+// Учёт нового выражения в ограничениях очереди: числа невычисленных выражений и числа
+// невычисленных операций в них. Если выражение не помещается, оно не принимается
+func (o *Orchestrator) admit(expr *Expression) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if max := o.Config.MaxQueuedExpressions; max > 0 && o.queued >= max {
+		return errQueueFull
+	}
+	if max := o.Config.MaxPendingNodes; max > 0 && o.pendingNodes+expr.tasks > max {
+		return errTooManyPending
+	}
+
+	o.queued++
+	o.pendingNodes += expr.tasks
+	expr.admitted = true
+	return nil
+}
+
+// Освобождение места завершённого выражения в очереди (вызывается под o.mu и expr.mu)
+func (o *Orchestrator) releaseAdmission(expr *Expression) {
+	if !expr.admitted {
+		return
+	}
+	expr.admitted = false
+
+	completed, total := expr.progress()
+	o.queued--
+	o.pendingNodes -= total - completed
+}
+
+// Ответ 503 с Retry-After при переполнении очереди
+func (o *Orchestrator) rejectOverloaded(w http.ResponseWriter, err error) {
+	limit := limitQueuedExpressions
+	if err == errTooManyPending {
+		limit = limitPendingNodes
+	}
+
+	o.metrics.requestsLimited.WithLabelValues(limit).Inc()
+	w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(queueRetryAfter)))
+	http.Error(w, "Orchestrator is overloaded, try again later", http.StatusServiceUnavailable)
+}
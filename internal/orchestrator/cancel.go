@@ -0,0 +1,77 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"final3/internal/models"
+)
+
+var (
+	errExpressionCancelled = errors.New("cancelled by user")
+	errExpressionFinished  = errors.New("expression is already finished")
+)
+
+// Остановка выражения со статусом status: задачи больше не выдаются, выданные задачи
+// отзываются, а уже вычисленные узлы сохраняются и видны в прогрессе выражения
+func (o *Orchestrator) stopExpression(expr *Expression, status ExpressionStatus, reason error) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	expr.mu.Lock()
+	defer expr.mu.Unlock()
+
+	if expr.finished() {
+		return errExpressionFinished
+	}
+
+	for key, l := range o.leases {
+		if l.expr != expr {
+			continue
+		}
+		delete(o.leases, key)
+		for c := l; c != nil; c = c.duplicate {
+			c.span.AddEvent(reason.Error(), "agent_id", c.agentID)
+			c.span.End()
+		}
+	}
+
+	ctx := expressionContext(context.Background(), expr)
+	completed, total := expr.progress()
+	schedulerLog().WarnContext(ctx, "Expression stopped",
+		"expression_id", expr.ID,
+		"status", string(status),
+		"reason", reason,
+		"completed_tasks", completed,
+		"total_tasks", total)
+
+	for id, node := range expr.IdMap {
+		if node.Status == models.StatusAtWorker {
+			node.Status = models.StatusInQueue
+		}
+		delete(expr.startedAt, id)
+	}
+
+	expr.Status = status
+	expr.Err = reason
+	o.removeFromQueue(expr)
+	o.finishExpression(expr)
+	return nil
+}
+
+// Завершено ли выражение (вызывается под expr.mu)
+func (expr *Expression) finished() bool {
+	switch expr.Status {
+	case StatusDone, StatusError, StatusTimedOut, StatusCancelled:
+		return true
+	}
+	return false
+}
+
+// Удаление выражения из базы; незавершённое выражение перед этим отменяется
+func (o *Orchestrator) deleteExpression(expr *Expression) {
+	o.stopExpression(expr, StatusCancelled, errExpressionCancelled)
+
+	o.DataBase.mu.Lock()
+	delete(o.DataBase.ExpressionList, expr.ID)
+	o.DataBase.mu.Unlock()
+}
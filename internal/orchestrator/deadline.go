@@ -0,0 +1,106 @@
+package orchestrator
+
+import (
+	"errors"
+	"final3/internal/models"
+	"time"
+)
+
+var (
+	errDeadlineExceeded = errors.New("deadline exceeded")
+	errInvalidTimeout   = errors.New("invalid timeout")
+	errInvalidDeadline  = errors.New("invalid deadline")
+	errDeadlineTooLong  = errors.New("deadline exceeds max timeout")
+)
+
+// Срок вычисления выражения из timeout или deadline запроса (нулевое время - срока нет)
+func (o *Orchestrator) expressionDeadline(now time.Time, timeout, deadline string) (time.Time, error) {
+	var at time.Time
+
+	switch {
+	case timeout != "" && deadline != "":
+		return time.Time{}, errors.Join(errInvalidTimeout, errors.New("timeout and deadline are mutually exclusive"))
+	case timeout != "":
+		d, err := time.ParseDuration(timeout)
+		if err != nil || d <= 0 {
+			return time.Time{}, errInvalidTimeout
+		}
+		at = now.Add(d)
+	case deadline != "":
+		t, err := time.Parse(time.RFC3339, deadline)
+		if err != nil || !t.After(now) {
+			return time.Time{}, errInvalidDeadline
+		}
+		at = t
+	case o.Config.DefaultTimeoutMS > 0:
+		at = now.Add(time.Duration(o.Config.DefaultTimeoutMS) * time.Millisecond)
+	}
+
+	if o.Config.MaxTimeoutMS > 0 {
+		limit := now.Add(time.Duration(o.Config.MaxTimeoutMS) * time.Millisecond)
+		if (timeout != "" || deadline != "") && at.After(limit) {
+			return time.Time{}, errDeadlineTooLong
+		}
+		if at.IsZero() || at.After(limit) {
+			at = limit
+		}
+	}
+
+	return at, nil
+}
+
+// Запуск таймера срока выражения после постановки его в очередь
+func (o *Orchestrator) scheduleDeadline(expr *Expression) {
+	if expr.Deadline.IsZero() {
+		return
+	}
+
+	expr.mu.Lock()
+	defer expr.mu.Unlock()
+
+	expr.timer = time.AfterFunc(time.Until(expr.Deadline), func() {
+		o.expireExpression(expr)
+	})
+}
+
+// Завершение выражения по истечении срока
+func (o *Orchestrator) expireExpression(expr *Expression) {
+	o.stopExpression(expr, StatusTimedOut, errDeadlineExceeded)
+}
+
+// Количество вычисленных и всех операций выражения (вызывается под expr.mu)
+func (expr *Expression) progress() (completed, total int) {
+	done := 0
+	for _, node := range expr.IdMap {
+		if node.Status == models.StatusDone {
+			done++
+		}
+	}
+	return done - (len(expr.IdMap) - expr.tasks), expr.tasks
+}
+
+// Истёк ли срок выражения
+func (expr *Expression) expired(now time.Time) bool {
+	return !expr.Deadline.IsZero() && !now.Before(expr.Deadline)
+}
+
+// Прогресс выражения в ответах API
+type progressView struct {
+	CompletedTasks int `json:"completed_tasks"`
+	TotalTasks     int `json:"total_tasks"`
+}
+
+// Вызывается под expr.mu
+func newProgressView(expr *Expression) progressView {
+	completed, total := expr.progress()
+	return progressView{CompletedTasks: completed, TotalTasks: total}
+}
+
+// Время для ответа API: nil для нулевого времени
+func timeView(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	t = t.UTC().Truncate(time.Millisecond)
+	return &t
+}
@@ -4,7 +4,7 @@ import (
 	"encoding/json"
 	"final3/internal/logger"
 	"final3/internal/models"
-	"fmt"
+	"final3/internal/tracing"
 	"net/http"
 	"sort"
 	"strconv"
@@ -12,21 +12,43 @@ import (
 	"time"
 )
 
+// Отклонение запросов с методом, отличным от method, до проверки авторизации
+func requireMethod(method string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			logger.WarnContext(r.Context(), "Wrong method",
+				"path", r.URL.Path,
+				"method", r.Method,
+				"remote_addr", r.RemoteAddr)
+			w.Header().Set("Allow", method)
+			http.Error(w, "Wrong method, expected "+method, http.StatusMethodNotAllowed)
+			return
+		}
+		next(w, r)
+	}
+}
+
 func (o *Orchestrator) CalculateHandler(w http.ResponseWriter, r *http.Request) {
-	logger.Debug("Received calculate request",
+	// Сквозной идентификатор выражения совпадает с идентификатором запроса, которым оно создано
+	traceID := logger.RequestIDFromContext(r.Context())
+	if traceID == "" {
+		traceID = newRequestID()
+	}
+	ctx := logger.ContextWithTraceID(r.Context(), traceID)
+	setAuditContext(ctx)
+
+	logger.DebugContext(ctx, "Received calculate request",
 		"remote_addr", r.RemoteAddr,
 		"method", r.Method)
 
-	if r.Method != http.MethodPost {
-		logger.Warn("Wrong method for calculate",
-			"method", r.Method,
-			"remote_addr", r.RemoteAddr)
-		http.Error(w, "Wrong method, expected POST", http.StatusUnprocessableEntity)
+	user := userFromContext(ctx)
+	if user == nil {
+		http.Error(w, "Authorization required", http.StatusUnauthorized)
 		return
 	}
 
 	if r.Header.Get("Content-Type") != "application/json" {
-		logger.Warn("Wrong content-type",
+		logger.WarnContext(ctx, "Wrong content-type",
 			"content_type", r.Header.Get("Content-Type"),
 			"remote_addr", r.RemoteAddr)
 		http.Error(w, "Wrong content-type, expected JSON", http.StatusUnprocessableEntity)
@@ -35,200 +57,226 @@ func (o *Orchestrator) CalculateHandler(w http.ResponseWriter, r *http.Request)
 	defer r.Body.Close()
 
 	var userRequest struct {
-		Expression string `json:"expression"`
+		Expression string          `json:"expression"`
+		Priority   json.RawMessage `json:"priority"`
+		Timeout    string          `json:"timeout"`  // Длительность, например "30s"
+		Deadline   string          `json:"deadline"` // Момент в RFC 3339
 	}
 
 	err := json.NewDecoder(r.Body).Decode(&userRequest)
 	if err != nil {
-		logger.Error("Failed to decode request body",
+		logger.ErrorContext(ctx, "Failed to decode request body",
 			"error", err,
 			"remote_addr", r.RemoteAddr)
-		panic(err)
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	priority, err := parsePriority(userRequest.Priority)
+	if err != nil {
+		logger.WarnContext(ctx, "Invalid expression priority",
+			"priority", string(userRequest.Priority),
+			"remote_addr", r.RemoteAddr)
+		http.Error(w, "Invalid priority, expected low, normal, high or number from 0 to 9", http.StatusUnprocessableEntity)
+		return
+	}
+
+	deadline, err := o.expressionDeadline(time.Now(), userRequest.Timeout, userRequest.Deadline)
+	if err != nil {
+		logger.WarnContext(ctx, "Invalid expression deadline",
+			"timeout", userRequest.Timeout,
+			"deadline", userRequest.Deadline,
+			"error", err)
+		if err == errDeadlineTooLong {
+			max := time.Duration(o.Config.MaxTimeoutMS) * time.Millisecond
+			http.Error(w, "Timeout or deadline exceeds maximum of "+max.String(), http.StatusUnprocessableEntity)
+			return
+		}
+		http.Error(w, "Invalid timeout or deadline, expected duration like \"30s\" or future RFC 3339 time", http.StatusUnprocessableEntity)
+		return
 	}
 
-	logger.Info("Processing calculation request",
-		"expression", userRequest.Expression)
+	logger.InfoContext(ctx, "Processing calculation request",
+		"expression", userRequest.Expression,
+		"priority", priority,
+		"deadline", deadline)
+
+	if max := o.maxExpressionNodes(); max > 0 {
+		w.Header().Set(nodesLimitHeader, strconv.Itoa(max))
+	}
 
+	// Место резервируется до разбора, чтобы параллельные запросы не превысили лимит
+	ip := clientIP(r)
+	active := o.reserveActive(user.ID, ip)
+	if active.limit > 0 {
+		w.Header().Set(activeLimitHeader, strconv.Itoa(active.limit))
+		w.Header().Set(activeRemainingHeader, strconv.Itoa(active.remaining))
+	}
+	if !active.allowed {
+		logger.WarnContext(ctx, "Active expressions limit exceeded",
+			"user", user.Login,
+			"ip", ip,
+			"limit", active.exceeded,
+			"max_active_expressions", active.limit)
+		o.rejectLimited(w, active.exceeded, activeRetryAfter, "Too many active expressions")
+		return
+	}
+
+	// Корневой спан выражения завершается вместе с вычислением выражения. Если клиент
+	// передал traceparent, выражение становится частью его трассировки
+	spanOpts := []tracing.StartOption{tracing.WithAttributes("expression", userRequest.Expression)}
+	if id, err := tracing.ParseTraceID(traceID); err == nil {
+		spanOpts = append(spanOpts, tracing.WithTraceID(id))
+	}
+	if parent, err := tracing.ParseTraceparent(r.Header.Get(tracing.TraceparentHeader)); err == nil {
+		ctx = tracing.ContextWithRemoteSpanContext(ctx, parent)
+	}
+	ctx, span := tracing.Start(ctx, "expression", spanOpts...)
+	traceID = span.SpanContext().TraceID().String()
+	ctx = logger.ContextWithTraceID(ctx, traceID)
+	setAuditContext(ctx)
+
+	_, parseSpan := tracing.Start(ctx, "parse")
 	expr, err := o.prepareInput(userRequest.Expression)
+	if expr != nil {
+		parseSpan.SetAttribute("nodes", len(expr.IdMap))
+	}
+	parseSpan.RecordError(err)
+	parseSpan.End()
 	if err != nil {
-		logger.Error("Failed to prepare input",
+		logger.ErrorContext(ctx, "Failed to prepare input",
 			"expression", userRequest.Expression,
 			"error", err)
+		o.releaseActive(user.ID, ip)
+		span.RecordError(err)
+		span.End()
+		if err == errTooManyNodes {
+			o.metrics.requestsLimited.WithLabelValues(limitExpressionNodes).Inc()
+			http.Error(w, "Expression has too many nodes", http.StatusRequestEntityTooLarge)
+			return
+		}
 		http.Error(w, "Invalid expression", http.StatusUnprocessableEntity)
 		return
 	}
 
 	if expr == nil {
+		o.releaseActive(user.ID, ip)
+		span.End()
 		http.Error(w, "Internal error", http.StatusInternalServerError)
 		return
 	}
 
+	// При переполнении очереди выражение не принимается, чтобы всплеск запросов
+	// не исчерпал память оркестратора
+	if err := o.admit(expr); err != nil {
+		logger.WarnContext(ctx, "Expression rejected, queue is full",
+			"nodes", expr.tasks,
+			"max_queued_expressions", o.Config.MaxQueuedExpressions,
+			"max_pending_nodes", o.Config.MaxPendingNodes,
+			"error", err)
+		// prepareInput уже сохранил выражение в базе, отклонённое выражение не должно в ней оставаться
+		o.DataBase.mu.Lock()
+		delete(o.DataBase.ExpressionList, expr.ID)
+		o.DataBase.mu.Unlock()
+		o.releaseActive(user.ID, ip)
+		span.RecordError(err)
+		span.End()
+		o.rejectOverloaded(w, err)
+		return
+	}
+
+	span.SetAttribute("expression_id", expr.ID)
+	setAuditExpression(ctx, expr.ID)
+	expr.span = span
+	expr.TraceID = traceID
+	ctx = logger.ContextWithExpressionID(ctx, expr.ID)
+
 	expr.Status = StatusInQueue
-	logger.Debug("Expression status updated",
+	logger.DebugContext(ctx, "Expression status updated",
 		"expression_id", expr.ID,
 		"status", string(StatusInQueue))
 
+	// Владелец назначается до постановки в очередь: по нему освобождается место в лимите
+	o.DataBase.mu.Lock()
+	expr.OwnerID = user.ID
+	expr.ownerIP = ip
+	expr.weight = o.userWeight(user.Login)
+	expr.Priority = priority
+	expr.Deadline = deadline
+	o.DataBase.ExpressionList[expr.ID] = expr
+	logger.DebugContext(ctx, "Expression added to database",
+		"expression_id", expr.ID,
+		"owner_id", user.ID)
+	o.DataBase.mu.Unlock()
+
 	o.mu.Lock()
-	o.Queue = append(o.Queue, expr)
-	logger.Debug("Expression added to queue",
+	o.Queue.Push(expr)
+	o.notifyTasksLocked()
+	logger.DebugContext(ctx, "Expression added to queue",
 		"expression_id", expr.ID,
-		"queue_length", len(o.Queue))
+		"priority", expr.Priority,
+		"queue_length", o.Queue.Len())
 	o.mu.Unlock()
 
-	o.DataBase.mu.Lock()
-	o.DataBase.ExpressionList[expr.ID] = expr
-	logger.Debug("Expression added to database",
-		"expression_id", expr.ID)
-	o.DataBase.mu.Unlock()
+	o.scheduleDeadline(expr)
 
 	var response = struct {
-		ID     int32            `json:"id"`
-		Status ExpressionStatus `json:"status"`
+		ID       int32            `json:"id"`
+		Status   ExpressionStatus `json:"status"`
+		Deadline *time.Time       `json:"deadline,omitempty"`
 	}{
-		ID:     expr.ID,
-		Status: expr.Status,
+		ID:       expr.ID,
+		Status:   StatusInQueue,
+		Deadline: timeView(deadline),
 	}
 
-	logger.Info("Calculation request processed successfully",
+	logger.InfoContext(ctx, "Calculation request processed successfully",
 		"expression_id", expr.ID,
-		"status", string(expr.Status))
+		"status", string(response.Status))
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
 
 func (o *Orchestrator) GetTaskHandler(w http.ResponseWriter, r *http.Request) {
-	logger.Debug("Received get task request",
+	logger.DebugContext(r.Context(), "Received get task request",
 		"remote_addr", r.RemoteAddr,
 		"method", r.Method)
 
 	if r.Method != http.MethodGet {
-		logger.Warn("Wrong method for get task",
+		logger.WarnContext(r.Context(), "Wrong method for get task",
 			"method", r.Method,
 			"remote_addr", r.RemoteAddr)
 		http.Error(w, "Wrong method, expected GET", http.StatusUnprocessableEntity)
 		return
 	}
 
-	o.mu.Lock()
-	defer o.mu.Unlock()
-	if len(o.Queue) == 0 {
-		logger.Debug("No tasks available in queue")
+	task, err := o.nextTask(r.Context(), agentIDFromRequest(r))
+	w.Header().Set(backlogHeader, strconv.Itoa(o.readyTasksCount()))
+	if task != nil && task.Traceparent != "" {
+		w.Header().Set(tracing.TraceparentHeader, task.Traceparent)
+	}
+
+	if err == errQueueEmpty {
 		http.Error(w, "No tasks available now (no expressions in queue)", http.StatusNotFound)
 		return
 	}
-	expr := o.Queue[0]
-	logger.Debug("Found expression in queue",
-		"expression_id", expr.ID)
-
-	expr.mu.Lock()
-	defer expr.mu.Unlock()
-
-	expr.Status = StatusInProgress
-	logger.Debug("Expression status updated",
-		"expression_id", expr.ID,
-		"status", string(StatusInProgress))
-
-	for id, task := range expr.IdMap {
-		if task.Type != models.Operator || task.Status != models.StatusInQueue {
-			continue
-		}
-
-		if len(task.Dependencies) < 2 {
-			logger.Debug("Task has insufficient dependencies",
-				"task_id", id,
-				"dependencies_count", len(task.Dependencies))
-			continue
-		}
-
-		dep1 := task.Dependencies[0]
-		dep2 := task.Dependencies[1]
-
-		if dep1.Type != models.Number || dep2.Type != models.Number {
-			logger.Debug("Task dependencies are not ready",
-				"task_id", id,
-				"dep1_type", dep1.Type,
-				"dep2_type", dep2.Type)
-			continue
-		}
-
-		task.Status = models.StatusAtWorker
-		logger.Debug("Task status updated",
-			"task_id", id,
-			"status", task.Status)
-
-		arg1, err := strconv.ParseFloat(dep1.Value, 64)
-		if err != nil {
-			logger.Error("Error parsing arg1",
-				"value", dep1.Value,
-				"error", err)
-			fmt.Printf("Error parsing arg1: %v\n", err)
-			continue
-		}
-
-		arg2, err := strconv.ParseFloat(dep2.Value, 64)
-		if err != nil {
-			logger.Error("Error parsing arg2",
-				"value", dep2.Value,
-				"error", err)
-			fmt.Printf("Error parsing arg2: %v\n", err)
-			continue
-		}
-
-		logger.Info("Sending task to worker",
-			"task_id", id,
-			"expression_id", expr.ID,
-			"arg1", arg1,
-			"operation", task.Value,
-			"arg2", arg2)
-
-		var operationTime time.Duration
-
-		switch task.Value {
-		case "+":
-			operationTime = time.Duration(o.Config.TimeAdditionMS) * time.Millisecond
-		case "-":
-			operationTime = time.Duration(o.Config.TimeSubtractionMS) * time.Millisecond
-		case "*":
-			operationTime = time.Duration(o.Config.TimeMultiplicationsMS) * time.Millisecond
-		case "/":
-			operationTime = time.Duration(o.Config.TimeDivisionsMS) * time.Millisecond
-		}
-
-		logger.Debug("Operation time calculated",
-			"operation", task.Value,
-			"time_ms", operationTime.Milliseconds())
-
-		taskToSend := struct {
-			ID            int           `json:"id"`
-			Arg1          float64       `json:"arg1"`
-			Arg2          float64       `json:"arg2"`
-			Operation     string        `json:"operation"`
-			OperationTime time.Duration `json:"operation_time"`
-		}{
-			ID:            id,
-			Arg1:          arg1,
-			Arg2:          arg2,
-			Operation:     task.Value,
-			OperationTime: operationTime,
-		}
-
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(taskToSend)
+	if err != nil {
+		http.Error(w, "No tasks to do", http.StatusNotFound)
 		return
 	}
 
-	logger.Debug("No eligible tasks found")
-	http.Error(w, "No tasks to do", 404)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(task)
 }
 
 func (o *Orchestrator) PostTaskHandler(w http.ResponseWriter, r *http.Request) {
-	logger.Debug("Received post task result",
+	logger.DebugContext(r.Context(), "Received post task result",
 		"remote_addr", r.RemoteAddr,
 		"method", r.Method)
 
 	if r.Method != http.MethodPost {
-		logger.Warn("Wrong method for post task",
+		logger.WarnContext(r.Context(), "Wrong method for post task",
 			"method", r.Method,
 			"remote_addr", r.RemoteAddr)
 		http.Error(w, "Wrong method, expected POST", http.StatusUnprocessableEntity)
@@ -236,7 +284,7 @@ func (o *Orchestrator) PostTaskHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if r.Header.Get("Content-Type") != "application/json" {
-		logger.Warn("Wrong content-type",
+		logger.WarnContext(r.Context(), "Wrong content-type",
 			"content_type", r.Header.Get("Content-Type"),
 			"remote_addr", r.RemoteAddr)
 		http.Error(w, "Wrong content-type, expected JSON", http.StatusUnprocessableEntity)
@@ -244,174 +292,103 @@ func (o *Orchestrator) PostTaskHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	defer r.Body.Close()
 
-	var postReq struct {
-		ID     int     `json:"id"`
-		Result float64 `json:"result"`
-		Error  string  `json:"error,omitempty"`
-	}
+	var postReq models.TaskResult
 
 	err := json.NewDecoder(r.Body).Decode(&postReq)
 	if err != nil {
-		logger.Error("Failed to decode request body",
+		logger.ErrorContext(r.Context(), "Failed to decode request body",
 			"error", err,
 			"remote_addr", r.RemoteAddr)
 		http.Error(w, "Invalid JSON", http.StatusBadRequest)
 		return
 	}
 
-	logger.Info("Received task result",
+	logger.InfoContext(r.Context(), "Received task result",
 		"task_id", postReq.ID,
+		"expression_id", postReq.ExpressionID,
 		"result", postReq.Result,
 		"error", postReq.Error)
 
-	o.mu.Lock()
-	if len(o.Queue) == 0 {
-		logger.Warn("Received result but queue is empty",
-			"task_id", postReq.ID)
-		o.mu.Unlock()
-		http.Error(w, "No expressions in queue", 404)
+	switch err := o.completeTask(r.Context(), agentIDFromRequest(r), postReq); err {
+	case nil:
+	case errExpressionNotFound:
+		http.Error(w, "Expression not found", http.StatusNotFound)
 		return
-	}
-	expr := o.Queue[0]
-	o.mu.Unlock()
-
-	expr.mu.Lock()
-	defer expr.mu.Unlock()
-
-	stringResult := fmt.Sprintf("%.5f", postReq.Result)
-	logger.Debug("Processing task result",
-		"task_id", postReq.ID,
-		"expression_id", expr.ID,
-		"result", stringResult)
-
-	completedNode := expr.IdMap[postReq.ID]
-	if completedNode == nil {
-		logger.Error("Node not found",
-			"task_id", postReq.ID,
-			"expression_id", expr.ID)
+	case errNodeNotFound:
 		http.Error(w, "Node not found", http.StatusNotFound)
 		return
+	case errLeaseNotFound:
+		http.Error(w, "Task is not leased", http.StatusNotFound)
+		return
+	case errLeaseNotOwned:
+		http.Error(w, "Task is leased by another agent", http.StatusConflict)
+		return
+	default:
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
 	}
 
-	completedNode.Value = stringResult
-	completedNode.Status = models.StatusDone
-	completedNode.Type = models.Number
-
-	logger.Debug("Node updated",
-		"task_id", postReq.ID,
-		"status", completedNode.Status,
-		"type", completedNode.Type)
-
-	if postReq.Error != "" {
-		logger.Error("Task error reported",
-			"task_id", postReq.ID,
-			"expression_id", expr.ID,
-			"error", postReq.Error)
-
-		o.mu.Lock()
-		defer o.mu.Unlock()
-		expr.Status = StatusError
-		expr.Err = fmt.Errorf(postReq.Error)
-
-		logger.Debug("Expression status updated",
-			"expression_id", expr.ID,
-			"status", string(StatusError))
-
-		o.Queue = o.Queue[1:]
-		logger.Debug("Expression removed from queue",
-			"queue_length", len(o.Queue))
+	w.WriteHeader(http.StatusOK)
+	logger.DebugContext(r.Context(), "Task result processed successfully",
+		"task_id", postReq.ID)
+}
 
-		o.DataBase.mu.Lock()
-		o.DataBase.ExpressionList[expr.ID].Status = StatusError
-		o.DataBase.mu.Unlock()
+func (o *Orchestrator) ReleaseTaskHandler(w http.ResponseWriter, r *http.Request) {
+	logger.DebugContext(r.Context(), "Received release task request",
+		"remote_addr", r.RemoteAddr,
+		"method", r.Method)
 
-		logger.Debug("Expression status updated in database",
-			"expression_id", expr.ID,
-			"status", string(StatusError))
+	if r.Method != http.MethodPost {
+		logger.WarnContext(r.Context(), "Wrong method for release task",
+			"method", r.Method,
+			"remote_addr", r.RemoteAddr)
+		http.Error(w, "Wrong method, expected POST", http.StatusUnprocessableEntity)
 		return
 	}
 
-	maxLevel := 0
-	for _, node := range expr.IdMap {
-		if node.Level > maxLevel {
-			maxLevel = node.Level
-		}
+	if r.Header.Get("Content-Type") != "application/json" {
+		logger.WarnContext(r.Context(), "Wrong content-type",
+			"content_type", r.Header.Get("Content-Type"),
+			"remote_addr", r.RemoteAddr)
+		http.Error(w, "Wrong content-type, expected JSON", http.StatusUnprocessableEntity)
+		return
 	}
+	defer r.Body.Close()
 
-	logger.Debug("Checking if expression is complete",
-		"expression_id", expr.ID,
-		"max_level", maxLevel)
-
-	allMaxLevelDone := true
-	for _, node := range expr.IdMap {
-		if node.Level == maxLevel && node.Status != models.StatusDone {
-			allMaxLevelDone = false
-			logger.Debug("Found unfinished node at max level",
-				"node_level", node.Level,
-				"node_status", node.Status)
-			break
-		}
+	var releaseReq struct {
+		ID           int   `json:"id"`
+		ExpressionID int32 `json:"expression_id"`
 	}
 
-	if allMaxLevelDone {
-		logger.Info("All nodes at max level are done, expression is complete",
-			"expression_id", expr.ID)
-
-		for _, node := range expr.IdMap {
-			if node.Level == maxLevel {
-				result, err := strconv.ParseFloat(node.Value, 64)
-				if err == nil {
-					expr.Result = result
-					expr.Status = StatusDone
-
-					logger.Info("Expression result calculated",
-						"expression_id", expr.ID,
-						"result", result)
-
-					o.DataBase.mu.Lock()
-					o.DataBase.ExpressionList[expr.ID].Status = StatusDone
-					o.DataBase.mu.Unlock()
-
-					logger.Debug("Expression status updated in database",
-						"expression_id", expr.ID,
-						"status", string(StatusDone))
-
-					o.mu.Lock()
-					if len(o.Queue) > 0 && o.Queue[0] == expr {
-						o.Queue = o.Queue[1:]
-						logger.Debug("Expression removed from queue",
-							"queue_length", len(o.Queue))
-					}
-					o.mu.Unlock()
-
-					logger.Info("Expression completed",
-						"expression_id", expr.ID,
-						"result", expr.Result)
-				} else {
-					logger.Error("Failed to parse final result",
-						"value", node.Value,
-						"error", err)
-				}
-				break
-			}
-		}
-	} else {
-		expr.Status = StatusInQueue
-		logger.Debug("Expression not yet complete, returning to queue",
-			"expression_id", expr.ID)
-
-		o.DataBase.mu.Lock()
-		o.DataBase.ExpressionList[expr.ID].Status = StatusInQueue
-		o.DataBase.mu.Unlock()
+	if err := json.NewDecoder(r.Body).Decode(&releaseReq); err != nil {
+		logger.ErrorContext(r.Context(), "Failed to decode request body",
+			"error", err,
+			"remote_addr", r.RemoteAddr)
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
 
-		logger.Debug("Expression status updated in database",
-			"expression_id", expr.ID,
-			"status", string(StatusInQueue))
+	agentID := agentIDFromRequest(r)
+	logger.InfoContext(r.Context(), "Agent releases task",
+		"task_id", releaseReq.ID,
+		"expression_id", releaseReq.ExpressionID,
+		"agent_id", agentID)
+
+	key := taskKey{ExpressionID: releaseReq.ExpressionID, NodeID: releaseReq.ID}
+	switch err := o.releaseTask(key, agentID); err {
+	case nil:
+	case errLeaseNotFound:
+		http.Error(w, "Task is not leased", http.StatusNotFound)
+		return
+	case errLeaseNotOwned:
+		http.Error(w, "Task is leased by another agent", http.StatusConflict)
+		return
+	default:
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
 	}
 
 	w.WriteHeader(http.StatusOK)
-	logger.Debug("Task result processed successfully",
-		"task_id", postReq.ID)
 }
 
 func (o *Orchestrator) ExpressionsListHandler(w http.ResponseWriter, r *http.Request) {
@@ -427,53 +404,64 @@ func (o *Orchestrator) ExpressionsListHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	o.DataBase.mu.Lock()
-	defer o.DataBase.mu.Unlock()
+	user := userFromContext(r.Context())
+	if user == nil {
+		http.Error(w, "Authorization required", http.StatusUnauthorized)
+		return
+	}
 
+	o.DataBase.mu.Lock()
 	logger.Debug("Preparing expressions list",
 		"expressions_count", len(o.DataBase.ExpressionList))
 
-	type sendStruct struct {
-		ID     int32            `json:"id"`
-		Status ExpressionStatus `json:"status"`
-		Result float64          `json:"result"`
-		Error  string           `json:"error,omitempty"`
+	// Пользователь видит только свои выражения
+	exprs := make([]*Expression, 0, len(o.DataBase.ExpressionList))
+	for _, expr := range o.DataBase.ExpressionList {
+		if expr.OwnerID == user.ID {
+			exprs = append(exprs, expr)
+		}
 	}
+	o.DataBase.mu.Unlock()
 
-	ids := make([]int32, 0, len(o.DataBase.ExpressionList))
-
-	for id := range o.DataBase.ExpressionList {
-		ids = append(ids, id)
+	type sendStruct struct {
+		ID                 int32            `json:"id"`
+		Status             ExpressionStatus `json:"status"`
+		Result             float64          `json:"result"`
+		Priority           int              `json:"priority"`
+		ExpectedCompletion *time.Time       `json:"expected_completion,omitempty"`
+		Deadline           *time.Time       `json:"deadline,omitempty"`
+		Progress           progressView     `json:"progress"`
+		Error              string           `json:"error,omitempty"`
 	}
 
-	sort.Slice(ids, func(i, j int) bool {
-		return ids[i] < ids[j]
+	sort.Slice(exprs, func(i, j int) bool {
+		return exprs[i].ID < exprs[j].ID
 	})
 
-	logger.Debug("Sorted expression IDs",
-		"ids_count", len(ids))
-
-	exprList := make([]sendStruct, 0, len(ids))
-	for _, id := range ids {
-		expr, ok := o.DataBase.ExpressionList[id]
-		if !ok {
-			logger.Warn("Expression not found in database",
-				"expression_id", id)
-			continue
-		}
+	logger.Debug("Sorted expressions",
+		"expressions_count", len(exprs))
 
+	now := time.Now()
+	exprList := make([]sendStruct, 0, len(exprs))
+	for _, expr := range exprs {
+		expr.mu.Lock()
 		sendExpr := sendStruct{
-			ID:     expr.ID,
-			Status: expr.Status,
-			Result: expr.Result,
+			ID:                 expr.ID,
+			Status:             expr.Status,
+			Result:             expr.Result,
+			Priority:           expr.Priority,
+			ExpectedCompletion: o.expectedCompletionView(expr, now),
+			Deadline:           timeView(expr.Deadline),
+			Progress:           newProgressView(expr),
 		}
 
 		if expr.Err != nil {
 			sendExpr.Error = expr.Err.Error()
 			logger.Debug("Including error in expression data",
-				"expression_id", id,
+				"expression_id", expr.ID,
 				"error", expr.Err.Error())
 		}
+		expr.mu.Unlock()
 
 		exprList = append(exprList, sendExpr)
 	}
@@ -505,6 +493,12 @@ func (o *Orchestrator) GetExpressionByIDHandler(w http.ResponseWriter, r *http.R
 		return
 	}
 
+	user := userFromContext(r.Context())
+	if user == nil {
+		http.Error(w, "Authorization required", http.StatusUnauthorized)
+		return
+	}
+
 	url := r.URL.Path
 	stringID, ok := strings.CutPrefix(url, "/api/v1/expressions/:")
 	if !ok {
@@ -528,9 +522,14 @@ func (o *Orchestrator) GetExpressionByIDHandler(w http.ResponseWriter, r *http.R
 
 	logger.Debug("Parsed ID",
 		"id", id)
+	setAuditExpression(r.Context(), int32(id))
 
+	// Чужие выражения для пользователя не существуют
 	o.DataBase.mu.Lock()
 	expr, ok := o.DataBase.ExpressionList[int32(id)]
+	if ok && expr.OwnerID != user.ID {
+		ok = false
+	}
 	if !ok {
 		logger.Warn("Expression not found in database",
 			"expression_id", id)
@@ -541,24 +540,132 @@ func (o *Orchestrator) GetExpressionByIDHandler(w http.ResponseWriter, r *http.R
 	o.DataBase.mu.Unlock()
 
 	logger.Debug("Found expression in database",
-		"expression_id", id,
-		"status", string(expr.Status))
+		"expression_id", id)
 
+	expr.mu.Lock()
 	sendExpression := struct {
-		ID     int32            `json:"id"`
-		Status ExpressionStatus `json:"status"`
-		Result float64          `json:"result"`
+		ID                 int32            `json:"id"`
+		Status             ExpressionStatus `json:"status"`
+		Result             float64          `json:"result"`
+		Priority           int              `json:"priority"`
+		ExpectedCompletion *time.Time       `json:"expected_completion,omitempty"`
+		Deadline           *time.Time       `json:"deadline,omitempty"`
+		Progress           progressView     `json:"progress"`
 	}{
-		ID:     expr.ID,
-		Status: expr.Status,
-		Result: expr.Result,
+		ID:                 expr.ID,
+		Status:             expr.Status,
+		Result:             expr.Result,
+		Priority:           expr.Priority,
+		ExpectedCompletion: o.expectedCompletionView(expr, time.Now()),
+		Deadline:           timeView(expr.Deadline),
+		Progress:           newProgressView(expr),
 	}
+	expr.mu.Unlock()
 
 	logger.Info("Sending expression details",
-		"expression_id", expr.ID,
-		"status", string(expr.Status),
-		"result", expr.Result)
+		"expression_id", sendExpression.ID,
+		"status", string(sendExpression.Status),
+		"result", sendExpression.Result)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(sendExpression)
 }
+
+func (o *Orchestrator) CancelExpressionHandler(w http.ResponseWriter, r *http.Request) {
+	logger.DebugContext(r.Context(), "Received cancel expression request",
+		"path", r.URL.Path,
+		"remote_addr", r.RemoteAddr,
+		"method", r.Method)
+
+	if r.Method != http.MethodPost {
+		logger.WarnContext(r.Context(), "Wrong method for cancel expression",
+			"method", r.Method,
+			"remote_addr", r.RemoteAddr)
+		http.Error(w, "Wrong method, expected POST", http.StatusUnprocessableEntity)
+		return
+	}
+
+	expr, ok := o.userExpression(w, r, "/cancel")
+	if !ok {
+		return
+	}
+
+	if err := o.stopExpression(expr, StatusCancelled, errExpressionCancelled); err != nil {
+		logger.WarnContext(r.Context(), "Expression is already finished",
+			"expression_id", expr.ID)
+		http.Error(w, "Expression is already finished", http.StatusConflict)
+		return
+	}
+
+	logger.InfoContext(r.Context(), "Expression cancelled",
+		"expression_id", expr.ID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		ID     int32            `json:"id"`
+		Status ExpressionStatus `json:"status"`
+	}{
+		ID:     expr.ID,
+		Status: StatusCancelled,
+	})
+}
+
+func (o *Orchestrator) DeleteExpressionHandler(w http.ResponseWriter, r *http.Request) {
+	logger.DebugContext(r.Context(), "Received delete expression request",
+		"path", r.URL.Path,
+		"remote_addr", r.RemoteAddr,
+		"method", r.Method)
+
+	if r.Method != http.MethodDelete {
+		logger.WarnContext(r.Context(), "Wrong method for delete expression",
+			"method", r.Method,
+			"remote_addr", r.RemoteAddr)
+		http.Error(w, "Wrong method, expected DELETE", http.StatusUnprocessableEntity)
+		return
+	}
+
+	expr, ok := o.userExpression(w, r, "")
+	if !ok {
+		return
+	}
+
+	o.deleteExpression(expr)
+	logger.InfoContext(r.Context(), "Expression deleted",
+		"expression_id", expr.ID)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Выражение пользователя из пути /api/v1/expressions/:<id><suffix>. Если его нет, отвечает 404
+func (o *Orchestrator) userExpression(w http.ResponseWriter, r *http.Request, suffix string) (*Expression, bool) {
+	user := userFromContext(r.Context())
+	if user == nil {
+		http.Error(w, "Authorization required", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	stringID, ok := strings.CutPrefix(strings.TrimSuffix(r.URL.Path, suffix), "/api/v1/expressions/:")
+	id, err := strconv.ParseInt(stringID, 10, 32)
+	if !ok || err != nil {
+		logger.WarnContext(r.Context(), "Failed to parse expression ID",
+			"path", r.URL.Path)
+		http.Error(w, "Failed to parse ID", http.StatusNotFound)
+		return nil, false
+	}
+	setAuditExpression(r.Context(), int32(id))
+
+	// Чужие выражения для пользователя не существуют
+	o.DataBase.mu.Lock()
+	expr, ok := o.DataBase.ExpressionList[int32(id)]
+	if ok && expr.OwnerID != user.ID {
+		ok = false
+	}
+	o.DataBase.mu.Unlock()
+	if !ok {
+		logger.WarnContext(r.Context(), "Expression not found in database",
+			"expression_id", id)
+		http.Error(w, "Failed to find expression", http.StatusNotFound)
+		return nil, false
+	}
+	return expr, true
+}
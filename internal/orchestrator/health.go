@@ -0,0 +1,61 @@
+package orchestrator
+
+import (
+	"context"
+	"encoding/json"
+	"final3/internal/logger"
+	"final3/internal/version"
+	"net/http"
+	"time"
+)
+
+// Сколько /readyz ждёт освобождения блокировки хранилища. Обработчики держат её недолго,
+// поэтому обычная нагрузка не делает оркестратор неготовым
+const readinessTimeout = time.Second
+
+// Проверка живости хранилища. Хранилище находится в памяти процесса и всегда доступно,
+// поэтому проверяется только то, что его блокировка освобождается до отмены ctx, - иначе
+// оркестратор завис и не сможет обработать запросы
+func (db *DataBase) CheckLock(ctx context.Context) error {
+	for !db.mu.TryLock() {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Millisecond):
+		}
+	}
+	db.mu.Unlock()
+	return nil
+}
+
+// Проверка жизнеспособности: процесс работает
+func (o *Orchestrator) HealthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// Проверка готовности: хранилище не заблокировано дольше readinessTimeout и оркестратор не завершает работу
+func (o *Orchestrator) ReadyzHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	status := "ready"
+	if o.draining.Load() {
+		status = "draining"
+	} else if err := o.DataBase.CheckLock(ctx); err != nil {
+		logger.Warn("Storage lock is held too long", "timeout", readinessTimeout, "error", err)
+		status = "storage locked"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if status != "ready" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(map[string]string{"status": status})
+}
+
+// Информация о сборке
+func (o *Orchestrator) VersionHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(version.Get())
+}
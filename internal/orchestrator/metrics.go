@@ -0,0 +1,183 @@
+package orchestrator
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Метрики оркестратора в формате Prometheus
+type orchestratorMetrics struct {
+	registry          *prometheus.Registry
+	tasksDispatched   *prometheus.CounterVec
+	tasksCompleted    *prometheus.CounterVec
+	tasksFailed       *prometheus.CounterVec
+	tasksRetried      *prometheus.CounterVec
+	tasksSpeculated   *prometheus.CounterVec
+	speculativeWins   *prometheus.CounterVec
+	taskLatency       *prometheus.HistogramVec
+	expressionLatency *prometheus.HistogramVec
+	httpRequests      *prometheus.CounterVec
+	requestsLimited   *prometheus.CounterVec
+}
+
+func newOrchestratorMetrics(o *Orchestrator) *orchestratorMetrics {
+	counter := func(name, help string, labels ...string) *prometheus.CounterVec {
+		return prometheus.NewCounterVec(prometheus.CounterOpts{Name: name, Help: help}, labels)
+	}
+	histogram := func(name, help string, buckets []float64, labels ...string) *prometheus.HistogramVec {
+		return prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: name, Help: help, Buckets: buckets}, labels)
+	}
+	gauge := func(name, help string, fn func() float64) prometheus.GaugeFunc {
+		return prometheus.NewGaugeFunc(prometheus.GaugeOpts{Name: name, Help: help}, fn)
+	}
+
+	m := &orchestratorMetrics{
+		registry: prometheus.NewRegistry(),
+		tasksDispatched: counter("orchestrator_tasks_dispatched_total",
+			"Tasks handed out to agents.", "operator"),
+		tasksCompleted: counter("orchestrator_tasks_completed_total",
+			"Tasks completed by agents.", "operator"),
+		tasksFailed: counter("orchestrator_tasks_failed_total",
+			"Tasks for which agents reported an error.", "operator"),
+		tasksRetried: counter("orchestrator_tasks_retried_total",
+			"Tasks returned to the queue after a transient agent failure.", "operator"),
+		tasksSpeculated: counter("orchestrator_tasks_speculated_total",
+			"Duplicate copies of straggler tasks sent to idle agents.", "operator"),
+		speculativeWins: counter("orchestrator_speculative_wins_total",
+			"Duplicated tasks whose copy finished before the original.", "operator"),
+		taskLatency: histogram("orchestrator_task_duration_seconds",
+			"Time from task dispatch to result.", prometheus.DefBuckets, "operator"),
+		expressionLatency: histogram("orchestrator_expression_duration_seconds",
+			"Time from expression submission to final status.",
+			[]float64{0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600}, "status"),
+		httpRequests: counter("orchestrator_http_requests_total",
+			"HTTP requests by handler and response code.", "handler", "code"),
+		requestsLimited: counter("orchestrator_requests_limited_total",
+			"Requests rejected by rate limits and quotas.", "limit"),
+	}
+
+	m.registry.MustRegister(
+		m.tasksDispatched, m.tasksCompleted, m.tasksFailed, m.tasksRetried,
+		m.tasksSpeculated, m.speculativeWins, m.taskLatency, m.expressionLatency,
+		m.httpRequests, m.requestsLimited,
+		gauge("orchestrator_queue_length", "Expressions waiting for calculation.", func() float64 {
+			o.mu.Lock()
+			defer o.mu.Unlock()
+			return float64(o.Queue.Len())
+		}),
+		gauge("orchestrator_pending_nodes", "Operations not yet calculated in accepted expressions.", func() float64 {
+			o.mu.Lock()
+			defer o.mu.Unlock()
+			return float64(o.pendingNodes)
+		}),
+		gauge("orchestrator_tasks_in_flight", "Tasks leased by agents.", func() float64 {
+			o.mu.Lock()
+			defer o.mu.Unlock()
+			return float64(len(o.leases))
+		}),
+		&statusCollector{
+			desc: prometheus.NewDesc("orchestrator_expressions", "Expressions by status.", []string{"status"}, nil),
+			o:    o,
+		},
+	)
+
+	return m
+}
+
+// Число выражений по статусам, вычисляемое при каждом сборе метрик
+type statusCollector struct {
+	desc *prometheus.Desc
+	o    *Orchestrator
+}
+
+func (c *statusCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *statusCollector) Collect(ch chan<- prometheus.Metric) {
+	for status, count := range c.o.expressionsByStatus() {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, count, status)
+	}
+}
+
+// Количество выражений в каждом статусе
+func (o *Orchestrator) expressionsByStatus() map[string]float64 {
+	o.DataBase.mu.Lock()
+	exprs := make([]*Expression, 0, len(o.DataBase.ExpressionList))
+	for _, expr := range o.DataBase.ExpressionList {
+		exprs = append(exprs, expr)
+	}
+	o.DataBase.mu.Unlock()
+
+	counts := make(map[string]float64)
+	for _, expr := range exprs {
+		expr.mu.Lock()
+		counts[string(expr.Status)]++
+		expr.mu.Unlock()
+	}
+	return counts
+}
+
+// Учёт завершения выражения (вызывается под expr.mu)
+func (m *orchestratorMetrics) expressionFinished(expr *Expression) {
+	m.expressionLatency.WithLabelValues(string(expr.Status)).Observe(time.Since(expr.createdAt).Seconds())
+}
+
+// Подсчёт HTTP-запросов к обработчику handler по кодам ответа
+func (o *Orchestrator) instrument(handler string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w}
+		next(rec, r)
+		o.metrics.httpRequests.WithLabelValues(handler, strconv.Itoa(rec.Status())).Inc()
+	}
+}
+
+// Обёртка над http.ResponseWriter, запоминающая код ответа
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	if r.status == 0 {
+		r.status = code
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// Поддержка перехвата соединения для WebSocket
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+
+	conn, rw, err := hijacker.Hijack()
+	if err == nil && r.status == 0 {
+		r.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+func (r *statusRecorder) Status() int {
+	if r.status == 0 {
+		return http.StatusOK
+	}
+	return r.status
+}
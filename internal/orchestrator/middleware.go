@@ -0,0 +1,54 @@
+package orchestrator
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"final3/internal/logger"
+	"net/http"
+)
+
+const (
+	requestIDHeader = "X-Request-ID"
+	traceIDHeader   = "X-Trace-ID" // Сквозной идентификатор выражения, передаваемый агентами вместе с результатами
+	maxRequestIDLen = 128
+)
+
+// Присвоение запросу идентификатора: принимается X-Request-ID клиента или генерируется новый.
+// Идентификатор возвращается в ответе и сохраняется в контексте запроса для логов
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+
+		ctx := logger.ContextWithRequestID(r.Context(), id)
+		if traceID := r.Header.Get(traceIDHeader); validRequestID(traceID) {
+			ctx = logger.ContextWithTraceID(ctx, traceID)
+		}
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Новый случайный идентификатор (32 шестнадцатеричных символа)
+func newRequestID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// Идентификатор клиента принимается, только если он непустой, не слишком длинный
+// и состоит из печатных ASCII-символов
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
@@ -2,14 +2,24 @@ package orchestrator
 
 import (
 	"context"
+	"errors"
+	"final3/internal/auth"
 	"final3/internal/config"
 	"final3/internal/logger"
 	"final3/internal/models"
+	"final3/internal/mtls"
+	"final3/internal/tracing"
+	"final3/internal/version"
 	"final3/pkg/parser"
 	"fmt"
+	"net"
 	"net/http"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 type ExpressionStatus string
@@ -20,15 +30,31 @@ const (
 	StatusInProgress ExpressionStatus = "in_progress"
 	StatusDone       ExpressionStatus = "done"
 	StatusError      ExpressionStatus = "error"
+	StatusTimedOut   ExpressionStatus = "timed_out"
+	StatusCancelled  ExpressionStatus = "cancelled"
 )
 
 type Expression struct {
-	ID     int32
-	IdMap  map[int]*models.Node
-	Status ExpressionStatus
-	Err    error
-	Result float64
-	mu     sync.Mutex
+	ID           int32
+	OwnerID      int32              // Пользователь, отправивший выражение
+	ownerIP      string             // IP-адрес, с которого отправлено выражение
+	weight       int                // Вес владельца при распределении задач
+	Priority     int                // Приоритет выражения от PriorityLow до MaxPriority
+	paths        map[int]pathLength // Оставшиеся пути от узлов до корня
+	startedAt    map[int]time.Time  // Время выдачи задач, находящихся у агентов
+	failedAgents map[int][]string   // Агенты, на которых задачи завершились временным сбоем
+	tasks        int                // Количество операций в выражении
+	admitted     bool               // Выражение учтено в ограничениях очереди
+	Deadline     time.Time          // Срок вычисления (нулевое время - без срока)
+	timer        *time.Timer        // Таймер срока вычисления
+	TraceID      string             // Идентификатор запроса, которым выражение было создано
+	IdMap        map[int]*models.Node
+	Status       ExpressionStatus
+	Err          error
+	Result       float64
+	mu           sync.Mutex
+	createdAt    time.Time
+	span         *tracing.Span // Корневой спан трассировки выражения
 }
 
 // Создание нового выражения для заданного оркестратора
@@ -42,23 +68,48 @@ func (o *Orchestrator) NewExpression() *Expression {
 		"expression_id", id)
 
 	return &Expression{
-		ID:     id,
-		IdMap:  make(map[int]*models.Node),
-		Status: StatusCreated,
-		Err:    nil,
+		ID:           id,
+		IdMap:        make(map[int]*models.Node),
+		startedAt:    make(map[int]time.Time),
+		failedAgents: make(map[int][]string),
+		Status:       StatusCreated,
+		Err:          nil,
+		createdAt:    time.Now(),
 	}
 }
 
 type Orchestrator struct {
-	Queue            []*Expression
+	Queue            *ExpressionQueue
 	PrevExpressionID int32
 	mu               sync.Mutex
 	DataBase         *DataBase
 	Config           *config.OrchestratorConfig
+	leases           map[taskKey]*lease
+	tasksChanged     chan struct{} // Закрывается, когда у агентов могут появиться новые задачи
+	wsSessions       atomic.Int64
+	metrics          *orchestratorMetrics
+	draining         atomic.Bool
+	tokens           *auth.TokenManager
+	userLimiter      *rateLimiter
+	ipLimiter        *rateLimiter
+	active           map[int32]int  // Количество незавершённых выражений каждого пользователя
+	activeIP         map[string]int // Количество незавершённых выражений с каждого IP-адреса
+	shares           map[int32]*ownerShare
+	virtualTime      float64 // Виртуальное время владельца последней выданной задачи
+	queued           int     // Принятых и ещё не завершённых выражений
+	pendingNodes     int     // Невычисленных операций в них
+
+	agentsSeen map[string]time.Time // Время последнего запроса задачи каждым агентом
 }
 
 type DataBase struct {
 	ExpressionList map[int32]*Expression
+	Users          map[int32]*User
+	PrevUserID     int32
+	logins         map[string]*User
+	APIKeys        map[int32]*APIKey
+	PrevAPIKeyID   int32
+	apiKeyHashes   map[string]*APIKey
 	mu             sync.Mutex
 }
 
@@ -67,87 +118,162 @@ func NewDatabase() *DataBase {
 	logger.Debug("Creating new database")
 	return &DataBase{
 		ExpressionList: make(map[int32]*Expression),
+		Users:          make(map[int32]*User),
+		logins:         make(map[string]*User),
+		APIKeys:        make(map[int32]*APIKey),
+		apiKeyHashes:   make(map[string]*APIKey),
 	}
 }
 
 // Создание нового оркестратора
-func NewOrchestrator(cfg *config.Config) *Orchestrator {
+func NewOrchestrator(cfg *config.Config) (*Orchestrator, error) {
 	logger.Info("Initializing orchestrator",
+		"version", version.Version,
 		"port", cfg.Orchestrator.Port,
 		"time_addition_ms", cfg.Orchestrator.TimeAdditionMS,
 		"time_subtraction_ms", cfg.Orchestrator.TimeSubtractionMS,
 		"time_multiplications_ms", cfg.Orchestrator.TimeMultiplicationsMS,
 		"time_divisions_ms", cfg.Orchestrator.TimeDivisionsMS)
 
-	return &Orchestrator{
-		Queue:    make([]*Expression, 0),
+	o := &Orchestrator{
+		Queue:    NewExpressionQueue(time.Duration(cfg.Orchestrator.PriorityAgingMS) * time.Millisecond),
 		DataBase: NewDatabase(),
 		Config:   &cfg.Orchestrator,
+		leases:   make(map[taskKey]*lease),
+		active:   make(map[int32]int),
+		activeIP: make(map[string]int),
+		shares:   make(map[int32]*ownerShare),
+
+		agentsSeen: make(map[string]time.Time),
+
+		userLimiter: newRateLimiter(cfg.Orchestrator.RateLimitRPS, cfg.Orchestrator.RateLimitBurst),
+		ipLimiter:   newRateLimiter(cfg.Orchestrator.IPRateLimitRPS, cfg.Orchestrator.IPRateLimitBurst),
+	}
+	o.metrics = newOrchestratorMetrics(o)
+
+	ttl := time.Duration(cfg.Orchestrator.TokenTTLMinutes) * time.Minute
+	if ttl <= 0 {
+		ttl = defaultTokenTTL
+	}
+	if cfg.Orchestrator.JWTSecret == "" {
+		logger.Warn("JWT secret is not configured, using random key; tokens will not survive restart")
 	}
+	tokens, err := auth.NewTokenManager(cfg.Orchestrator.JWTSecret, ttl)
+	if err != nil {
+		logger.Error("Failed to create orchestrator", "error", err)
+		return nil, fmt.Errorf("token manager: %w", err)
+	}
+	o.tokens = tokens
+
+	return o, nil
 }
 
 // Запуск оркестратора
 func (o *Orchestrator) RunOrchestration(ctx context.Context) error {
 	logger.Info("Starting orchestration service")
 
-	mux := http.NewServeMux()
-	mux.HandleFunc("/api/v1/calculate", o.CalculateHandler)
-	mux.HandleFunc("/api/v1/expressions", o.ExpressionsListHandler)
-	mux.HandleFunc("/api/v1/expressions/", o.GetExpressionByIDHandler)
-	mux.HandleFunc("/internal/task", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == http.MethodGet {
-			o.GetTaskHandler(w, r)
-		} else if r.Method == http.MethodPost {
-			o.PostTaskHandler(w, r)
-		} else {
-			logger.Warn("Wrong method for /internal/task",
-				"method", r.Method,
-				"remote_addr", r.RemoteAddr)
-			http.Error(w, `{"error":"Wrong Method"}`, http.StatusMethodNotAllowed)
+	if o.Config.AgentSecret == "" && len(o.Config.AgentTokens) == 0 && o.Config.TLS.CAFile == "" {
+		logger.Error("Agent credentials are not configured: set agent_secret, agent_tokens or tls.ca_file")
+		return errNoAgentCredentials
+	}
+
+	// Контекст запросов отменяется вместе с ctx, чтобы завершать WebSocket-сессии агентов
+	baseContext := func(net.Listener) context.Context {
+		return ctx
+	}
+
+	// Публичный API и внутренние эндпоинты для агентов слушают разные порты
+	servers := []*http.Server{
+		{
+			Addr:        fmt.Sprintf(":%d", o.Config.Port),
+			Handler:     o.handler(),
+			BaseContext: baseContext,
+		},
+		{
+			Addr:        fmt.Sprintf(":%d", o.Config.InternalPort),
+			Handler:     o.internalHandler(),
+			BaseContext: baseContext,
+		},
+	}
+
+	internalTLS := o.Config.TLS.CertFile != ""
+	if internalTLS {
+		tlsConfig, err := mtls.ServerConfig(o.Config.TLS)
+		if err != nil {
+			logger.Error("Failed to configure internal TLS", "error", err)
+			return fmt.Errorf("internal tls: %w", err)
 		}
-	})
+		servers[1].TLSConfig = tlsConfig
+	}
 
-	port := fmt.Sprintf("%d", o.Config.Port)
-	server := &http.Server{
-		Addr:    ":" + port,
-		Handler: mux,
+	serverError := make(chan error, len(servers))
+
+	if o.Config.AdminAddr != "" {
+		go o.serveAdmin(ctx, o.Config.AdminAddr)
 	}
 
-	serverError := make(chan error, 1)
+	logger.Info("Starting HTTP servers",
+		"port", o.Config.Port,
+		"internal_port", o.Config.InternalPort,
+		"internal_tls", internalTLS,
+		"internal_mtls", o.Config.TLS.CAFile != "")
+
+	for _, server := range servers {
+		go func(server *http.Server) {
+			var err error
+			if server.TLSConfig != nil {
+				err = server.ListenAndServeTLS("", "")
+			} else {
+				err = server.ListenAndServe()
+			}
+			if err != nil && err != http.ErrServerClosed {
+				logger.Error("Server error", "addr", server.Addr, "error", err)
+				serverError <- err
+			} else {
+				serverError <- nil
+			}
+		}(server)
+	}
 
-	logger.Info("Starting HTTP server", "port", port)
+	shutdown := func() error {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
 
-	go func() {
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			logger.Error("Server error", "error", err)
-			serverError <- err
-		} else {
-			serverError <- nil
+		var shutdownErr error
+		for _, server := range servers {
+			if err := server.Shutdown(shutdownCtx); err != nil {
+				logger.Error("Server shutdown error", "addr", server.Addr, "error", err)
+				shutdownErr = errors.Join(shutdownErr, err)
+			}
 		}
-	}()
+		return shutdownErr
+	}
 
 	select {
 	case <-ctx.Done():
-		logger.Info("Context done, shutting down server")
-		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		defer cancel()
+		o.draining.Store(true)
+		if delay := time.Duration(o.Config.ShutdownDelayMS) * time.Millisecond; delay > 0 {
+			logger.Info("Draining before shutdown", "delay_ms", delay.Milliseconds())
+			time.Sleep(delay)
+		}
 
-		if err := server.Shutdown(shutdownCtx); err != nil {
-			logger.Error("Server shutdown error", "error", err)
+		logger.Info("Context done, shutting down servers")
+		if err := shutdown(); err != nil {
 			return fmt.Errorf("server shutdown error: %w", err)
 		}
 
-		err := <-serverError
-		if err != nil {
-			logger.Error("Server error during shutdown", "error", err)
-			return fmt.Errorf("server error during shutdown: %w", err)
+		for range servers {
+			if err := <-serverError; err != nil {
+				logger.Error("Server error during shutdown", "error", err)
+				return fmt.Errorf("server error during shutdown: %w", err)
+			}
 		}
 
 		logger.Info("Server shutdown complete")
 		return nil
 	case err := <-serverError:
+		shutdown()
 		if err != nil {
-			logger.Error("Server error", "error", err)
 			return fmt.Errorf("server error: %w", err)
 		}
 		logger.Info("Server stopped")
@@ -155,28 +281,76 @@ func (o *Orchestrator) RunOrchestration(ctx context.Context) error {
 	}
 }
 
+// Маршруты HTTP-сервера оркестратора
+func (o *Orchestrator) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/register", o.instrument("register", audited(logger.AuditRegister, o.limitIP(o.RegisterHandler))))
+	mux.HandleFunc("/api/v1/login", o.instrument("login", audited(logger.AuditLogin, o.limitIP(o.LoginHandler))))
+	mux.HandleFunc("/api/v1/calculate", o.instrument("calculate", audited(logger.AuditSubmit, requireMethod(http.MethodPost, o.limitIP(o.requireUser(auth.ScopeSubmit, o.limitUser(o.CalculateHandler)))))))
+	mux.HandleFunc("/api/v1/expressions", o.instrument("expressions_list", audited(logger.AuditList, o.limitIP(o.requireUser(auth.ScopeRead, o.limitUser(o.ExpressionsListHandler))))))
+	mux.HandleFunc("/api/v1/expressions/", o.expressionHandler())
+	mux.HandleFunc("/api/v1/keys", o.instrument("api_keys", audited(logger.AuditAPIKeys, o.limitIP(o.requireUser("", o.limitUser(o.APIKeysHandler))))))
+	mux.HandleFunc("/api/v1/keys/", o.instrument("api_key", audited(logger.AuditRevokeAPIKey, o.limitIP(o.requireUser("", o.limitUser(o.RevokeAPIKeyHandler))))))
+	mux.Handle("/metrics", promhttp.HandlerFor(o.metrics.registry, promhttp.HandlerOpts{}))
+	mux.HandleFunc("/healthz", o.HealthzHandler)
+	mux.HandleFunc("/readyz", o.ReadyzHandler)
+	mux.HandleFunc("/version", o.VersionHandler)
+	return withRequestID(mux)
+}
+
+// Маршруты отдельного выражения: просмотр, отмена (POST .../cancel) и удаление (DELETE)
+func (o *Orchestrator) expressionHandler() http.HandlerFunc {
+	view := o.instrument("expression", audited(logger.AuditView, o.limitIP(o.requireUser(auth.ScopeRead, o.limitUser(o.GetExpressionByIDHandler)))))
+	cancel := o.instrument("expression_cancel", audited(logger.AuditCancel, o.limitIP(o.requireUser(auth.ScopeSubmit, o.limitUser(o.CancelExpressionHandler)))))
+	remove := o.instrument("expression_delete", audited(logger.AuditDelete, o.limitIP(o.requireUser(auth.ScopeSubmit, o.limitUser(o.DeleteExpressionHandler)))))
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/cancel"):
+			cancel(w, r)
+		case r.Method == http.MethodDelete:
+			remove(w, r)
+		default:
+			view(w, r)
+		}
+	}
+}
+
 // Подготовка полученного выражения к обработке
 func (o *Orchestrator) prepareInput(input string) (*Expression, error) {
-	logger.Debug("Preparing input expression", "input", input)
+	parserLog().Debug("Preparing input expression", "input", input)
 
 	levelMap, maxLevel, err := parser.ParseExpression(input)
 	if err != nil {
-		logger.Error("Failed to parse expression",
+		parserLog().Error("Failed to parse expression",
 			"input", input,
 			"error", err)
 		return nil, err
 	}
 
-	logger.Debug("Expression parsed successfully",
+	parserLog().Debug("Expression parsed successfully",
 		"max_level", maxLevel,
 		"levels_count", len(levelMap))
 
+	if max := o.maxExpressionNodes(); max > 0 {
+		nodes := 0
+		for _, level := range levelMap {
+			nodes += len(level)
+		}
+		if nodes > max {
+			parserLog().Warn("Expression exceeds node limit",
+				"nodes_count", nodes,
+				"max_nodes", max)
+			return nil, errTooManyNodes
+		}
+	}
+
 	prevID := 0
 	expr := o.NewExpression()
 
 	for level := 0; level <= maxLevel; level++ {
 		nodes := levelMap[level]
-		logger.Debug("Processing level",
+		parserLog().Debug("Processing level",
 			"level", level,
 			"nodes_count", len(nodes),
 			"expression_id", expr.ID)
@@ -184,7 +358,7 @@ func (o *Orchestrator) prepareInput(input string) (*Expression, error) {
 		for _, node := range nodes {
 			curID := prevID + 1
 			if _, ok := expr.IdMap[curID]; ok {
-				logger.Error("Node ID collision",
+				parserLog().Error("Node ID collision",
 					"node_id", curID,
 					"expression_id", expr.ID)
 				return nil, fmt.Errorf("почему уже есть?")
@@ -192,12 +366,12 @@ func (o *Orchestrator) prepareInput(input string) (*Expression, error) {
 
 			if node.Type == models.Number {
 				node.Status = models.StatusDone
-				logger.Debug("Node is a number, marking as done",
+				parserLog().Debug("Node is a number, marking as done",
 					"node_id", curID,
 					"value", node.Value)
 			} else {
 				node.Status = models.StatusInQueue
-				logger.Debug("Node is an operation, marking as in queue",
+				parserLog().Debug("Node is an operation, marking as in queue",
 					"node_id", curID,
 					"operation", node.Value)
 			}
@@ -207,7 +381,14 @@ func (o *Orchestrator) prepareInput(input string) (*Expression, error) {
 		}
 	}
 
-	logger.Info("Input preparation completed",
+	expr.paths = o.criticalPaths(expr)
+	for _, node := range expr.IdMap {
+		if node.Type == models.Operator {
+			expr.tasks++
+		}
+	}
+
+	parserLog().Info("Input preparation completed",
 		"expression_id", expr.ID,
 		"nodes_count", len(expr.IdMap))
 
@@ -215,7 +396,7 @@ func (o *Orchestrator) prepareInput(input string) (*Expression, error) {
 	o.DataBase.ExpressionList[expr.ID] = expr
 	o.DataBase.mu.Unlock()
 
-	logger.Debug("Expression added to database",
+	parserLog().Debug("Expression added to database",
 		"expression_id", expr.ID)
 
 	return expr, nil
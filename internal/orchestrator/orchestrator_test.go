@@ -1,21 +1,78 @@
 package orchestrator
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"errors"
+	"final3/internal/auth"
 	"final3/internal/config"
+	"final3/internal/logger"
 	"final3/internal/models"
+	"final3/internal/tracing"
+	"final3/internal/version"
+	"fmt"
+	"io"
+	"log/slog"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
 	"testing"
 	"time"
+
+	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
+// Регистрация пользователя login и выпуск его токена доступа
+func testToken(t *testing.T, orch *Orchestrator, login string) string {
+	t.Helper()
+
+	user, err := orch.createUser(login, "password")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	token, _, err := orch.tokens.Issue(user.ID, user.Login)
+	if err != nil {
+		t.Fatalf("Failed to issue token: %v", err)
+	}
+	return "Bearer " + token
+}
+
+// Создание оркестратора для теста
+func newTestOrchestrator(t *testing.T, cfg *config.Config) *Orchestrator {
+	t.Helper()
+
+	orch, err := NewOrchestrator(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create orchestrator: %v", err)
+	}
+	return orch
+}
+
+const testAgentSecret = "agent-secret"
+
+// Подпись запроса агента agentID токеном, выпущенным по testAgentSecret
+func asAgent(req *http.Request, agentID string) *http.Request {
+	req.Header.Set(agentIDHeader, agentID)
+	req.Header.Set("Authorization", "Bearer "+auth.AgentToken(testAgentSecret, agentID))
+	return req
+}
+
 func TestPrepareInput(t *testing.T) {
 	cfg := &config.Config{
 		Orchestrator: config.OrchestratorConfig{},
 	}
 
-	orch := NewOrchestrator(cfg)
+	orch := newTestOrchestrator(t, cfg)
 
 	tests := []struct {
 		name        string
@@ -162,6 +219,8 @@ func TestRunOrchestration(t *testing.T) {
 	cfg := &config.Config{
 		Orchestrator: config.OrchestratorConfig{
 			Port:                  8090,
+			InternalPort:          8091,
+			AgentSecret:           testAgentSecret,
 			TimeAdditionMS:        100,
 			TimeSubtractionMS:     100,
 			TimeMultiplicationsMS: 200,
@@ -169,7 +228,7 @@ func TestRunOrchestration(t *testing.T) {
 		},
 	}
 
-	orch := NewOrchestrator(cfg)
+	orch := newTestOrchestrator(t, cfg)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
 	defer cancel()
@@ -195,32 +254,50 @@ func TestRunOrchestration(t *testing.T) {
 			name:   "CalculateEndpoint",
 			path:   "http://localhost:8090/api/v1/calculate",
 			method: http.MethodGet,
-			status: http.StatusUnprocessableEntity,
+			status: http.StatusMethodNotAllowed,
 		},
 		{
 			name:   "ExpressionsListEndpoint",
 			path:   "http://localhost:8090/api/v1/expressions",
 			method: http.MethodGet,
-			status: http.StatusOK,
+			status: http.StatusUnauthorized,
 		},
 		{
 			name:   "GetExpressionByIDEndpoint",
 			path:   "http://localhost:8090/api/v1/expressions/1",
 			method: http.MethodGet,
-			status: http.StatusNotFound,
+			status: http.StatusUnauthorized,
+		},
+		{
+			name:   "RegisterEndpoint",
+			path:   "http://localhost:8090/api/v1/register",
+			method: http.MethodGet,
+			status: http.StatusUnprocessableEntity,
+		},
+		{
+			name:   "LoginEndpoint",
+			path:   "http://localhost:8090/api/v1/login",
+			method: http.MethodGet,
+			status: http.StatusUnprocessableEntity,
 		},
 		{
 			name:   "GetTaskEndpoint",
-			path:   "http://localhost:8090/internal/task",
+			path:   "http://localhost:8091/internal/task",
 			method: http.MethodGet,
 			status: http.StatusNotFound,
 		},
 		{
 			name:   "PostTaskEndpoint",
-			path:   "http://localhost:8090/internal/task",
+			path:   "http://localhost:8091/internal/task",
 			method: http.MethodPost,
 			status: http.StatusUnprocessableEntity,
 		},
+		{
+			name:   "InternalEndpointOnPublicPort",
+			path:   "http://localhost:8090/internal/task",
+			method: http.MethodGet,
+			status: http.StatusNotFound,
+		},
 	}
 
 	client := &http.Client{
@@ -234,7 +311,7 @@ func TestRunOrchestration(t *testing.T) {
 				t.Fatalf("Failed to create request: %v", err)
 			}
 
-			resp, err := client.Do(req)
+			resp, err := client.Do(asAgent(req, "agent-1"))
 			if err != nil {
 				t.Skipf("Endpoint test skipped, server might not be ready: %v", err)
 				return
@@ -258,11 +335,12 @@ func TestRunOrchestration(t *testing.T) {
 func TestRunOrchestrationContextCancellation(t *testing.T) {
 	cfg := &config.Config{
 		Orchestrator: config.OrchestratorConfig{
-			Port: 8091,
+			Port:        8091,
+			AgentSecret: testAgentSecret,
 		},
 	}
 
-	orch := NewOrchestrator(cfg)
+	orch := newTestOrchestrator(t, cfg)
 
 	ctx, cancel := context.WithCancel(context.Background())
 
@@ -288,18 +366,20 @@ func TestRunOrchestrationContextCancellation(t *testing.T) {
 func TestRunOrchestrationPortConflict(t *testing.T) {
 	cfg1 := &config.Config{
 		Orchestrator: config.OrchestratorConfig{
-			Port: 8092,
+			Port:        8092,
+			AgentSecret: testAgentSecret,
 		},
 	}
 
 	cfg2 := &config.Config{
 		Orchestrator: config.OrchestratorConfig{
-			Port: 8092,
+			Port:        8092,
+			AgentSecret: testAgentSecret,
 		},
 	}
 
-	orch1 := NewOrchestrator(cfg1)
-	orch2 := NewOrchestrator(cfg2)
+	orch1 := newTestOrchestrator(t, cfg1)
+	orch2 := newTestOrchestrator(t, cfg2)
 
 	ctx1, cancel1 := context.WithCancel(context.Background())
 	defer cancel1()
@@ -330,11 +410,12 @@ func TestRunOrchestrationPortConflict(t *testing.T) {
 func TestRunOrchestrationShutdown(t *testing.T) {
 	cfg := &config.Config{
 		Orchestrator: config.OrchestratorConfig{
-			Port: 8093,
+			Port:        8093,
+			AgentSecret: testAgentSecret,
 		},
 	}
 
-	orch := NewOrchestrator(cfg)
+	orch := newTestOrchestrator(t, cfg)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
 	defer cancel()
@@ -351,3 +432,1984 @@ func TestRunOrchestrationShutdown(t *testing.T) {
 		t.Errorf("RunOrchestration returned too quickly: %v", duration)
 	}
 }
+
+func TestAgentWebSocket(t *testing.T) {
+	cfg := &config.Config{
+		Orchestrator: config.OrchestratorConfig{
+			TimeAdditionMS:        10,
+			TimeSubtractionMS:     10,
+			TimeMultiplicationsMS: 10,
+			TimeDivisionsMS:       10,
+		},
+	}
+
+	orch := newTestOrchestrator(t, cfg)
+	server := httptest.NewServer(http.HandlerFunc(orch.AgentWebSocketHandler))
+	defer server.Close()
+	token := testToken(t, orch, "alice")
+
+	submit := func(expression string) *Expression {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/calculate", strings.NewReader(`{"expression": "`+expression+`"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", token)
+		rec := httptest.NewRecorder()
+		orch.handler().ServeHTTP(rec, req)
+
+		var resp struct {
+			ID int32 `json:"id"`
+		}
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatalf("Failed to decode calculate response: %v", err)
+		}
+		return orch.DataBase.ExpressionList[resp.ID]
+	}
+
+	dial := func() *websocket.Conn {
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"?capacity=1", nil)
+		if err != nil {
+			t.Fatalf("Failed to dial websocket: %v", err)
+		}
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		return conn
+	}
+
+	t.Run("RequeueOnDisconnect", func(t *testing.T) {
+		expr := submit("2+3")
+
+		conn := dial()
+		var task models.Task
+		if err := conn.ReadJSON(&task); err != nil {
+			t.Fatalf("Failed to read task: %v", err)
+		}
+		conn.Close()
+
+		deadline := time.Now().Add(time.Second)
+		for time.Now().Before(deadline) {
+			expr.mu.Lock()
+			status := expr.IdMap[task.ID].Status
+			expr.mu.Unlock()
+			if status == models.StatusInQueue {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Error("Task was not returned to queue after agent disconnected")
+	})
+
+	t.Run("PushAndComplete", func(t *testing.T) {
+		conn := dial()
+		defer conn.Close()
+
+		var task models.Task
+		if err := conn.ReadJSON(&task); err != nil {
+			t.Fatalf("Failed to read task: %v", err)
+		}
+
+		if task.Operation != "+" || task.Arg1 != 2 || task.Arg2 != 3 {
+			t.Fatalf("Unexpected task: %+v", task)
+		}
+
+		err := conn.WriteJSON(models.TaskResult{
+			ID:           task.ID,
+			ExpressionID: task.ExpressionID,
+			Result:       task.Arg1 + task.Arg2,
+		})
+		if err != nil {
+			t.Fatalf("Failed to write result: %v", err)
+		}
+
+		expr := orch.DataBase.ExpressionList[task.ExpressionID]
+		deadline := time.Now().Add(time.Second)
+		for time.Now().Before(deadline) {
+			expr.mu.Lock()
+			status, result := expr.Status, expr.Result
+			expr.mu.Unlock()
+			if status == StatusDone {
+				if result != 5 {
+					t.Errorf("Expected result 5, got %f", result)
+				}
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Error("Expression was not completed")
+	})
+
+	// Задачи приходят по оповещениям об изменении очереди, без её периодического опроса
+	t.Run("PushOnSubmitAndResult", func(t *testing.T) {
+		conn := dial()
+		defer conn.Close()
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+
+		// Соединение успевает проверить пустую очередь и ждёт оповещения
+		time.Sleep(50 * time.Millisecond)
+		expr := submit("2*3+4")
+
+		var first models.Task
+		if err := conn.ReadJSON(&first); err != nil {
+			t.Fatalf("Failed to read task after submit: %v", err)
+		}
+		if first.Operation != "*" {
+			t.Fatalf("Expected multiplication first, got %+v", first)
+		}
+
+		err := conn.WriteJSON(models.TaskResult{ID: first.ID, ExpressionID: first.ExpressionID, Result: 6})
+		if err != nil {
+			t.Fatalf("Failed to write result: %v", err)
+		}
+
+		var second models.Task
+		if err := conn.ReadJSON(&second); err != nil {
+			t.Fatalf("Failed to read task after result: %v", err)
+		}
+		if second.Operation != "+" || second.Arg1 != 6 || second.Arg2 != 4 || second.ExpressionID != expr.ID {
+			t.Errorf("Unexpected second task: %+v", second)
+		}
+	})
+}
+
+func TestReleaseTaskHandler(t *testing.T) {
+	cfg := &config.Config{
+		Orchestrator: config.OrchestratorConfig{},
+	}
+
+	orch := newTestOrchestrator(t, cfg)
+	expr, err := orch.prepareInput("2+3")
+	if err != nil {
+		t.Fatalf("Failed to prepare input: %v", err)
+	}
+	orch.Queue.Push(expr)
+
+	task, err := orch.nextTask(context.Background(), "agent-1")
+	if err != nil {
+		t.Fatalf("Failed to get task: %v", err)
+	}
+
+	release := func(agentID string) int {
+		body := `{"id": ` + strconv.Itoa(task.ID) + `, "expression_id": ` + strconv.Itoa(int(task.ExpressionID)) + `}`
+		req := httptest.NewRequest(http.MethodPost, "/internal/task/release", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(agentIDHeader, agentID)
+		rec := httptest.NewRecorder()
+		orch.ReleaseTaskHandler(rec, req)
+		return rec.Code
+	}
+
+	if code := release("agent-2"); code != http.StatusConflict {
+		t.Errorf("Expected status %d for foreign agent, got %d", http.StatusConflict, code)
+	}
+
+	if code := release("agent-1"); code != http.StatusOK {
+		t.Errorf("Expected status %d for leasing agent, got %d", http.StatusOK, code)
+	}
+
+	if status := expr.IdMap[task.ID].Status; status != models.StatusInQueue {
+		t.Errorf("Expected released task status %s, got %s", models.StatusInQueue, status)
+	}
+
+	if code := release("agent-1"); code != http.StatusNotFound {
+		t.Errorf("Expected status %d for repeated release, got %d", http.StatusNotFound, code)
+	}
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	cfg := &config.Config{
+		Orchestrator: config.OrchestratorConfig{AgentSecret: testAgentSecret},
+	}
+
+	orch := newTestOrchestrator(t, cfg)
+	server := httptest.NewServer(orch.handler())
+	defer server.Close()
+	internal := httptest.NewServer(orch.internalHandler())
+	defer internal.Close()
+	token := testToken(t, orch, "alice")
+
+	submit := func(expression string) {
+		req, _ := http.NewRequest(http.MethodPost, server.URL+"/api/v1/calculate",
+			strings.NewReader(`{"expression": "`+expression+`"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Failed to submit expression: %v", err)
+		}
+		resp.Body.Close()
+	}
+
+	complete := func(errText string) {
+		req, _ := http.NewRequest(http.MethodGet, internal.URL+"/internal/task", nil)
+		resp, err := http.DefaultClient.Do(asAgent(req, "agent-1"))
+		if err != nil {
+			t.Fatalf("Failed to get task: %v", err)
+		}
+		var task models.Task
+		if err := json.NewDecoder(resp.Body).Decode(&task); err != nil {
+			t.Fatalf("Failed to decode task: %v", err)
+		}
+		resp.Body.Close()
+
+		body, _ := json.Marshal(models.TaskResult{
+			ID:           task.ID,
+			ExpressionID: task.ExpressionID,
+			Result:       6,
+			Error:        errText,
+		})
+		req, _ = http.NewRequest(http.MethodPost, internal.URL+"/internal/task", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err = http.DefaultClient.Do(asAgent(req, "agent-1"))
+		if err != nil {
+			t.Fatalf("Failed to post result: %v", err)
+		}
+		resp.Body.Close()
+	}
+
+	submit("2*3")
+	complete("")
+	submit("1/0")
+	complete("division by zero")
+	submit("1+1")
+
+	resp, err := http.Get(server.URL + "/metrics")
+	if err != nil {
+		t.Fatalf("Failed to get metrics: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var sb strings.Builder
+	if _, err := io.Copy(&sb, resp.Body); err != nil {
+		t.Fatalf("Failed to read metrics: %v", err)
+	}
+	body := sb.String()
+
+	expected := []string{
+		"orchestrator_queue_length 1",
+		"orchestrator_tasks_in_flight 0",
+		`orchestrator_expressions{status="done"} 1`,
+		`orchestrator_expressions{status="error"} 1`,
+		`orchestrator_expressions{status="in_queue"} 1`,
+		`orchestrator_tasks_dispatched_total{operator="*"} 1`,
+		`orchestrator_tasks_completed_total{operator="*"} 1`,
+		`orchestrator_tasks_failed_total{operator="/"} 1`,
+		`orchestrator_task_duration_seconds_count{operator="*"} 1`,
+		`orchestrator_expression_duration_seconds_count{status="done"} 1`,
+		`orchestrator_http_requests_total{code="200",handler="calculate"} 3`,
+		`orchestrator_http_requests_total{code="200",handler="task"} 4`,
+	}
+	for _, line := range expected {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("Expected line %q in metrics:\n%s", line, body)
+		}
+	}
+}
+
+func TestHealthEndpoints(t *testing.T) {
+	cfg := &config.Config{
+		Orchestrator: config.OrchestratorConfig{},
+	}
+
+	orch := newTestOrchestrator(t, cfg)
+	handler := orch.handler()
+
+	get := func(ctx context.Context, path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil).WithContext(ctx))
+		return rec
+	}
+
+	if rec := get(context.Background(), "/healthz"); rec.Code != http.StatusOK {
+		t.Errorf("Expected /healthz status %d, got %d", http.StatusOK, rec.Code)
+	}
+
+	if rec := get(context.Background(), "/readyz"); rec.Code != http.StatusOK {
+		t.Errorf("Expected /readyz status %d, got %d", http.StatusOK, rec.Code)
+	}
+
+	// Блокировка, которую обработчик держит недолго, на готовность не влияет
+	t.Run("StorageBrieflyLocked", func(t *testing.T) {
+		orch.DataBase.mu.Lock()
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			orch.DataBase.mu.Unlock()
+		}()
+
+		if rec := get(context.Background(), "/readyz"); rec.Code != http.StatusOK {
+			t.Errorf("Expected /readyz status %d with briefly locked storage, got %d", http.StatusOK, rec.Code)
+		}
+	})
+
+	t.Run("StorageLocked", func(t *testing.T) {
+		orch.DataBase.mu.Lock()
+		defer orch.DataBase.mu.Unlock()
+
+		start := time.Now()
+		rec := get(context.Background(), "/readyz")
+		if rec.Code != http.StatusServiceUnavailable {
+			t.Errorf("Expected /readyz status %d with locked storage, got %d", http.StatusServiceUnavailable, rec.Code)
+		}
+		if elapsed := time.Since(start); elapsed < readinessTimeout {
+			t.Errorf("Expected /readyz to wait %v for the lock, waited %v", readinessTimeout, elapsed)
+		}
+		if !strings.Contains(rec.Body.String(), "storage locked") {
+			t.Errorf("Expected storage locked reason, got %s", rec.Body.String())
+		}
+	})
+
+	t.Run("Draining", func(t *testing.T) {
+		orch.draining.Store(true)
+		defer orch.draining.Store(false)
+
+		rec := get(context.Background(), "/readyz")
+		if rec.Code != http.StatusServiceUnavailable {
+			t.Errorf("Expected /readyz status %d while draining, got %d", http.StatusServiceUnavailable, rec.Code)
+		}
+		if !strings.Contains(rec.Body.String(), "draining") {
+			t.Errorf("Expected draining reason, got %s", rec.Body.String())
+		}
+	})
+
+	rec := get(context.Background(), "/version")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected /version status %d, got %d", http.StatusOK, rec.Code)
+	}
+
+	var info version.Info
+	if err := json.NewDecoder(rec.Body).Decode(&info); err != nil {
+		t.Fatalf("Failed to decode version: %v", err)
+	}
+	if info.Version != version.Version || info.GoVersion == "" {
+		t.Errorf("Unexpected version info: %+v", info)
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	cfg := &config.Config{
+		Orchestrator: config.OrchestratorConfig{AgentSecret: testAgentSecret},
+	}
+
+	orch := newTestOrchestrator(t, cfg)
+	handler := orch.handler()
+	token := testToken(t, orch, "alice")
+
+	submit := func(requestID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/calculate", strings.NewReader(`{"expression": "2+3"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", token)
+		if requestID != "" {
+			req.Header.Set(requestIDHeader, requestID)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Идентификатор в формате W3C trace-id становится идентификатором трассировки выражения
+	const clientID = "0af7651916cd43dd8448eb211c80319c"
+	for _, id := range []string{clientID, "client-id-1"} {
+		if got := submit(id).Header().Get(requestIDHeader); got != id {
+			t.Errorf("Expected client request ID %q to be echoed, got %q", id, got)
+		}
+	}
+
+	for _, id := range []string{"", "has space", strings.Repeat("x", maxRequestIDLen+1)} {
+		if got := submit(id).Header().Get(requestIDHeader); len(got) != 32 || got == id {
+			t.Errorf("Expected generated request ID for %q, got %q", id, got)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	orch.internalHandler().ServeHTTP(rec, asAgent(httptest.NewRequest(http.MethodGet, "/internal/task", nil), "agent-1"))
+
+	var task models.Task
+	if err := json.NewDecoder(rec.Body).Decode(&task); err != nil {
+		t.Fatalf("Failed to decode task: %v", err)
+	}
+	if task.TraceID != clientID {
+		t.Errorf("Expected task trace ID %q, got %q", clientID, task.TraceID)
+	}
+}
+
+// Экспортёр в память, сохраняющий спаны после остановки трассировщика
+type memoryExporter struct {
+	*tracetest.InMemoryExporter
+}
+
+func (memoryExporter) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+func TestTracing(t *testing.T) {
+	exporter := memoryExporter{tracetest.NewInMemoryExporter()}
+	tracer := tracing.NewTracer("orchestrator", exporter)
+	tracing.SetTracer(tracer)
+	defer tracing.SetTracer(tracing.NewTracer("", nil))
+
+	cfg := &config.Config{
+		Orchestrator: config.OrchestratorConfig{AgentSecret: testAgentSecret},
+	}
+
+	orch := newTestOrchestrator(t, cfg)
+	handler := orch.handler()
+
+	const clientTraceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/calculate", strings.NewReader(`{"expression": "2*3"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", testToken(t, orch, "alice"))
+	req.Header.Set(tracing.TraceparentHeader, clientTraceparent)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	internal := orch.internalHandler()
+	rec := httptest.NewRecorder()
+	internal.ServeHTTP(rec, asAgent(httptest.NewRequest(http.MethodGet, "/internal/task", nil), "agent-1"))
+
+	var task models.Task
+	if err := json.NewDecoder(rec.Body).Decode(&task); err != nil {
+		t.Fatalf("Failed to decode task: %v", err)
+	}
+	if rec.Header().Get(tracing.TraceparentHeader) != task.Traceparent || task.Traceparent == "" {
+		t.Errorf("Expected traceparent header %q to match task", rec.Header().Get(tracing.TraceparentHeader))
+	}
+
+	body, _ := json.Marshal(models.TaskResult{ID: task.ID, ExpressionID: task.ExpressionID, Result: 6})
+	req = httptest.NewRequest(http.MethodPost, "/internal/task", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	internal.ServeHTTP(httptest.NewRecorder(), asAgent(req, "agent-1"))
+
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Failed to shut down tracer: %v", err)
+	}
+
+	spans := make(map[string]tracetest.SpanStub)
+	for _, span := range exporter.GetSpans() {
+		spans[span.Name] = span
+	}
+
+	root, parse, taskSpan := spans["expression"], spans["parse"], spans["task *"]
+	rootID := root.SpanContext.SpanID()
+	if root.SpanContext.TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" || root.Parent.SpanID().String() != "00f067aa0ba902b7" {
+		t.Errorf("Expression span is not a child of client span: %+v", root)
+	}
+	attrs := attribute.NewSet(root.Attributes...)
+	if status, _ := attrs.Value("status"); status.AsString() != string(StatusDone) {
+		t.Errorf("Expected expression span status %s, got %v", StatusDone, status.Emit())
+	}
+	if parse.Parent.SpanID() != rootID || taskSpan.Parent.SpanID() != rootID {
+		t.Errorf("Parse and task spans must be children of expression span: %+v %+v", parse, taskSpan)
+	}
+	if task.Traceparent != tracing.Traceparent(taskSpan.SpanContext) {
+		t.Errorf("Task traceparent %q does not match task span %+v", task.Traceparent, taskSpan.SpanContext)
+	}
+	if task.TraceID != root.SpanContext.TraceID().String() {
+		t.Errorf("Expected log trace ID %s to match trace, got %s", root.SpanContext.TraceID(), task.TraceID)
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Audit.Enabled = true
+	cfg.Audit.Dir = t.TempDir()
+
+	if err := logger.InitAudit(cfg); err != nil {
+		t.Fatalf("Failed to init audit log: %v", err)
+	}
+	defer logger.CloseAudit()
+
+	// Журнал аудита не зависит от уровня логирования приложения
+	oldLevel := logger.GetLevel()
+	logger.SetLevel(slog.LevelError)
+	defer logger.SetLevel(oldLevel)
+
+	orch := newTestOrchestrator(t, cfg)
+	handler := orch.handler()
+	token := testToken(t, orch, "alice")
+
+	requests := []struct {
+		method string
+		path   string
+		body   string
+	}{
+		{http.MethodPost, "/api/v1/login", `{"login": "alice", "password": "wrong"}`},
+		{http.MethodPost, "/api/v1/calculate", `{"expression": "2+3"}`},
+		{http.MethodPost, "/api/v1/calculate", `{"expression": "(2+3"}`},
+		{http.MethodGet, "/api/v1/expressions", ""},
+		{http.MethodGet, "/api/v1/expressions/:1", ""},
+		{http.MethodGet, "/api/v1/expressions/:42", ""},
+		{http.MethodPost, "/api/v1/expressions/:1/cancel", ""},
+		{http.MethodPost, "/api/v1/expressions/:1/cancel", ""},
+		{http.MethodDelete, "/api/v1/expressions/:1", ""},
+		{http.MethodDelete, "/api/v1/expressions/:1", ""},
+		{http.MethodGet, "/internal/task", ""},
+	}
+	const clientTraceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	for _, r := range requests {
+		req := httptest.NewRequest(r.method, r.path, strings.NewReader(r.body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(tracing.TraceparentHeader, "00-"+clientTraceID+"-00f067aa0ba902b7-01")
+		if r.path != "/api/v1/login" {
+			req.Header.Set("Authorization", token)
+		}
+		req.RemoteAddr = "192.0.2.1:1234"
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	files, err := filepath.Glob(filepath.Join(cfg.Audit.Dir, "audit-*.log"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("Expected one audit file, got %v (%v)", files, err)
+	}
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatalf("Failed to read audit file: %v", err)
+	}
+
+	expected := []struct {
+		action       string
+		expressionID int32
+		status       int
+		outcome      string
+	}{
+		{logger.AuditLogin, 0, http.StatusUnauthorized, logger.OutcomeDenied},
+		{logger.AuditSubmit, 1, http.StatusOK, logger.OutcomeSuccess},
+		{logger.AuditSubmit, 0, http.StatusUnprocessableEntity, logger.OutcomeFailure},
+		{logger.AuditList, 0, http.StatusOK, logger.OutcomeSuccess},
+		{logger.AuditView, 1, http.StatusOK, logger.OutcomeSuccess},
+		{logger.AuditView, 42, http.StatusNotFound, logger.OutcomeFailure},
+		{logger.AuditCancel, 1, http.StatusOK, logger.OutcomeSuccess},
+		{logger.AuditCancel, 1, http.StatusConflict, logger.OutcomeFailure},
+		{logger.AuditDelete, 1, http.StatusNoContent, logger.OutcomeSuccess},
+		{logger.AuditDelete, 1, http.StatusNotFound, logger.OutcomeFailure},
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != len(expected) {
+		t.Fatalf("Expected %d audit records, got %d:\n%s", len(expected), len(lines), data)
+	}
+
+	for i, line := range lines {
+		var record logger.AuditRecord
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("Failed to decode audit record %q: %v", line, err)
+		}
+
+		want := expected[i]
+		if record.Action != want.action || record.ExpressionID != want.expressionID ||
+			record.Status != want.status || record.Outcome != want.outcome {
+			t.Errorf("Record %d: expected %+v, got %+v", i, want, record)
+		}
+		if record.User != "alice" {
+			t.Errorf("Record %d: expected user alice, got %q", i, record.User)
+		}
+		if record.RemoteAddr != "192.0.2.1:1234" {
+			t.Errorf("Record %d: expected remote address to be recorded, got %q", i, record.RemoteAddr)
+		}
+		if record.RequestID == "" || record.Time.IsZero() {
+			t.Errorf("Record %d: expected request ID and time, got %+v", i, record)
+		}
+		if record.Action == logger.AuditSubmit && record.TraceID != clientTraceID {
+			t.Errorf("Record %d: expected trace ID %q of the expression, got %q", i, clientTraceID, record.TraceID)
+		}
+	}
+}
+
+func TestUsers(t *testing.T) {
+	cfg := &config.Config{
+		Orchestrator: config.OrchestratorConfig{JWTSecret: "test-secret"},
+	}
+
+	handler := newTestOrchestrator(t, cfg).handler()
+
+	do := func(method, path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	registerTests := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{"Alice", `{"login": "alice", "password": "alice-password"}`, http.StatusOK},
+		{"Bob", `{"login": "bob", "password": "bob-password"}`, http.StatusOK},
+		{"Duplicate", `{"login": "alice", "password": "other-password"}`, http.StatusConflict},
+		{"ShortPassword", `{"login": "carol", "password": "short"}`, http.StatusUnprocessableEntity},
+		{"EmptyLogin", `{"login": " ", "password": "carol-password"}`, http.StatusUnprocessableEntity},
+	}
+	for _, tt := range registerTests {
+		t.Run("Register"+tt.name, func(t *testing.T) {
+			if rec := do(http.MethodPost, "/api/v1/register", "", tt.body); rec.Code != tt.status {
+				t.Errorf("Expected status %d, got %d: %s", tt.status, rec.Code, rec.Body.String())
+			}
+		})
+	}
+
+	login := func(login, password string) (string, int) {
+		rec := do(http.MethodPost, "/api/v1/login", "", `{"login": "`+login+`", "password": "`+password+`"}`)
+		var resp struct {
+			Token string `json:"token"`
+		}
+		json.NewDecoder(rec.Body).Decode(&resp)
+		return resp.Token, rec.Code
+	}
+
+	if _, code := login("alice", "wrong-password"); code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for wrong password, got %d", code)
+	}
+	if _, code := login("nobody", "alice-password"); code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for unknown user, got %d", code)
+	}
+
+	alice, code := login("alice", "alice-password")
+	if code != http.StatusOK || alice == "" {
+		t.Fatalf("Expected alice to log in, got %d", code)
+	}
+	bob, code := login("bob", "bob-password")
+	if code != http.StatusOK || bob == "" {
+		t.Fatalf("Expected bob to log in, got %d", code)
+	}
+
+	if rec := do(http.MethodGet, "/api/v1/expressions", "not-a-token", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for invalid token, got %d", rec.Code)
+	}
+	if rec := do(http.MethodGet, "/api/v1/calculate", "", ""); rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405 for wrong method without token, got %d", rec.Code)
+	}
+	if rec := do(http.MethodPost, "/api/v1/calculate", alice, `{"expression":`); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for invalid JSON, got %d", rec.Code)
+	}
+
+	rec := do(http.MethodPost, "/api/v1/calculate", alice, `{"expression": "2+3"}`)
+	var created struct {
+		ID int32 `json:"id"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&created); err != nil {
+		t.Fatalf("Failed to decode calculate response: %v", err)
+	}
+
+	list := func(token string) int {
+		var resp struct {
+			Expressions []struct {
+				ID int32 `json:"id"`
+			} `json:"expressions"`
+		}
+		json.NewDecoder(do(http.MethodGet, "/api/v1/expressions", token, "").Body).Decode(&resp)
+		return len(resp.Expressions)
+	}
+
+	if n := list(alice); n != 1 {
+		t.Errorf("Expected alice to see 1 expression, got %d", n)
+	}
+	if n := list(bob); n != 0 {
+		t.Errorf("Expected bob to see no expressions, got %d", n)
+	}
+
+	path := "/api/v1/expressions/:" + strconv.Itoa(int(created.ID))
+	if rec := do(http.MethodGet, path, alice, ""); rec.Code != http.StatusOK {
+		t.Errorf("Expected owner to view expression, got %d", rec.Code)
+	}
+	if rec := do(http.MethodGet, path, bob, ""); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for another user's expression, got %d", rec.Code)
+	}
+}
+
+func TestAPIKeys(t *testing.T) {
+	cfg := &config.Config{}
+	orch := newTestOrchestrator(t, cfg)
+	handler := orch.handler()
+	alice := testToken(t, orch, "alice")
+	bob := testToken(t, orch, "bob")
+
+	do := func(method, path, authorization, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", authorization)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	type keyResponse struct {
+		ID         int32      `json:"id"`
+		Key        string     `json:"key"`
+		Scopes     []string   `json:"scopes"`
+		LastUsedAt *time.Time `json:"last_used_at"`
+		RevokedAt  *time.Time `json:"revoked_at"`
+	}
+
+	create := func(body string) keyResponse {
+		t.Helper()
+		rec := do(http.MethodPost, "/api/v1/keys", alice, body)
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("Expected key to be created, got %d: %s", rec.Code, rec.Body.String())
+		}
+		var key keyResponse
+		if err := json.NewDecoder(rec.Body).Decode(&key); err != nil {
+			t.Fatalf("Failed to decode key: %v", err)
+		}
+		return key
+	}
+
+	submitKey := create(`{"name": "ci", "scopes": ["submit"]}`)
+	readKey := create(`{"name": "dashboard", "scopes": ["read"], "expires_at": "` + time.Now().Add(time.Hour).Format(time.RFC3339) + `"}`)
+	fullKey := create(`{"name": "full"}`)
+
+	if len(fullKey.Scopes) != 2 {
+		t.Errorf("Expected key without scopes to have all scopes, got %v", fullKey.Scopes)
+	}
+
+	invalid := []string{
+		`{"name": "bad", "scopes": ["admin"]}`,
+		`{"name": "old", "expires_at": "2000-01-01T00:00:00Z"}`,
+	}
+	for _, body := range invalid {
+		if rec := do(http.MethodPost, "/api/v1/keys", alice, body); rec.Code != http.StatusUnprocessableEntity {
+			t.Errorf("Expected 422 for %s, got %d", body, rec.Code)
+		}
+	}
+
+	// Ключ хранится только в виде хеша
+	orch.DataBase.mu.Lock()
+	for _, k := range orch.DataBase.APIKeys {
+		if strings.Contains(k.Hash, submitKey.Key) || k.Hash == submitKey.Key {
+			t.Error("Expected API key to be stored hashed")
+		}
+	}
+	orch.DataBase.mu.Unlock()
+
+	bearer := func(key keyResponse) string { return "Bearer " + key.Key }
+	checks := []struct {
+		name          string
+		method        string
+		path          string
+		authorization string
+		body          string
+		status        int
+	}{
+		{"SubmitKeySubmits", http.MethodPost, "/api/v1/calculate", bearer(submitKey), `{"expression": "2+3"}`, http.StatusOK},
+		{"SubmitKeyCannotRead", http.MethodGet, "/api/v1/expressions", bearer(submitKey), "", http.StatusForbidden},
+		{"ReadKeyReads", http.MethodGet, "/api/v1/expressions/:1", bearer(readKey), "", http.StatusOK},
+		{"ReadKeyCannotSubmit", http.MethodPost, "/api/v1/calculate", bearer(readKey), `{"expression": "2+3"}`, http.StatusForbidden},
+		{"FullKeyReads", http.MethodGet, "/api/v1/expressions", bearer(fullKey), "", http.StatusOK},
+		{"KeyCannotManageKeys", http.MethodGet, "/api/v1/keys", bearer(fullKey), "", http.StatusForbidden},
+		{"UnknownKey", http.MethodGet, "/api/v1/expressions", "Bearer calc_unknown", "", http.StatusUnauthorized},
+		{"OtherUserCannotRevoke", http.MethodDelete, "/api/v1/keys/" + strconv.Itoa(int(readKey.ID)), bob, "", http.StatusNotFound},
+		{"RevokeReadKey", http.MethodDelete, "/api/v1/keys/" + strconv.Itoa(int(readKey.ID)), alice, "", http.StatusOK},
+		{"RevokedKey", http.MethodGet, "/api/v1/expressions", bearer(readKey), "", http.StatusUnauthorized},
+	}
+	for _, tt := range checks {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := do(tt.method, tt.path, tt.authorization, tt.body); rec.Code != tt.status {
+				t.Errorf("Expected status %d, got %d: %s", tt.status, rec.Code, rec.Body.String())
+			}
+		})
+	}
+
+	// Ключ с истёкшим сроком действия
+	orch.DataBase.mu.Lock()
+	orch.DataBase.APIKeys[fullKey.ID].ExpiresAt = time.Now().Add(-time.Minute)
+	orch.DataBase.mu.Unlock()
+	if rec := do(http.MethodGet, "/api/v1/expressions", bearer(fullKey), ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected expired key to be rejected, got %d", rec.Code)
+	}
+
+	rec := do(http.MethodGet, "/api/v1/keys", alice, "")
+	var list struct {
+		Keys []keyResponse `json:"keys"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&list); err != nil {
+		t.Fatalf("Failed to decode keys list: %v", err)
+	}
+	if len(list.Keys) != 3 {
+		t.Fatalf("Expected 3 keys, got %d", len(list.Keys))
+	}
+	for _, k := range list.Keys {
+		if k.Key != "" {
+			t.Errorf("Expected key %d secret not to be listed", k.ID)
+		}
+		if k.LastUsedAt == nil {
+			t.Errorf("Expected key %d last used time to be recorded", k.ID)
+		}
+		if (k.ID == readKey.ID) != (k.RevokedAt != nil) {
+			t.Errorf("Unexpected revocation time for key %d: %v", k.ID, k.RevokedAt)
+		}
+	}
+}
+
+func TestAgentAuthentication(t *testing.T) {
+	cfg := &config.Config{
+		Orchestrator: config.OrchestratorConfig{
+			AgentSecret: testAgentSecret,
+		},
+	}
+
+	orch := newTestOrchestrator(t, cfg)
+	internal := orch.internalHandler()
+
+	do := func(internal http.Handler, method, agentID, token string, body []byte) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/internal/task", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(agentIDHeader, agentID)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		internal.ServeHTTP(rec, req)
+		return rec
+	}
+
+	agentToken := func(agentID string) string {
+		return auth.AgentToken(testAgentSecret, agentID)
+	}
+
+	authTests := []struct {
+		name    string
+		agentID string
+		token   string
+		status  int
+	}{
+		{"NoToken", "agent-1", "", http.StatusUnauthorized},
+		{"WrongToken", "agent-1", "wrong", http.StatusUnauthorized},
+		{"RawSecret", "agent-1", testAgentSecret, http.StatusUnauthorized},
+		{"OtherAgentToken", "agent-2", agentToken("agent-1"), http.StatusUnauthorized},
+		{"AgentToken", "agent-1", agentToken("agent-1"), http.StatusNotFound},
+	}
+	for _, tt := range authTests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := do(internal, http.MethodGet, tt.agentID, tt.token, nil); rec.Code != tt.status {
+				t.Errorf("Expected status %d, got %d", tt.status, rec.Code)
+			}
+		})
+	}
+
+	t.Run("TokensWithoutSecretFallback", func(t *testing.T) {
+		orch := newTestOrchestrator(t, &config.Config{
+			Orchestrator: config.OrchestratorConfig{
+				AgentSecret: testAgentSecret,
+				AgentTokens: map[string]string{"agent-2": "agent-2-token"},
+			},
+		})
+		internal := orch.internalHandler()
+
+		if rec := do(internal, http.MethodGet, "agent-2", "agent-2-token", nil); rec.Code != http.StatusNotFound {
+			t.Errorf("Expected status %d for configured token, got %d", http.StatusNotFound, rec.Code)
+		}
+		if rec := do(internal, http.MethodGet, "agent-1", agentToken("agent-1"), nil); rec.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status %d for agent without token, got %d", http.StatusUnauthorized, rec.Code)
+		}
+	})
+
+	t.Run("NoCredentials", func(t *testing.T) {
+		orch := newTestOrchestrator(t, &config.Config{})
+		if rec := do(orch.internalHandler(), http.MethodGet, "agent-1", agentToken("agent-1"), nil); rec.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, rec.Code)
+		}
+		if err := orch.RunOrchestration(context.Background()); !errors.Is(err, errNoAgentCredentials) {
+			t.Errorf("Expected %v, got %v", errNoAgentCredentials, err)
+		}
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/calculate", strings.NewReader(`{"expression": "2+3"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", testToken(t, orch, "alice"))
+	orch.handler().ServeHTTP(httptest.NewRecorder(), req)
+
+	rec := do(internal, http.MethodGet, "agent-1", agentToken("agent-1"), nil)
+	var task models.Task
+	if err := json.NewDecoder(rec.Body).Decode(&task); err != nil {
+		t.Fatalf("Failed to decode task: %v", err)
+	}
+
+	result, _ := json.Marshal(models.TaskResult{ID: task.ID, ExpressionID: task.ExpressionID, Result: 5})
+	resultTests := []struct {
+		name    string
+		agentID string
+		status  int
+	}{
+		{"NotLeaseHolder", "agent-3", http.StatusConflict},
+		{"LeaseHolder", "agent-1", http.StatusOK},
+		{"AlreadyCompleted", "agent-1", http.StatusNotFound},
+	}
+	for _, tt := range resultTests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := do(internal, http.MethodPost, tt.agentID, agentToken(tt.agentID), result); rec.Code != tt.status {
+				t.Errorf("Expected status %d, got %d: %s", tt.status, rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestAgentCertificateIdentity(t *testing.T) {
+	cfg := &config.Config{
+		Orchestrator: config.OrchestratorConfig{
+			AgentSecret: "shared-secret",
+		},
+	}
+
+	orch := newTestOrchestrator(t, cfg)
+	internal := orch.internalHandler()
+
+	verified := &tls.ConnectionState{
+		VerifiedChains: [][]*x509.Certificate{{
+			{Subject: pkix.Name{CommonName: "agent-1"}},
+		}},
+	}
+
+	tests := []struct {
+		name    string
+		state   *tls.ConnectionState
+		agentID string
+		status  int
+	}{
+		{"CertificateWithoutToken", verified, "", http.StatusNotFound},
+		{"CertificateWithMatchingID", verified, "agent-1", http.StatusNotFound},
+		{"CertificateWithOtherID", verified, "agent-2", http.StatusForbidden},
+		{"UnverifiedCertificate", &tls.ConnectionState{}, "agent-1", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/internal/task", nil)
+			req.TLS = tt.state
+			if tt.agentID != "" {
+				req.Header.Set(agentIDHeader, tt.agentID)
+			}
+			rec := httptest.NewRecorder()
+			internal.ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Errorf("Expected status %d, got %d", tt.status, rec.Code)
+			}
+		})
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Unix(0, 0)
+	limiter := newRateLimiter(1, 2)
+	limiter.now = func() time.Time { return now }
+
+	steps := []struct {
+		name      string
+		advance   time.Duration
+		key       string
+		allowed   bool
+		remaining int
+	}{
+		{"First", 0, "alice", true, 1},
+		{"Burst", 0, "alice", true, 0},
+		{"Exhausted", 0, "alice", false, 0},
+		{"OtherKey", 0, "bob", true, 1},
+		{"PartialRefill", 500 * time.Millisecond, "alice", false, 0},
+		{"Refilled", 500 * time.Millisecond, "alice", true, 0},
+	}
+
+	for _, step := range steps {
+		now = now.Add(step.advance)
+		st := limiter.allow(step.key)
+		if st.allowed != step.allowed || st.remaining != step.remaining {
+			t.Errorf("%s: expected allowed=%v remaining=%d, got allowed=%v remaining=%d",
+				step.name, step.allowed, step.remaining, st.allowed, st.remaining)
+		}
+		if !st.allowed && st.retryAfter <= 0 {
+			t.Errorf("%s: expected positive retry after, got %v", step.name, st.retryAfter)
+		}
+	}
+
+	if newRateLimiter(0, 10) != nil {
+		t.Error("Expected no limiter for zero rate")
+	}
+}
+
+func TestQuotas(t *testing.T) {
+	calculate := func(orch *Orchestrator, token, expression string) *httptest.ResponseRecorder {
+		body := fmt.Sprintf(`{"expression": %q}`, expression)
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/calculate", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", token)
+		rec := httptest.NewRecorder()
+		orch.handler().ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("UserRate", func(t *testing.T) {
+		orch := newTestOrchestrator(t, &config.Config{Orchestrator: config.OrchestratorConfig{
+			RateLimitRPS:   0.01,
+			RateLimitBurst: 2,
+		}})
+		alice := testToken(t, orch, "alice")
+		bob := testToken(t, orch, "bob")
+
+		for i, want := range []string{"1", "0"} {
+			rec := calculate(orch, alice, "2+3")
+			if rec.Code != http.StatusOK {
+				t.Fatalf("Request %d: expected status 200, got %d", i, rec.Code)
+			}
+			if got := rec.Header().Get(rateLimitLimitHeader); got != "2" {
+				t.Errorf("Expected %s 2, got %q", rateLimitLimitHeader, got)
+			}
+			if got := rec.Header().Get(rateLimitRemainingHeader); got != want {
+				t.Errorf("Expected %s %s, got %q", rateLimitRemainingHeader, want, got)
+			}
+		}
+
+		rec := calculate(orch, alice, "2+3")
+		if rec.Code != http.StatusTooManyRequests {
+			t.Fatalf("Expected status 429, got %d", rec.Code)
+		}
+		if retry, err := strconv.Atoi(rec.Header().Get("Retry-After")); err != nil || retry < 1 {
+			t.Errorf("Expected positive Retry-After, got %q", rec.Header().Get("Retry-After"))
+		}
+
+		if rec := calculate(orch, bob, "2+3"); rec.Code != http.StatusOK {
+			t.Errorf("Expected other user to be unaffected, got status %d", rec.Code)
+		}
+	})
+
+	t.Run("IPRate", func(t *testing.T) {
+		orch := newTestOrchestrator(t, &config.Config{Orchestrator: config.OrchestratorConfig{
+			IPRateLimitRPS:   0.01,
+			IPRateLimitBurst: 1,
+		}})
+
+		login := func(remoteAddr string) int {
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/login", strings.NewReader(`{"login": "alice", "password": "password"}`))
+			req.Header.Set("Content-Type", "application/json")
+			req.RemoteAddr = remoteAddr
+			rec := httptest.NewRecorder()
+			orch.handler().ServeHTTP(rec, req)
+			return rec.Code
+		}
+
+		if code := login("10.0.0.1:1000"); code == http.StatusTooManyRequests {
+			t.Errorf("Expected first request to pass, got %d", code)
+		}
+		if code := login("10.0.0.1:2000"); code != http.StatusTooManyRequests {
+			t.Errorf("Expected status 429 for same IP, got %d", code)
+		}
+		if code := login("10.0.0.2:1000"); code == http.StatusTooManyRequests {
+			t.Errorf("Expected other IP to pass, got %d", code)
+		}
+	})
+
+	t.Run("ActiveExpressions", func(t *testing.T) {
+		orch := newTestOrchestrator(t, &config.Config{Orchestrator: config.OrchestratorConfig{
+			MaxActiveExpressions: 1,
+		}})
+		alice := testToken(t, orch, "alice")
+
+		rec := calculate(orch, alice, "2+3")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", rec.Code)
+		}
+		if got := rec.Header().Get(activeRemainingHeader); got != "0" {
+			t.Errorf("Expected %s 0, got %q", activeRemainingHeader, got)
+		}
+
+		if rec := calculate(orch, alice, "(2+3"); rec.Code != http.StatusTooManyRequests {
+			t.Fatalf("Expected status 429, got %d", rec.Code)
+		} else if rec.Header().Get("Retry-After") == "" {
+			t.Error("Expected Retry-After header")
+		}
+
+		task, err := orch.nextTask(context.Background(), "agent-1")
+		if err != nil {
+			t.Fatalf("Failed to get task: %v", err)
+		}
+		err = orch.completeTask(context.Background(), "agent-1", models.TaskResult{ID: task.ID, ExpressionID: task.ExpressionID, Result: 5})
+		if err != nil {
+			t.Fatalf("Failed to complete task: %v", err)
+		}
+
+		if rec := calculate(orch, alice, "2+3"); rec.Code != http.StatusOK {
+			t.Errorf("Expected status 200 after expression finished, got %d", rec.Code)
+		}
+	})
+
+	t.Run("ExpressionNodes", func(t *testing.T) {
+		orch := newTestOrchestrator(t, &config.Config{Orchestrator: config.OrchestratorConfig{
+			MaxExpressionNodes:   3,
+			MaxActiveExpressions: 1,
+		}})
+		alice := testToken(t, orch, "alice")
+
+		rec := calculate(orch, alice, "2+3*4")
+		if rec.Code != http.StatusRequestEntityTooLarge {
+			t.Fatalf("Expected status 413, got %d", rec.Code)
+		}
+		if got := rec.Header().Get("Retry-After"); got != "" {
+			t.Errorf("Expected no Retry-After for permanent error, got %q", got)
+		}
+		if got := rec.Header().Get(nodesLimitHeader); got != "3" {
+			t.Errorf("Expected %s 3, got %q", nodesLimitHeader, got)
+		}
+
+		if rec := calculate(orch, alice, "2+3"); rec.Code != http.StatusOK {
+			t.Errorf("Expected rejected expression to release its slot, got status %d", rec.Code)
+		}
+	})
+
+	t.Run("IPQuotas", func(t *testing.T) {
+		orch := newTestOrchestrator(t, &config.Config{Orchestrator: config.OrchestratorConfig{
+			MaxActiveExpressions:   2,
+			IPMaxActiveExpressions: 1,
+			MaxExpressionNodes:     5,
+			IPMaxExpressionNodes:   3,
+		}})
+		alice := testToken(t, orch, "alice")
+		bob := testToken(t, orch, "bob")
+
+		calculateFrom := func(token, remoteAddr, expression string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/calculate", strings.NewReader(`{"expression": "`+expression+`"}`))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Authorization", token)
+			req.RemoteAddr = remoteAddr
+			rec := httptest.NewRecorder()
+			orch.handler().ServeHTTP(rec, req)
+			return rec
+		}
+
+		if rec := calculateFrom(alice, "10.0.0.1:1000", "2+3*4"); rec.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("Expected status 413 for IP node limit, got %d", rec.Code)
+		} else if got := rec.Header().Get(nodesLimitHeader); got != "3" {
+			t.Errorf("Expected %s 3, got %q", nodesLimitHeader, got)
+		}
+
+		rec := calculateFrom(alice, "10.0.0.1:1000", "2+3")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", rec.Code)
+		}
+		if got := rec.Header().Get(activeLimitHeader); got != "1" {
+			t.Errorf("Expected stricter %s 1, got %q", activeLimitHeader, got)
+		}
+		if got := rec.Header().Get(activeRemainingHeader); got != "0" {
+			t.Errorf("Expected %s 0, got %q", activeRemainingHeader, got)
+		}
+
+		if rec := calculateFrom(bob, "10.0.0.1:2000", "2+3"); rec.Code != http.StatusTooManyRequests {
+			t.Errorf("Expected status 429 for same IP, got %d", rec.Code)
+		} else if rec.Header().Get("Retry-After") == "" {
+			t.Error("Expected Retry-After header")
+		}
+		if rec := calculateFrom(alice, "10.0.0.2:1000", "2+3"); rec.Code != http.StatusOK {
+			t.Errorf("Expected other IP to pass, got %d", rec.Code)
+		}
+	})
+}
+
+func TestFairScheduling(t *testing.T) {
+	cfg := &config.Config{
+		Orchestrator: config.OrchestratorConfig{
+			TimeAdditionMS: 100,
+			UserWeights:    map[string]int{"interactive": 2},
+		},
+	}
+
+	orch := newTestOrchestrator(t, cfg)
+	batch := testToken(t, orch, "batch")
+	interactive := testToken(t, orch, "interactive")
+
+	submit := func(token string) int32 {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/calculate", strings.NewReader(`{"expression": "1+2"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", token)
+		rec := httptest.NewRecorder()
+		orch.handler().ServeHTTP(rec, req)
+
+		var resp struct {
+			ID int32 `json:"id"`
+		}
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		return resp.ID
+	}
+
+	// Пакетный пользователь отправляет выражения первым
+	owners := make(map[int32]string)
+	for i := 0; i < 6; i++ {
+		owners[submit(batch)] = "batch"
+	}
+	for i := 0; i < 6; i++ {
+		owners[submit(interactive)] = "interactive"
+	}
+
+	dispatched := make(map[string]int)
+	var order []string
+	for i := 0; i < 6; i++ {
+		task, err := orch.nextTask(context.Background(), "agent-1")
+		if err != nil {
+			t.Fatalf("Failed to get task %d: %v", i, err)
+		}
+		owner := owners[task.ExpressionID]
+		dispatched[owner]++
+		order = append(order, owner)
+	}
+
+	if dispatched["interactive"] != 4 || dispatched["batch"] != 2 {
+		t.Errorf("Expected 4 interactive and 2 batch tasks, got %v (order %v)", dispatched, order)
+	}
+	if order[1] != "interactive" {
+		t.Errorf("Expected interactive task to be dispatched second, got order %v", order)
+	}
+}
+
+func TestParsePriority(t *testing.T) {
+	tests := []struct {
+		name        string
+		raw         string
+		expected    int
+		expectError bool
+	}{
+		{"Missing", "", PriorityNormal, false},
+		{"Null", "null", PriorityNormal, false},
+		{"Low", `"low"`, PriorityLow, false},
+		{"High", `"HIGH"`, PriorityHigh, false},
+		{"Number", "7", 7, false},
+		{"UnknownName", `"urgent"`, 0, true},
+		{"Negative", "-1", 0, true},
+		{"TooHigh", "10", 0, true},
+		{"Fraction", "1.5", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			priority, err := parsePriority(json.RawMessage(tt.raw))
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("Expected error for %q", tt.raw)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if priority != tt.expected {
+				t.Errorf("Expected priority %d, got %d", tt.expected, priority)
+			}
+		})
+	}
+}
+
+func TestPriorityScheduling(t *testing.T) {
+	newExpr := func(orch *Orchestrator, priority int, age time.Duration) *Expression {
+		expr, err := orch.prepareInput("2+3")
+		if err != nil {
+			t.Fatalf("Failed to prepare input: %v", err)
+		}
+		expr.Priority = priority
+		expr.createdAt = time.Now().Add(-age)
+		orch.Queue.Push(expr)
+		return expr
+	}
+
+	tests := []struct {
+		name     string
+		agingMS  int64
+		low      time.Duration // Время ожидания выражения с низким приоритетом
+		expected string
+	}{
+		{"HigherPriorityFirst", 0, time.Hour, "high"},
+		{"FreshLowPriority", 1000, 0, "high"},
+		{"AgedLowPriority", 1000, 3 * time.Second, "low"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			orch := newTestOrchestrator(t, &config.Config{Orchestrator: config.OrchestratorConfig{
+				PriorityAgingMS: tt.agingMS,
+			}})
+
+			exprs := map[int32]string{
+				newExpr(orch, PriorityLow, tt.low).ID: "low",
+				newExpr(orch, PriorityNormal, 0).ID:   "normal",
+				newExpr(orch, PriorityHigh, 0).ID:     "high",
+			}
+
+			if first := orch.Queue.Items()[0]; exprs[first.ID] != tt.expected {
+				t.Errorf("Expected %s expression at queue head, got %s", tt.expected, exprs[first.ID])
+			}
+
+			task, err := orch.nextTask(context.Background(), "agent-1")
+			if err != nil {
+				t.Fatalf("Failed to get task: %v", err)
+			}
+			if got := exprs[task.ExpressionID]; got != tt.expected {
+				t.Errorf("Expected task from %s expression, got %s", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestCriticalPath(t *testing.T) {
+	tests := []struct {
+		name       string
+		config     config.OrchestratorConfig
+		expression string
+		operation  string
+		arg1, arg2 float64
+	}{
+		// Умножение дольше двух сложений, поэтому оно начинается первым
+		{"OperationTimes", config.OrchestratorConfig{TimeAdditionMS: 100, TimeMultiplicationsMS: 1000}, "1+2+3*4", "*", 3, 4},
+		{"AdditionOnLongerPath", config.OrchestratorConfig{TimeAdditionMS: 1000, TimeMultiplicationsMS: 100}, "1+2+3*4", "+", 1, 2},
+		// Без времени операций сравнивается количество операций на пути
+		{"OperationCount", config.OrchestratorConfig{}, "(4+5)+(1+2+3)", "+", 1, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			orch := newTestOrchestrator(t, &config.Config{Orchestrator: tt.config})
+			expr, err := orch.prepareInput(tt.expression)
+			if err != nil {
+				t.Fatalf("Failed to prepare input: %v", err)
+			}
+			orch.Queue.Push(expr)
+
+			task, err := orch.nextTask(context.Background(), "agent-1")
+			if err != nil {
+				t.Fatalf("Failed to get task: %v", err)
+			}
+			if task.Operation != tt.operation || task.Arg1 != tt.arg1 || task.Arg2 != tt.arg2 {
+				t.Errorf("Expected task %v %s %v, got %v %s %v",
+					tt.arg1, tt.operation, tt.arg2, task.Arg1, task.Operation, task.Arg2)
+			}
+		})
+	}
+}
+
+func TestExpectedCompletion(t *testing.T) {
+	cfg := &config.Config{
+		Orchestrator: config.OrchestratorConfig{
+			TimeAdditionMS:        100,
+			TimeMultiplicationsMS: 1000,
+		},
+	}
+
+	orch := newTestOrchestrator(t, cfg)
+	token := testToken(t, orch, "alice")
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/calculate", strings.NewReader(`{"expression": "1+2+3*4"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", token)
+	rec := httptest.NewRecorder()
+	orch.handler().ServeHTTP(rec, req)
+
+	var created struct {
+		ID int32 `json:"id"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&created); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	expr := orch.DataBase.ExpressionList[created.ID]
+
+	now := time.Now()
+	if got := orch.expectedCompletion(expr, now).Sub(now); got != 1100*time.Millisecond {
+		t.Errorf("Expected 1.1s until completion, got %v", got)
+	}
+
+	task, err := orch.nextTask(context.Background(), "agent-1")
+	if err != nil {
+		t.Fatalf("Failed to get task: %v", err)
+	}
+
+	// Умножение выполняется 400 мс из 1000
+	expr.startedAt[task.ID] = now.Add(-400 * time.Millisecond)
+	if got := orch.expectedCompletion(expr, now).Sub(now); got != 700*time.Millisecond {
+		t.Errorf("Expected 0.7s until completion, got %v", got)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/expressions/:"+strconv.Itoa(int(created.ID)), nil)
+	req.Header.Set("Authorization", token)
+	rec = httptest.NewRecorder()
+	orch.handler().ServeHTTP(rec, req)
+
+	var view struct {
+		ExpectedCompletion *time.Time `json:"expected_completion"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&view); err != nil {
+		t.Fatalf("Failed to decode expression: %v", err)
+	}
+	if view.ExpectedCompletion == nil || view.ExpectedCompletion.Before(now) {
+		t.Errorf("Expected expected_completion in the future, got %v", view.ExpectedCompletion)
+	}
+
+	if err := orch.completeTask(context.Background(), "agent-1", models.TaskResult{ID: task.ID, ExpressionID: expr.ID, Result: 12}); err != nil {
+		t.Fatalf("Failed to complete task: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		task, err := orch.nextTask(context.Background(), "agent-1")
+		if err != nil {
+			t.Fatalf("Failed to get task: %v", err)
+		}
+		if err := orch.completeTask(context.Background(), "agent-1", models.TaskResult{ID: task.ID, ExpressionID: expr.ID, Result: task.Arg1 + task.Arg2}); err != nil {
+			t.Fatalf("Failed to complete task: %v", err)
+		}
+	}
+	if at := orch.expectedCompletion(expr, time.Now()); !at.IsZero() {
+		t.Errorf("Expected no estimate for finished expression, got %v", at)
+	}
+}
+
+func TestExpressionDeadlineLimits(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name        string
+		config      config.OrchestratorConfig
+		timeout     string
+		deadline    string
+		expected    time.Duration // От now; 0 - без срока
+		expectError bool
+	}{
+		{"NoDeadline", config.OrchestratorConfig{}, "", "", 0, false},
+		{"Default", config.OrchestratorConfig{DefaultTimeoutMS: 60000}, "", "", time.Minute, false},
+		{"Timeout", config.OrchestratorConfig{DefaultTimeoutMS: 60000}, "30s", "", 30 * time.Second, false},
+		{"Deadline", config.OrchestratorConfig{}, "", "2025-01-01T12:05:00Z", 5 * time.Minute, false},
+		{"AtMax", config.OrchestratorConfig{MaxTimeoutMS: 120000}, "2m", "", 2 * time.Minute, false},
+		{"TimeoutAboveMax", config.OrchestratorConfig{MaxTimeoutMS: 120000}, "1h", "", 0, true},
+		{"DeadlineAboveMax", config.OrchestratorConfig{MaxTimeoutMS: 120000}, "", "2025-01-01T12:05:00Z", 0, true},
+		{"DefaultClampedToMax", config.OrchestratorConfig{DefaultTimeoutMS: 600000, MaxTimeoutMS: 120000}, "", "", 2 * time.Minute, false},
+		{"MaxWithoutRequest", config.OrchestratorConfig{MaxTimeoutMS: 120000}, "", "", 2 * time.Minute, false},
+		{"InvalidTimeout", config.OrchestratorConfig{}, "soon", "", 0, true},
+		{"NegativeTimeout", config.OrchestratorConfig{}, "-5s", "", 0, true},
+		{"PastDeadline", config.OrchestratorConfig{}, "", "2025-01-01T11:00:00Z", 0, true},
+		{"Both", config.OrchestratorConfig{}, "30s", "2025-01-01T12:05:00Z", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			orch := newTestOrchestrator(t, &config.Config{Orchestrator: tt.config})
+			deadline, err := orch.expressionDeadline(now, tt.timeout, tt.deadline)
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("Expected error, got deadline %v", deadline)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if tt.expected == 0 {
+				if !deadline.IsZero() {
+					t.Errorf("Expected no deadline, got %v", deadline)
+				}
+				return
+			}
+			if got := deadline.Sub(now); got != tt.expected {
+				t.Errorf("Expected deadline in %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestExpressionDeadlineAboveMax(t *testing.T) {
+	orch := newTestOrchestrator(t, &config.Config{Orchestrator: config.OrchestratorConfig{MaxTimeoutMS: 60000}})
+	token := testToken(t, orch, "alice")
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/calculate", strings.NewReader(`{"expression": "1+2", "timeout": "5m"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", token)
+	rec := httptest.NewRecorder()
+	orch.handler().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("Expected status 422, got %d", rec.Code)
+	}
+	if body := rec.Body.String(); !strings.Contains(body, "exceeds maximum of 1m0s") {
+		t.Errorf("Expected max timeout in response, got %q", body)
+	}
+	if n := len(orch.DataBase.ExpressionList); n != 0 {
+		t.Errorf("Expected rejected expression not to be stored, got %d", n)
+	}
+}
+
+func TestExpressionTimeout(t *testing.T) {
+	cfg := &config.Config{
+		Orchestrator: config.OrchestratorConfig{
+			MaxActiveExpressions: 1,
+		},
+	}
+
+	orch := newTestOrchestrator(t, cfg)
+	token := testToken(t, orch, "alice")
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/calculate", strings.NewReader(`{"expression": "1+2+3", "timeout": "200ms"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", token)
+	rec := httptest.NewRecorder()
+	orch.handler().ServeHTTP(rec, req)
+
+	var created struct {
+		ID       int32      `json:"id"`
+		Deadline *time.Time `json:"deadline"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&created); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if created.Deadline == nil {
+		t.Fatal("Expected deadline in response")
+	}
+
+	task, err := orch.nextTask(context.Background(), "agent-1")
+	if err != nil {
+		t.Fatalf("Failed to get task: %v", err)
+	}
+	if err := orch.completeTask(context.Background(), "agent-1", models.TaskResult{ID: task.ID, ExpressionID: created.ID, Result: 3}); err != nil {
+		t.Fatalf("Failed to complete task: %v", err)
+	}
+	task, err = orch.nextTask(context.Background(), "agent-1")
+	if err != nil {
+		t.Fatalf("Failed to get task: %v", err)
+	}
+
+	get := func() (status ExpressionStatus, progress progressView) {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/expressions/:"+strconv.Itoa(int(created.ID)), nil)
+		req.Header.Set("Authorization", token)
+		rec := httptest.NewRecorder()
+		orch.handler().ServeHTTP(rec, req)
+
+		var view struct {
+			Status   ExpressionStatus `json:"status"`
+			Progress progressView     `json:"progress"`
+		}
+		if err := json.NewDecoder(rec.Body).Decode(&view); err != nil {
+			t.Fatalf("Failed to decode expression: %v", err)
+		}
+		return view.Status, view.Progress
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	status, progress := get()
+	for status != StatusTimedOut && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+		status, progress = get()
+	}
+
+	if status != StatusTimedOut {
+		t.Fatalf("Expected status %s, got %s", StatusTimedOut, status)
+	}
+	if progress != (progressView{CompletedTasks: 1, TotalTasks: 2}) {
+		t.Errorf("Expected 1 of 2 tasks completed, got %+v", progress)
+	}
+
+	err = orch.completeTask(context.Background(), "agent-1", models.TaskResult{ID: task.ID, ExpressionID: created.ID, Result: 6})
+	if err != errLeaseNotFound {
+		t.Errorf("Expected late result to be rejected with %v, got %v", errLeaseNotFound, err)
+	}
+	if _, err := orch.nextTask(context.Background(), "agent-1"); err != errQueueEmpty {
+		t.Errorf("Expected empty queue after timeout, got %v", err)
+	}
+
+	// Истёкшее выражение не занимает место в лимите активных выражений
+	req = httptest.NewRequest(http.MethodPost, "/api/v1/calculate", strings.NewReader(`{"expression": "1+2"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", token)
+	rec = httptest.NewRecorder()
+	orch.handler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", rec.Code)
+	}
+}
+
+func TestCancelExpression(t *testing.T) {
+	cfg := &config.Config{
+		Orchestrator: config.OrchestratorConfig{
+			MaxActiveExpressions: 1,
+		},
+	}
+
+	orch := newTestOrchestrator(t, cfg)
+	handler := orch.handler()
+	token := testToken(t, orch, "alice")
+
+	do := func(method, path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(`{"expression": "1+2+3"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", token)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	do(http.MethodPost, "/api/v1/calculate", token)
+	task, err := orch.nextTask(context.Background(), "agent-1")
+	if err != nil {
+		t.Fatalf("Failed to get task: %v", err)
+	}
+
+	if rec := do(http.MethodPost, "/api/v1/expressions/:1/cancel", testToken(t, orch, "bob")); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d for expression of another user, got %d", http.StatusNotFound, rec.Code)
+	}
+	if rec := do(http.MethodPost, "/api/v1/expressions/:1/cancel", token); rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	var view struct {
+		Status ExpressionStatus `json:"status"`
+	}
+	if err := json.NewDecoder(do(http.MethodGet, "/api/v1/expressions/:1", token).Body).Decode(&view); err != nil {
+		t.Fatalf("Failed to decode expression: %v", err)
+	}
+	if view.Status != StatusCancelled {
+		t.Errorf("Expected status %s, got %s", StatusCancelled, view.Status)
+	}
+
+	err = orch.completeTask(context.Background(), "agent-1", models.TaskResult{ID: task.ID, ExpressionID: 1, Result: 3})
+	if err != errLeaseNotFound {
+		t.Errorf("Expected result of cancelled expression to be rejected with %v, got %v", errLeaseNotFound, err)
+	}
+	if rec := do(http.MethodPost, "/api/v1/expressions/:1/cancel", token); rec.Code != http.StatusConflict {
+		t.Errorf("Expected status %d for finished expression, got %d", http.StatusConflict, rec.Code)
+	}
+
+	// Отменённое выражение не занимает место в лимите активных выражений, а удалённое не находится
+	if rec := do(http.MethodPost, "/api/v1/calculate", token); rec.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", rec.Code)
+	}
+	if rec := do(http.MethodDelete, "/api/v1/expressions/:2", token); rec.Code != http.StatusNoContent {
+		t.Errorf("Expected status %d, got %d", http.StatusNoContent, rec.Code)
+	}
+	if rec := do(http.MethodGet, "/api/v1/expressions/:2", token); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d for deleted expression, got %d", http.StatusNotFound, rec.Code)
+	}
+	if _, err := orch.nextTask(context.Background(), "agent-1"); err != errQueueEmpty {
+		t.Errorf("Expected empty queue after delete, got %v", err)
+	}
+	if rec := do(http.MethodPost, "/api/v1/calculate", token); rec.Code != http.StatusOK {
+		t.Errorf("Expected status 200 after delete, got %d", rec.Code)
+	}
+}
+
+func TestTaskRetries(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxRetries int
+		outcomes   []models.ErrorClass // Результаты попыток на agent-1, agent-2, ...: пусто - успех
+		status     ExpressionStatus
+		result     float64
+	}{
+		{"RetriedOnAnotherAgent", 2, []models.ErrorClass{models.ErrorTransient, ""}, StatusDone, 6},
+		{"RetriesExhausted", 1, []models.ErrorClass{models.ErrorTransient, models.ErrorTransient}, StatusError, 0},
+		{"NoRetries", 0, []models.ErrorClass{models.ErrorTransient}, StatusError, 0},
+		{"MathErrorNotRetried", 2, []models.ErrorClass{models.ErrorMath}, StatusError, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			orch := newTestOrchestrator(t, &config.Config{Orchestrator: config.OrchestratorConfig{
+				MaxTaskRetries: tt.maxRetries,
+			}})
+
+			expr, err := orch.prepareInput("2*3")
+			if err != nil {
+				t.Fatalf("Failed to prepare input: %v", err)
+			}
+			orch.DataBase.ExpressionList[expr.ID] = expr
+			orch.Queue.Push(expr)
+
+			// Все агенты работают с самого начала, поэтому повтор может достаться другому агенту
+			orch.mu.Lock()
+			for i := range tt.outcomes {
+				orch.touchAgent(fmt.Sprintf("agent-%d", i+1), time.Now())
+			}
+			orch.mu.Unlock()
+
+			for i, class := range tt.outcomes {
+				agentID := fmt.Sprintf("agent-%d", i+1)
+
+				// Повтор не выдаётся агентам, на которых задача уже завершилась сбоем
+				for j := 0; j < i; j++ {
+					if _, err := orch.nextTask(context.Background(), fmt.Sprintf("agent-%d", j+1)); err != errNoReadyTasks {
+						t.Errorf("Expected agent-%d to get no tasks, got %v", j+1, err)
+					}
+				}
+
+				task, err := orch.nextTask(context.Background(), agentID)
+				if err != nil {
+					t.Fatalf("Failed to get task for %s: %v", agentID, err)
+				}
+
+				res := models.TaskResult{ID: task.ID, ExpressionID: expr.ID, Result: task.Arg1 * task.Arg2}
+				if class != "" {
+					res.Result = 0
+					res.Error = "failed"
+					res.ErrorClass = class
+				}
+				if err := orch.completeTask(context.Background(), agentID, res); err != nil {
+					t.Fatalf("Failed to complete task: %v", err)
+				}
+			}
+
+			expr.mu.Lock()
+			defer expr.mu.Unlock()
+			if expr.Status != tt.status {
+				t.Errorf("Expected status %s, got %s", tt.status, expr.Status)
+			}
+			if expr.Result != tt.result {
+				t.Errorf("Expected result %v, got %v", tt.result, expr.Result)
+			}
+			if expr.Status == StatusError && orch.Queue.Len() != 0 {
+				t.Error("Expected failed expression to be removed from queue")
+			}
+		})
+	}
+}
+
+func TestSpeculativeExecution(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+		winner     string // Агент, первым приславший результат; пусто - копия не выдаётся
+	}{
+		{"CopyWins", "2*3", "agent-2"},
+		{"OriginalWins", "2*3", "agent-1"},
+		{"OperatorWithoutFactor", "2+3", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			orch := newTestOrchestrator(t, &config.Config{Orchestrator: config.OrchestratorConfig{
+				TimeAdditionMS:        10,
+				TimeMultiplicationsMS: 10,
+				SpeculationFactors:    map[string]float64{"*": 2},
+			}})
+
+			expr, err := orch.prepareInput(tt.expression)
+			if err != nil {
+				t.Fatalf("Failed to prepare input: %v", err)
+			}
+			orch.DataBase.ExpressionList[expr.ID] = expr
+			orch.Queue.Push(expr)
+
+			task, err := orch.nextTask(context.Background(), "agent-1")
+			if err != nil {
+				t.Fatalf("Failed to get task: %v", err)
+			}
+			if _, err := orch.nextTask(context.Background(), "agent-2"); err != errNoReadyTasks {
+				t.Errorf("Expected no copy before the task is late, got %v", err)
+			}
+
+			time.Sleep(30 * time.Millisecond)
+
+			if _, err := orch.nextTask(context.Background(), "agent-1"); err != errNoReadyTasks {
+				t.Errorf("Expected no copy for the agent holding the task, got %v", err)
+			}
+
+			copied, err := orch.nextTask(context.Background(), "agent-2")
+			if tt.winner == "" {
+				if err != errNoReadyTasks {
+					t.Errorf("Expected no copy, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Failed to get task copy: %v", err)
+			}
+			if copied.ID != task.ID || copied.Arg1 != task.Arg1 || copied.Arg2 != task.Arg2 {
+				t.Errorf("Expected copy of task %+v, got %+v", task, copied)
+			}
+			if _, err := orch.nextTask(context.Background(), "agent-3"); err != errNoReadyTasks {
+				t.Errorf("Expected a single copy per task, got %v", err)
+			}
+
+			loser := "agent-1"
+			if tt.winner == loser {
+				loser = "agent-2"
+			}
+
+			res := models.TaskResult{ID: task.ID, ExpressionID: expr.ID, Result: 6}
+			if err := orch.completeTask(context.Background(), tt.winner, res); err != nil {
+				t.Fatalf("Failed to complete task: %v", err)
+			}
+			if err := orch.completeTask(context.Background(), loser, res); err != errLeaseNotFound {
+				t.Errorf("Expected discarded result to be rejected with %v, got %v", errLeaseNotFound, err)
+			}
+
+			expr.mu.Lock()
+			defer expr.mu.Unlock()
+			if expr.Status != StatusDone || expr.Result != 6 {
+				t.Errorf("Expected done expression with result 6, got %s %v", expr.Status, expr.Result)
+			}
+			if len(orch.leases) != 0 {
+				t.Errorf("Expected no leases left, got %d", len(orch.leases))
+			}
+		})
+	}
+}
+
+func TestBackpressure(t *testing.T) {
+	cfg := &config.Config{
+		Orchestrator: config.OrchestratorConfig{
+			MaxQueuedExpressions: 2,
+			MaxPendingNodes:      3,
+		},
+	}
+
+	orch := newTestOrchestrator(t, cfg)
+	token := testToken(t, orch, "alice")
+
+	submit := func(expression string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/calculate", strings.NewReader(`{"expression": "`+expression+`"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", token)
+		rec := httptest.NewRecorder()
+		orch.handler().ServeHTTP(rec, req)
+		return rec
+	}
+
+	steps := []struct {
+		expression string
+		complete   bool // Перед отправкой вычислить первое выражение в очереди
+		code       int
+	}{
+		{expression: "1+2", code: http.StatusOK},
+		{expression: "1+2+3", code: http.StatusOK},
+		{expression: "4+5", code: http.StatusServiceUnavailable},                   // Два выражения уже в очереди
+		{expression: "4*5+6", complete: true, code: http.StatusServiceUnavailable}, // Четыре операции в очереди
+		{expression: "4+5", code: http.StatusOK},
+	}
+
+	for i, step := range steps {
+		if step.complete {
+			task, err := orch.nextTask(context.Background(), "agent-1")
+			if err != nil {
+				t.Fatalf("Failed to get task: %v", err)
+			}
+			res := models.TaskResult{ID: task.ID, ExpressionID: task.ExpressionID, Result: task.Arg1 + task.Arg2}
+			if err := orch.completeTask(context.Background(), "agent-1", res); err != nil {
+				t.Fatalf("Failed to complete task: %v", err)
+			}
+		}
+
+		rec := submit(step.expression)
+		if rec.Code != step.code {
+			t.Fatalf("Step %d: expected status %d, got %d", i, step.code, rec.Code)
+		}
+		if rec.Code == http.StatusServiceUnavailable && rec.Header().Get("Retry-After") == "" {
+			t.Errorf("Step %d: expected Retry-After header", i)
+		}
+	}
+
+	// Отклонённые выражения не остаются ни в базе, ни в списке выражений
+	accepted := 0
+	for _, step := range steps {
+		if step.code == http.StatusOK {
+			accepted++
+		}
+	}
+	orch.DataBase.mu.Lock()
+	stored := len(orch.DataBase.ExpressionList)
+	orch.DataBase.mu.Unlock()
+	if stored != accepted {
+		t.Errorf("Expected %d expressions in database, got %d", accepted, stored)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/expressions", nil)
+	req.Header.Set("Authorization", token)
+	rec := httptest.NewRecorder()
+	orch.handler().ServeHTTP(rec, req)
+	var list struct {
+		Expressions []struct {
+			ID int32 `json:"id"`
+		} `json:"expressions"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&list); err != nil {
+		t.Fatalf("Failed to decode expressions: %v", err)
+	}
+	if len(list.Expressions) != accepted {
+		t.Errorf("Expected %d expressions in list, got %d", accepted, len(list.Expressions))
+	}
+
+	rec = httptest.NewRecorder()
+	orch.handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := rec.Body.String()
+
+	expected := []string{
+		"orchestrator_pending_nodes 3",
+		`orchestrator_requests_limited_total{limit="queued_expressions"} 1`,
+		`orchestrator_requests_limited_total{limit="pending_nodes"} 1`,
+	}
+	for _, line := range expected {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("Expected line %q in metrics:\n%s", line, body)
+		}
+	}
+}
+
+func TestTaskRetrySingleAgent(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxRetries int
+		failures   int // Временных сбоев перед успешным вычислением
+		status     ExpressionStatus
+		retried    int
+		failed     int // Окончательных ошибок: повторы в них не учитываются
+	}{
+		{"RetriedOnSameAgent", 2, 2, StatusDone, 2, 0},
+		{"RetriesExhausted", 1, 2, StatusError, 1, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			orch := newTestOrchestrator(t, &config.Config{Orchestrator: config.OrchestratorConfig{
+				MaxTaskRetries: tt.maxRetries,
+			}})
+
+			expr, err := orch.prepareInput("2*3")
+			if err != nil {
+				t.Fatalf("Failed to prepare input: %v", err)
+			}
+			orch.Queue.Push(expr)
+
+			// Других агентов нет, поэтому задача возвращается агенту, на котором она сбоила
+			for i := 0; i <= tt.failures; i++ {
+				task, err := orch.nextTask(context.Background(), "agent-1")
+				if err != nil {
+					t.Fatalf("Attempt %d: failed to get task: %v", i+1, err)
+				}
+
+				res := models.TaskResult{ID: task.ID, ExpressionID: expr.ID, Result: 6}
+				if i < tt.failures {
+					res = models.TaskResult{ID: task.ID, ExpressionID: expr.ID, Error: "failed", ErrorClass: models.ErrorTransient}
+				}
+				if err := orch.completeTask(context.Background(), "agent-1", res); err != nil {
+					t.Fatalf("Attempt %d: failed to complete task: %v", i+1, err)
+				}
+
+				expr.mu.Lock()
+				status := expr.Status
+				expr.mu.Unlock()
+				if status == StatusError {
+					break
+				}
+			}
+
+			expr.mu.Lock()
+			if expr.Status != tt.status {
+				t.Errorf("Expected status %s, got %s", tt.status, expr.Status)
+			}
+			expr.mu.Unlock()
+
+			rec := httptest.NewRecorder()
+			orch.handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+			body := rec.Body.String()
+
+			for name, count := range map[string]int{"retried": tt.retried, "failed": tt.failed} {
+				line := fmt.Sprintf(`orchestrator_tasks_%s_total{operator="*"} %d`, name, count)
+				if count == 0 {
+					line = fmt.Sprintf(`orchestrator_tasks_%s_total{operator="*"}`, name)
+					if strings.Contains(body, line) {
+						t.Errorf("Expected no %q in metrics:\n%s", line, body)
+					}
+					continue
+				}
+				if !strings.Contains(body, line+"\n") {
+					t.Errorf("Expected line %q in metrics:\n%s", line, body)
+				}
+			}
+		})
+	}
+}
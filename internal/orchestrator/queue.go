@@ -0,0 +1,112 @@
+package orchestrator
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Приоритеты выражений. Кроме названий принимаются целые числа от 0 до MaxPriority
+const (
+	PriorityLow    = 0
+	PriorityNormal = 1
+	PriorityHigh   = 2
+	MaxPriority    = 9
+)
+
+var errInvalidPriority = errors.New("invalid priority")
+
+// Очередь выражений, упорядоченная по приоритету с учётом старения: каждые aging ожидания
+// повышают приоритет выражения на единицу, поэтому выражения с низким приоритетом
+// не ждут бесконечно. Выражения сравниваются по моменту отправки, сдвинутому на
+// приоритет * aging назад, - этот порядок не меняется со временем
+type ExpressionQueue struct {
+	items []*Expression
+	aging time.Duration
+}
+
+// Создание очереди со старением aging (0 - без старения)
+func NewExpressionQueue(aging time.Duration) *ExpressionQueue {
+	return &ExpressionQueue{aging: aging}
+}
+
+// Добавление выражения после всех выражений, которые должны обрабатываться раньше него
+func (q *ExpressionQueue) Push(expr *Expression) {
+	i := sort.Search(len(q.items), func(i int) bool {
+		return q.before(expr, q.items[i])
+	})
+	q.items = append(q.items, nil)
+	copy(q.items[i+1:], q.items[i:])
+	q.items[i] = expr
+}
+
+// Удаление выражения из очереди
+func (q *ExpressionQueue) Remove(expr *Expression) bool {
+	for i, e := range q.items {
+		if e == expr {
+			q.items = append(q.items[:i], q.items[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (q *ExpressionQueue) Len() int {
+	return len(q.items)
+}
+
+// Выражения в порядке обработки. Срез принадлежит очереди и не должен изменяться
+func (q *ExpressionQueue) Items() []*Expression {
+	return q.items
+}
+
+// Приоритет выражения с учётом времени ожидания
+func (q *ExpressionQueue) effectivePriority(expr *Expression, now time.Time) int {
+	if q.aging <= 0 {
+		return expr.Priority
+	}
+	return expr.Priority + int(now.Sub(expr.createdAt)/q.aging)
+}
+
+// Должно ли выражение a обрабатываться раньше b
+func (q *ExpressionQueue) before(a, b *Expression) bool {
+	if q.aging <= 0 {
+		if a.Priority != b.Priority {
+			return a.Priority > b.Priority
+		}
+		return a.createdAt.Before(b.createdAt)
+	}
+
+	ka := a.createdAt.Add(-time.Duration(a.Priority) * q.aging)
+	kb := b.createdAt.Add(-time.Duration(b.Priority) * q.aging)
+	return ka.Before(kb)
+}
+
+// Разбор приоритета из запроса: low, normal, high или число от 0 до MaxPriority.
+// Без приоритета выражение получает PriorityNormal
+func parsePriority(raw json.RawMessage) (int, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return PriorityNormal, nil
+	}
+
+	var name string
+	if err := json.Unmarshal(raw, &name); err == nil {
+		switch strings.ToLower(name) {
+		case "low":
+			return PriorityLow, nil
+		case "normal":
+			return PriorityNormal, nil
+		case "high":
+			return PriorityHigh, nil
+		}
+		return 0, errInvalidPriority
+	}
+
+	var value int
+	if err := json.Unmarshal(raw, &value); err != nil || value < 0 || value > MaxPriority {
+		return 0, errInvalidPriority
+	}
+	return value, nil
+}
@@ -0,0 +1,269 @@
+package orchestrator
+
+import (
+	"errors"
+	"final3/internal/logger"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	rateLimitLimitHeader     = "X-RateLimit-Limit"     // Размер корзины токенов
+	rateLimitRemainingHeader = "X-RateLimit-Remaining" // Доступно запросов прямо сейчас
+	rateLimitResetHeader     = "X-RateLimit-Reset"     // Секунд до полного восстановления корзины
+
+	activeLimitHeader     = "X-Quota-Active-Limit"     // Максимум одновременно вычисляемых выражений пользователя
+	activeRemainingHeader = "X-Quota-Active-Remaining" // Сколько выражений ещё можно отправить
+	nodesLimitHeader      = "X-Quota-Nodes-Limit"      // Максимум узлов в выражении
+
+	activeRetryAfter  = time.Second     // Retry-After при превышении числа активных выражений
+	limiterSweepEvery = 1 * time.Minute // Период удаления неиспользуемых корзин
+)
+
+// Причины отказа в обработке запроса
+const (
+	limitUserRate            = "user_rate"
+	limitIPRate              = "ip_rate"
+	limitActiveExpressions   = "active_expressions"
+	limitIPActiveExpressions = "ip_active_expressions"
+	limitExpressionNodes     = "expression_nodes"
+)
+
+var errTooManyNodes = errors.New("expression has too many nodes")
+
+// Состояние корзины после запроса
+type rateStatus struct {
+	allowed    bool
+	limit      int
+	remaining  int
+	reset      time.Duration // Время до полного восстановления корзины
+	retryAfter time.Duration // Время до появления токена, если запрос отклонён
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Ограничение частоты запросов корзиной токенов вместимостью burst на каждый ключ
+type rateLimiter struct {
+	rate  float64
+	burst float64
+	now   func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+// Ограничитель rps запросов в секунду со всплеском burst; nil при rps <= 0
+func newRateLimiter(rps float64, burst int) *rateLimiter {
+	if rps <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = int(math.Max(1, math.Ceil(rps)))
+	}
+	return &rateLimiter{
+		rate:    rps,
+		burst:   float64(burst),
+		now:     time.Now,
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// Списание токена из корзины key
+func (l *rateLimiter) allow(key string) rateStatus {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	st := rateStatus{limit: int(l.burst)}
+	if b.tokens >= 1 {
+		b.tokens--
+		st.allowed = true
+	} else {
+		st.retryAfter = l.refill(1 - b.tokens)
+	}
+	st.remaining = int(b.tokens)
+	st.reset = l.refill(l.burst - b.tokens)
+	return st
+}
+
+// Время, за которое в корзину поступит tokens токенов
+func (l *rateLimiter) refill(tokens float64) time.Duration {
+	return time.Duration(tokens / l.rate * float64(time.Second))
+}
+
+// Удаление корзин, которые успели заполниться: они не отличаются от новых (вызывается под l.mu)
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < limiterSweepEvery {
+		return
+	}
+	l.lastSweep = now
+
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// Ограничение частоты запросов с одного IP-адреса
+func (o *Orchestrator) limitIP(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if o.ipLimiter == nil {
+			next(w, r)
+			return
+		}
+
+		ip := clientIP(r)
+		if !o.checkRate(w, r, o.ipLimiter.allow(ip), limitIPRate, "ip", ip) {
+			return
+		}
+		next(w, r)
+	}
+}
+
+// Ограничение частоты запросов пользователя (после requireUser)
+func (o *Orchestrator) limitUser(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := userFromContext(r.Context())
+		if o.userLimiter == nil || user == nil {
+			next(w, r)
+			return
+		}
+
+		key := strconv.Itoa(int(user.ID))
+		if !o.checkRate(w, r, o.userLimiter.allow(key), limitUserRate, "user", user.Login) {
+			return
+		}
+		next(w, r)
+	}
+}
+
+// Заголовки самого строгого ограничителя и ответ 429, если запрос отклонён
+func (o *Orchestrator) checkRate(w http.ResponseWriter, r *http.Request, st rateStatus, limit, keyName, key string) bool {
+	header := w.Header()
+	if prev, err := strconv.Atoi(header.Get(rateLimitRemainingHeader)); err != nil || st.remaining <= prev || !st.allowed {
+		header.Set(rateLimitLimitHeader, strconv.Itoa(st.limit))
+		header.Set(rateLimitRemainingHeader, strconv.Itoa(st.remaining))
+		header.Set(rateLimitResetHeader, strconv.Itoa(int(math.Ceil(st.reset.Seconds()))))
+	}
+
+	if st.allowed {
+		return true
+	}
+
+	logger.WarnContext(r.Context(), "Request rate limit exceeded",
+		"limit", limit,
+		keyName, key,
+		"retry_after_ms", st.retryAfter.Milliseconds())
+	o.rejectLimited(w, limit, st.retryAfter, "Too many requests")
+	return false
+}
+
+// Ответ 429 с Retry-After
+func (o *Orchestrator) rejectLimited(w http.ResponseWriter, limit string, retryAfter time.Duration, message string) {
+	o.metrics.requestsLimited.WithLabelValues(limit).Inc()
+	w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(retryAfter)))
+	http.Error(w, message, http.StatusTooManyRequests)
+}
+
+// Состояние лимитов активных выражений после резервирования
+type activeStatus struct {
+	allowed   bool
+	limit     int    // Самый строгий из лимитов (0 - без ограничения)
+	remaining int    // Сколько выражений ещё можно отправить по этому лимиту
+	exceeded  string // Исчерпанный лимит, если место не зарезервировано
+}
+
+// Резервирование места под новое выражение пользователя userID с адреса ip
+func (o *Orchestrator) reserveActive(userID int32, ip string) activeStatus {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	limits := []struct {
+		name  string
+		max   int
+		count int
+	}{
+		{limitActiveExpressions, o.Config.MaxActiveExpressions, o.active[userID]},
+		{limitIPActiveExpressions, o.Config.IPMaxActiveExpressions, o.activeIP[ip]},
+	}
+
+	st := activeStatus{allowed: true}
+	for _, l := range limits {
+		if l.max <= 0 {
+			continue
+		}
+		if l.count >= l.max {
+			return activeStatus{limit: l.max, exceeded: l.name}
+		}
+		if st.limit == 0 || l.max-l.count-1 < st.remaining {
+			st.limit, st.remaining = l.max, l.max-l.count-1
+		}
+	}
+
+	o.active[userID]++
+	o.activeIP[ip]++
+	return st
+}
+
+// Освобождение места выражения пользователя userID с адреса ip (вызывается под o.mu)
+func (o *Orchestrator) releaseActiveLocked(userID int32, ip string) {
+	if o.active[userID] <= 1 {
+		delete(o.active, userID)
+	} else {
+		o.active[userID]--
+	}
+	if o.activeIP[ip] <= 1 {
+		delete(o.activeIP, ip)
+	} else {
+		o.activeIP[ip]--
+	}
+}
+
+func (o *Orchestrator) releaseActive(userID int32, ip string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.releaseActiveLocked(userID, ip)
+}
+
+// Наибольшее число узлов в выражении: самый строгий из лимитов пользователя и IP-адреса (0 - без ограничения)
+func (o *Orchestrator) maxExpressionNodes() int {
+	max := o.Config.MaxExpressionNodes
+	if ipMax := o.Config.IPMaxExpressionNodes; ipMax > 0 && (max <= 0 || ipMax < max) {
+		max = ipMax
+	}
+	return max
+}
+
+// IP-адрес клиента без порта
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Округление длительности вверх до целых секунд (не меньше одной) для Retry-After
+func ceilSeconds(d time.Duration) int {
+	return int(math.Max(1, math.Ceil(d.Seconds())))
+}
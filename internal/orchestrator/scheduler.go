@@ -0,0 +1,289 @@
+package orchestrator
+
+import (
+	"final3/internal/models"
+	"slices"
+	"sort"
+	"time"
+)
+
+// Агент, который дольше этого времени не запрашивал задачи и не держит выданных задач,
+// считается отключённым
+const agentActiveWindow = 10 * time.Second
+
+// Доля владельца в справедливом распределении задач между пользователями.
+// Каждая выданная задача увеличивает виртуальное время владельца на время операции,
+// делённое на его вес, и следующая задача достаётся владельцу с наименьшим
+// виртуальным временем. Так пользователи с готовыми задачами получают агентов
+// пропорционально весам, независимо от размера их выражений
+type ownerShare struct {
+	vtime float64
+}
+
+// Выбор выражения, задача которого будет выдана агенту agentID следующей (вызывается под o.mu).
+// Рассматриваются выражения с готовыми для агента задачами и наибольшим приоритетом с учётом старения.
+// Из них у каждого владельца берётся первое в очереди, из владельцев - с наименьшим
+// виртуальным временем
+func (o *Orchestrator) selectExpression(agentID string) *Expression {
+	now := time.Now()
+	topPriority := -1
+	var candidates []*Expression
+
+	for _, expr := range o.Queue.Items() {
+		// Задачи выражения с истёкшим сроком не выдаются, даже если таймер ещё не сработал
+		if expr.expired(now) {
+			continue
+		}
+
+		expr.mu.Lock()
+		ready := o.hasReadyTask(expr, agentID)
+		expr.mu.Unlock()
+		if !ready {
+			continue
+		}
+
+		priority := o.Queue.effectivePriority(expr, now)
+		if priority > topPriority {
+			topPriority = priority
+			candidates = candidates[:0]
+		}
+		if priority == topPriority {
+			candidates = append(candidates, expr)
+		}
+	}
+
+	var (
+		best      *Expression
+		bestShare *ownerShare
+		seen      = make(map[int32]bool)
+	)
+
+	for _, expr := range candidates {
+		if seen[expr.OwnerID] {
+			continue
+		}
+		seen[expr.OwnerID] = true
+
+		share := o.ownerShare(expr.OwnerID)
+		if best == nil || share.vtime < bestShare.vtime {
+			best, bestShare = expr, share
+		}
+	}
+
+	if best != nil {
+		o.virtualTime = bestShare.vtime
+	}
+	return best
+}
+
+// Доля владельца ownerID (вызывается под o.mu). Владелец, у которого не было готовых задач,
+// не копит преимущество: его виртуальное время подтягивается к текущему
+func (o *Orchestrator) ownerShare(ownerID int32) *ownerShare {
+	share, ok := o.shares[ownerID]
+	if !ok {
+		share = &ownerShare{}
+		o.shares[ownerID] = share
+	}
+	if share.vtime < o.virtualTime {
+		share.vtime = o.virtualTime
+	}
+	return share
+}
+
+// Учёт выданной задачи с операцией длительностью cost (вызывается под o.mu и expr.mu)
+func (o *Orchestrator) chargeOwner(expr *Expression, cost time.Duration) {
+	weight := expr.weight
+	if weight <= 0 {
+		weight = 1
+	}
+
+	// Нулевое время операции всё равно учитывается, иначе очередь не продвигалась бы
+	ms := float64(cost) / float64(time.Millisecond)
+	if ms < 1 {
+		ms = 1
+	}
+	o.ownerShare(expr.OwnerID).vtime += ms / float64(weight)
+}
+
+// Удаление доли владельца, у которого не осталось выражений в очереди (вызывается под o.mu)
+func (o *Orchestrator) forgetOwner(ownerID int32) {
+	for _, expr := range o.Queue.Items() {
+		if expr.OwnerID == ownerID {
+			return
+		}
+	}
+	delete(o.shares, ownerID)
+}
+
+// Вес пользователя в распределении задач
+func (o *Orchestrator) userWeight(login string) int {
+	if weight, ok := o.Config.UserWeights[login]; ok && weight > 0 {
+		return weight
+	}
+	return 1
+}
+
+// Есть ли у выражения задача, готовая к выдаче агенту agentID (вызывается под o.mu и expr.mu)
+func (o *Orchestrator) hasReadyTask(expr *Expression, agentID string) bool {
+	for id, node := range expr.IdMap {
+		if isReadyTask(node) && !o.avoidAgent(expr, id, agentID) {
+			return true
+		}
+	}
+	return false
+}
+
+// Нужно ли придержать задачу id для других агентов (вызывается под o.mu и expr.mu).
+// Агенту, на котором задача уже завершилась временным сбоем, она выдаётся снова,
+// только если не осталось других работающих агентов, - иначе задача ждала бы бесконечно
+func (o *Orchestrator) avoidAgent(expr *Expression, id int, agentID string) bool {
+	failed := expr.failedAgents[id]
+	if !slices.Contains(failed, agentID) {
+		return false
+	}
+	for _, other := range o.liveAgents() {
+		if !slices.Contains(failed, other) {
+			return true
+		}
+	}
+	return false
+}
+
+// Учёт запроса задачи агентом agentID (вызывается под o.mu)
+func (o *Orchestrator) touchAgent(agentID string, now time.Time) {
+	o.agentsSeen[agentID] = now
+}
+
+// Работающие агенты: недавно запрашивавшие задачи или держащие выданные задачи (вызывается под o.mu)
+func (o *Orchestrator) liveAgents() []string {
+	now := time.Now()
+	live := make([]string, 0, len(o.agentsSeen))
+	for agentID, seen := range o.agentsSeen {
+		if now.Sub(seen) > agentActiveWindow {
+			delete(o.agentsSeen, agentID)
+			continue
+		}
+		live = append(live, agentID)
+	}
+	for _, l := range o.leases {
+		for c := l; c != nil; c = c.duplicate {
+			if !slices.Contains(live, c.agentID) {
+				live = append(live, c.agentID)
+			}
+		}
+	}
+	return live
+}
+
+// Завершалась ли задача id временным сбоем на агенте agentID (вызывается под expr.mu)
+func failedOn(expr *Expression, id int, agentID string) bool {
+	return slices.Contains(expr.failedAgents[id], agentID)
+}
+
+// Узел - операция в очереди с вычисленными аргументами
+func isReadyTask(node *models.Node) bool {
+	return node.Type == models.Operator && node.Status == models.StatusInQueue && node.IsReady()
+}
+
+// Оставшийся путь от узла до корня выражения: суммарное время операций на нём и их количество
+type pathLength struct {
+	duration time.Duration
+	ops      int
+}
+
+// Длиннее ли путь p пути q. При нулевом времени операций сравнивается количество операций
+func (p pathLength) longer(q pathLength) bool {
+	if p.duration != q.duration {
+		return p.duration > q.duration
+	}
+	return p.ops > q.ops
+}
+
+// Оставшиеся пути от каждого узла выражения до корня с учётом времени операций.
+// Узлы нумеруются по уровням снизу вверх, поэтому родитель узла всегда имеет больший номер
+func (o *Orchestrator) criticalPaths(expr *Expression) map[int]pathLength {
+	ids := make(map[*models.Node]int, len(expr.IdMap))
+	order := make([]int, 0, len(expr.IdMap))
+	for id, node := range expr.IdMap {
+		ids[node] = id
+		order = append(order, id)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(order)))
+
+	parents := make(map[int]int, len(expr.IdMap))
+	for id, node := range expr.IdMap {
+		for _, dep := range node.Dependencies {
+			parents[ids[dep]] = id
+		}
+	}
+
+	paths := make(map[int]pathLength, len(expr.IdMap))
+	for _, id := range order {
+		node := expr.IdMap[id]
+		var path pathLength
+		if parent, ok := parents[id]; ok {
+			path = paths[parent]
+		}
+		if node.Type == models.Operator {
+			path.duration += o.operationTime(node.Value)
+			path.ops++
+		}
+		paths[id] = path
+	}
+	return paths
+}
+
+// Готовые для агента agentID задачи выражения, начиная с лежащих на самом длинном
+// оставшемся пути (вызывается под o.mu и expr.mu)
+func (o *Orchestrator) readyTasks(expr *Expression, agentID string) []int {
+	ready := make([]int, 0)
+	for id, node := range expr.IdMap {
+		if isReadyTask(node) && !o.avoidAgent(expr, id, agentID) {
+			ready = append(ready, id)
+		}
+	}
+
+	sort.Slice(ready, func(i, j int) bool {
+		pi, pj := expr.paths[ready[i]], expr.paths[ready[j]]
+		if pi != pj {
+			return pi.longer(pj)
+		}
+		return ready[i] < ready[j]
+	})
+	return ready
+}
+
+// Ожидаемое время завершения выражения при наличии свободных агентов: время до конца
+// самого длинного из путей, начинающихся с невычисленных операций. Для задач, уже выданных
+// агентам, учитывается прошедшее время. Нулевое время - выражение завершено (вызывается под expr.mu)
+func (o *Orchestrator) expectedCompletion(expr *Expression, now time.Time) time.Time {
+	var remaining time.Duration
+	pending := false
+
+	for id, node := range expr.IdMap {
+		if node.Type != models.Operator || node.Status == models.StatusDone {
+			continue
+		}
+		pending = true
+
+		left := expr.paths[id].duration
+		if startedAt, ok := expr.startedAt[id]; ok {
+			left -= min(now.Sub(startedAt), o.operationTime(node.Value))
+		}
+		remaining = max(remaining, left)
+	}
+
+	if !pending {
+		return time.Time{}
+	}
+	return now.Add(remaining)
+}
+
+// Ожидаемое время завершения для ответа API: только для вычисляемых выражений
+// (вызывается под expr.mu)
+func (o *Orchestrator) expectedCompletionView(expr *Expression, now time.Time) *time.Time {
+	if expr.Status != StatusInQueue && expr.Status != StatusInProgress {
+		return nil
+	}
+	return timeView(o.expectedCompletion(expr, now))
+}
@@ -0,0 +1,142 @@
+package orchestrator
+
+import (
+	"context"
+	"final3/internal/logger"
+	"final3/internal/models"
+	"final3/internal/tracing"
+	"strconv"
+	"time"
+)
+
+// Время, после которого задача с операцией operator считается зависшей у агента:
+// время операции, умноженное на коэффициент из speculation_factors, но не меньше
+// speculation_min_delay_ms. Без коэффициента операция не дублируется
+func (o *Orchestrator) speculationDelay(operator string) (time.Duration, bool) {
+	factor, ok := o.Config.SpeculationFactors[operator]
+	if !ok || factor <= 0 {
+		return 0, false
+	}
+
+	delay := time.Duration(float64(o.operationTime(operator)) * factor)
+	return max(delay, time.Duration(o.Config.SpeculationMinDelayMS)*time.Millisecond), true
+}
+
+// Выдача свободному агенту agentID копии задачи, которая дольше всех задерживается у другого
+// агента (вызывается под o.mu). У задачи бывает не больше одной копии; результат принимается
+// от того агента, который ответит первым. nil - подходящих задач нет
+func (o *Orchestrator) speculativeTask(ctx context.Context, agentID string) *models.Task {
+	now := time.Now()
+
+	var (
+		straggler *lease
+		key       taskKey
+		overdue   time.Duration
+	)
+	for k, l := range o.leases {
+		if l.duplicate != nil || l.agentID == agentID || l.expr.expired(now) {
+			continue
+		}
+
+		var operator string
+		l.expr.mu.Lock()
+		node := l.expr.IdMap[k.NodeID]
+		eligible := node != nil && node.Status == models.StatusAtWorker && !failedOn(l.expr, k.NodeID, agentID)
+		if eligible {
+			operator = node.Value
+		}
+		l.expr.mu.Unlock()
+		if !eligible {
+			continue
+		}
+
+		delay, ok := o.speculationDelay(operator)
+		if !ok {
+			continue
+		}
+		if late := now.Sub(l.leasedAt) - delay; late >= 0 && (straggler == nil || late > overdue) {
+			straggler, key, overdue = l, k, late
+		}
+	}
+	if straggler == nil {
+		return nil
+	}
+
+	expr := straggler.expr
+	ctx = logger.ContextWithTaskID(expressionContext(ctx, expr), key.NodeID)
+
+	expr.mu.Lock()
+	defer expr.mu.Unlock()
+
+	node := expr.IdMap[key.NodeID]
+	arg1, err1 := strconv.ParseFloat(node.Dependencies[0].Value, 64)
+	arg2, err2 := strconv.ParseFloat(node.Dependencies[1].Value, 64)
+	if err1 != nil || err2 != nil {
+		return nil
+	}
+
+	_, span := tracing.Start(tracing.ContextWithSpan(ctx, expr.span), "task "+node.Value,
+		tracing.WithAttributes(
+			"expression_id", expr.ID,
+			"task_id", key.NodeID,
+			"operator", node.Value,
+			"agent_id", agentID,
+			"speculative", true))
+
+	straggler.duplicate = &lease{
+		expr:     expr,
+		agentID:  agentID,
+		leasedAt: now,
+		span:     span,
+	}
+
+	operationTime := o.operationTime(node.Value)
+	o.chargeOwner(expr, operationTime)
+	o.metrics.tasksSpeculated.WithLabelValues(node.Value).Inc()
+
+	elapsed := now.Sub(straggler.leasedAt)
+	expr.span.AddEvent("speculative task",
+		"task_id", key.NodeID,
+		"agent_id", agentID,
+		"straggler_agent_id", straggler.agentID,
+		"elapsed_ms", elapsed.Milliseconds())
+	schedulerLog().InfoContext(ctx, "Sending copy of straggler task to idle agent",
+		"task_id", key.NodeID,
+		"agent_id", agentID,
+		"straggler_agent_id", straggler.agentID,
+		"elapsed_ms", elapsed.Milliseconds(),
+		"operation_time_ms", operationTime.Milliseconds())
+
+	return &models.Task{
+		ID:            key.NodeID,
+		ExpressionID:  expr.ID,
+		Arg1:          arg1,
+		Arg2:          arg2,
+		Operation:     node.Value,
+		OperationTime: operationTime,
+		TraceID:       expr.TraceID,
+		Traceparent:   tracing.Traceparent(span.SpanContext()),
+	}
+}
+
+// Отбрасывание копии задачи loser после того, как результат пришёл от копии winner.
+// speculative - результат прислала спекулятивная копия (вызывается под o.mu и expr.mu)
+func (o *Orchestrator) discardCopy(ctx context.Context, expr *Expression, id int, winner, loser *lease, speculative bool) {
+	loser.span.AddEvent("discarded", "winner_agent_id", winner.agentID)
+	loser.span.End()
+
+	if speculative {
+		o.metrics.speculativeWins.WithLabelValues(expr.IdMap[id].Value).Inc()
+	}
+
+	expr.span.AddEvent("speculative result accepted",
+		"task_id", id,
+		"agent_id", winner.agentID,
+		"discarded_agent_id", loser.agentID,
+		"speculative", speculative)
+	schedulerLog().InfoContext(ctx, "Accepted first result of duplicated task, discarding the other copy",
+		"task_id", id,
+		"agent_id", winner.agentID,
+		"discarded_agent_id", loser.agentID,
+		"speculative", speculative)
+}
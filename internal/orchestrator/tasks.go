@@ -0,0 +1,565 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"final3/internal/logger"
+	"final3/internal/models"
+	"final3/internal/tracing"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	agentIDHeader = "X-Agent-ID"
+	backlogHeader = "X-Backlog" // Количество готовых к выполнению задач, по нему агенты масштабируют пул воркеров
+)
+
+var (
+	errQueueEmpty         = errors.New("no expressions in queue")
+	errNoReadyTasks       = errors.New("no tasks to do")
+	errExpressionNotFound = errors.New("expression not found")
+	errNodeNotFound       = errors.New("node not found")
+	errLeaseNotFound      = errors.New("task is not leased")
+	errLeaseNotOwned      = errors.New("task is leased by another agent")
+)
+
+// Ключ задачи: узел конкретного выражения
+type taskKey struct {
+	ExpressionID int32
+	NodeID       int
+}
+
+// Информация о выданной агенту задаче
+type lease struct {
+	expr      *Expression
+	agentID   string
+	leasedAt  time.Time
+	span      *tracing.Span
+	duplicate *lease // Спекулятивная копия задачи у другого агента
+}
+
+// Время выполнения операции согласно конфигу
+func (o *Orchestrator) operationTime(operation string) time.Duration {
+	switch operation {
+	case "+":
+		return time.Duration(o.Config.TimeAdditionMS) * time.Millisecond
+	case "-":
+		return time.Duration(o.Config.TimeSubtractionMS) * time.Millisecond
+	case "*":
+		return time.Duration(o.Config.TimeMultiplicationsMS) * time.Millisecond
+	case "/":
+		return time.Duration(o.Config.TimeDivisionsMS) * time.Millisecond
+	}
+	return 0
+}
+
+// Выдача следующей готовой к вычислению задачи агенту agentID
+func (o *Orchestrator) nextTask(ctx context.Context, agentID string) (*models.Task, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.touchAgent(agentID, time.Now())
+
+	if o.Queue.Len() == 0 {
+		schedulerLog().DebugContext(ctx, "No tasks available in queue")
+		return nil, errQueueEmpty
+	}
+
+	expr := o.selectExpression(agentID)
+	if expr == nil {
+		// Свободному агенту может достаться копия задачи, слишком долго выполняющейся у другого агента
+		if task := o.speculativeTask(ctx, agentID); task != nil {
+			return task, nil
+		}
+		schedulerLog().DebugContext(ctx, "No eligible tasks found")
+		return nil, errNoReadyTasks
+	}
+	ctx = expressionContext(ctx, expr)
+	schedulerLog().DebugContext(ctx, "Found expression in queue",
+		"expression_id", expr.ID)
+
+	expr.mu.Lock()
+	defer expr.mu.Unlock()
+
+	expr.Status = StatusInProgress
+	schedulerLog().DebugContext(ctx, "Expression status updated",
+		"expression_id", expr.ID,
+		"status", string(StatusInProgress))
+
+	// Первыми выдаются задачи на самом длинном оставшемся пути выражения
+	for _, id := range o.readyTasks(expr, agentID) {
+		task := expr.IdMap[id]
+		ctx := logger.ContextWithTaskID(ctx, id)
+
+		dep1 := task.Dependencies[0]
+		dep2 := task.Dependencies[1]
+
+		arg1, err := strconv.ParseFloat(dep1.Value, 64)
+		if err != nil {
+			schedulerLog().ErrorContext(ctx, "Error parsing arg1",
+				"value", dep1.Value,
+				"error", err)
+			continue
+		}
+
+		arg2, err := strconv.ParseFloat(dep2.Value, 64)
+		if err != nil {
+			schedulerLog().ErrorContext(ctx, "Error parsing arg2",
+				"value", dep2.Value,
+				"error", err)
+			continue
+		}
+
+		task.Status = models.StatusAtWorker
+		expr.startedAt[id] = time.Now()
+		schedulerLog().DebugContext(ctx, "Task status updated",
+			"task_id", id,
+			"status", task.Status)
+
+		_, span := tracing.Start(tracing.ContextWithSpan(ctx, expr.span), "task "+task.Value,
+			tracing.WithAttributes(
+				"expression_id", expr.ID,
+				"task_id", id,
+				"operator", task.Value,
+				"agent_id", agentID))
+
+		o.leases[taskKey{ExpressionID: expr.ID, NodeID: id}] = &lease{
+			expr:     expr,
+			agentID:  agentID,
+			leasedAt: time.Now(),
+			span:     span,
+		}
+		o.metrics.tasksDispatched.WithLabelValues(task.Value).Inc()
+		// Когда задача начнёт считаться зависшей, свободные агенты смогут взять её копию
+		if delay, ok := o.speculationDelay(task.Value); ok {
+			time.AfterFunc(delay, o.notifyTasks)
+		}
+
+		operationTime := o.operationTime(task.Value)
+		o.chargeOwner(expr, operationTime)
+
+		schedulerLog().InfoContext(ctx, "Sending task to worker",
+			"task_id", id,
+			"expression_id", expr.ID,
+			"agent_id", agentID,
+			"arg1", arg1,
+			"operation", task.Value,
+			"arg2", arg2)
+
+		schedulerLog().DebugContext(ctx, "Operation time calculated",
+			"operation", task.Value,
+			"time_ms", operationTime.Milliseconds())
+
+		return &models.Task{
+			ID:            id,
+			ExpressionID:  expr.ID,
+			Arg1:          arg1,
+			Arg2:          arg2,
+			Operation:     task.Value,
+			OperationTime: operationTime,
+			TraceID:       expr.TraceID,
+			Traceparent:   tracing.Traceparent(span.SpanContext()),
+		}, nil
+	}
+
+	schedulerLog().DebugContext(ctx, "No eligible tasks found")
+	return nil, errNoReadyTasks
+}
+
+// Количество задач в очереди, готовых к выполнению (все аргументы вычислены)
+func (o *Orchestrator) readyTasksCount() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	count := 0
+	for _, expr := range o.Queue.Items() {
+		expr.mu.Lock()
+		for _, node := range expr.IdMap {
+			if isReadyTask(node) {
+				count++
+			}
+		}
+		expr.mu.Unlock()
+	}
+
+	return count
+}
+
+// Удаление выражения из очереди (вызывается под o.mu)
+func (o *Orchestrator) removeFromQueue(expr *Expression) {
+	if o.Queue.Remove(expr) {
+		o.forgetOwner(expr.OwnerID)
+		schedulerLog().Debug("Expression removed from queue",
+			"expression_id", expr.ID,
+			"queue_length", o.Queue.Len())
+	}
+}
+
+// Приём результата задачи от агента agentID. Результат принимается только от агента,
+// которому задача выдана и не возвращена в очередь
+func (o *Orchestrator) completeTask(ctx context.Context, agentID string, res models.TaskResult) error {
+	o.DataBase.mu.Lock()
+	expr, ok := o.DataBase.ExpressionList[res.ExpressionID]
+	o.DataBase.mu.Unlock()
+	if !ok {
+		schedulerLog().WarnContext(ctx, "Received result for unknown expression",
+			"task_id", res.ID,
+			"expression_id", res.ExpressionID)
+		return errExpressionNotFound
+	}
+	ctx = logger.ContextWithTaskID(expressionContext(ctx, expr), res.ID)
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	key := taskKey{ExpressionID: res.ExpressionID, NodeID: res.ID}
+	l, err := o.leaseOf(key, agentID)
+	switch err {
+	case errLeaseNotFound:
+		schedulerLog().WarnContext(ctx, "Rejected result for task that is not leased",
+			"task_id", res.ID,
+			"agent_id", agentID)
+		return err
+	case errLeaseNotOwned:
+		schedulerLog().WarnContext(ctx, "Rejected result from agent that does not hold the lease",
+			"task_id", res.ID,
+			"agent_id", agentID,
+			"lease_agent_id", o.leases[key].agentID)
+		return err
+	}
+	// Копия задачи у другого агента, если задача выполнялась спекулятивно
+	speculative := o.leases[key] != l
+	other := o.dropCopyLocked(key, l)
+	// Результат делает готовыми следующие задачи выражения (или возвращает эту на повтор),
+	// ожидающие агенты проверят их после освобождения o.mu
+	o.notifyTasksLocked()
+
+	expr.mu.Lock()
+	defer expr.mu.Unlock()
+
+	stringResult := fmt.Sprintf("%.5f", res.Result)
+	schedulerLog().DebugContext(ctx, "Processing task result",
+		"task_id", res.ID,
+		"expression_id", expr.ID,
+		"result", stringResult)
+
+	completedNode := expr.IdMap[res.ID]
+	if completedNode == nil {
+		schedulerLog().ErrorContext(ctx, "Node not found",
+			"task_id", res.ID,
+			"expression_id", expr.ID)
+		return errNodeNotFound
+	}
+
+	if res.Error != "" {
+		l.span.SetAttribute("error_class", string(errorClass(res)))
+		l.span.RecordError(errors.New(res.Error))
+	} else {
+		l.span.SetAttribute("result", res.Result)
+	}
+	l.span.End()
+
+	// Повторный результат для уже вычисленного узла в метриках не учитывается
+	if completedNode.Type == models.Operator {
+		o.metrics.taskLatency.WithLabelValues(completedNode.Value).Observe(time.Since(l.leasedAt).Seconds())
+	}
+
+	// Временный сбой одной из копий задачи: результат дождётся от другой копии
+	if other != nil && res.Error != "" && errorClass(res) == models.ErrorTransient {
+		expr.failedAgents[res.ID] = append(expr.failedAgents[res.ID], agentID)
+		expr.span.AddEvent("speculative copy failed",
+			"task_id", res.ID,
+			"agent_id", agentID,
+			"remaining_agent_id", other.agentID,
+			"error", res.Error)
+		schedulerLog().WarnContext(ctx, "Transient failure of task copy, waiting for another agent",
+			"task_id", res.ID,
+			"agent_id", agentID,
+			"remaining_agent_id", other.agentID,
+			"error", res.Error)
+		return nil
+	}
+
+	// Принимается первый результат, копия задачи у другого агента отбрасывается
+	if other != nil {
+		delete(o.leases, key)
+		o.discardCopy(ctx, expr, res.ID, l, other, speculative)
+	}
+
+	delete(expr.startedAt, res.ID)
+
+	// Временный сбой агента: задача возвращается в очередь и будет выдана другому агенту
+	if res.Error != "" && errorClass(res) == models.ErrorTransient {
+		attempt := len(expr.failedAgents[res.ID]) + 1
+		expr.failedAgents[res.ID] = append(expr.failedAgents[res.ID], agentID)
+
+		if attempt <= o.Config.MaxTaskRetries {
+			completedNode.Status = models.StatusInQueue
+			expr.Status = StatusInQueue
+			o.metrics.tasksRetried.WithLabelValues(completedNode.Value).Inc()
+			expr.span.AddEvent("task retry",
+				"task_id", res.ID,
+				"failed_agent_id", agentID,
+				"attempt", attempt,
+				"error", res.Error)
+
+			schedulerLog().WarnContext(ctx, "Transient task failure, task will be retried",
+				"task_id", res.ID,
+				"agent_id", agentID,
+				"attempt", attempt,
+				"max_retries", o.Config.MaxTaskRetries,
+				"error", res.Error)
+			return nil
+		}
+
+		schedulerLog().WarnContext(ctx, "Task retries exhausted",
+			"task_id", res.ID,
+			"attempts", attempt,
+			"max_retries", o.Config.MaxTaskRetries)
+		res.Error = fmt.Sprintf("%s (failed after %d attempts)", res.Error, attempt)
+	}
+
+	// Задачи, отправленные на повтор, в failed не учитываются: там только окончательные ошибки
+	if completedNode.Type == models.Operator {
+		if res.Error != "" {
+			o.metrics.tasksFailed.WithLabelValues(completedNode.Value).Inc()
+		} else {
+			o.metrics.tasksCompleted.WithLabelValues(completedNode.Value).Inc()
+		}
+		if expr.admitted {
+			o.pendingNodes--
+		}
+	}
+
+	completedNode.Value = stringResult
+	completedNode.Status = models.StatusDone
+	completedNode.Type = models.Number
+
+	schedulerLog().DebugContext(ctx, "Node updated",
+		"task_id", res.ID,
+		"status", completedNode.Status,
+		"type", completedNode.Type)
+
+	if res.Error != "" {
+		schedulerLog().ErrorContext(ctx, "Task error reported",
+			"task_id", res.ID,
+			"expression_id", expr.ID,
+			"error", res.Error)
+
+		expr.Status = StatusError
+		expr.Err = errors.New(res.Error)
+
+		schedulerLog().DebugContext(ctx, "Expression status updated",
+			"expression_id", expr.ID,
+			"status", string(StatusError))
+
+		o.removeFromQueue(expr)
+		o.finishExpression(expr)
+		return nil
+	}
+
+	maxLevel := 0
+	for _, node := range expr.IdMap {
+		if node.Level > maxLevel {
+			maxLevel = node.Level
+		}
+	}
+
+	schedulerLog().DebugContext(ctx, "Checking if expression is complete",
+		"expression_id", expr.ID,
+		"max_level", maxLevel)
+
+	allMaxLevelDone := true
+	for _, node := range expr.IdMap {
+		if node.Level == maxLevel && node.Status != models.StatusDone {
+			allMaxLevelDone = false
+			schedulerLog().DebugContext(ctx, "Found unfinished node at max level",
+				"node_level", node.Level,
+				"node_status", node.Status)
+			break
+		}
+	}
+
+	if !allMaxLevelDone {
+		expr.Status = StatusInQueue
+		schedulerLog().DebugContext(ctx, "Expression not yet complete, returning to queue",
+			"expression_id", expr.ID)
+		return nil
+	}
+
+	schedulerLog().InfoContext(ctx, "All nodes at max level are done, expression is complete",
+		"expression_id", expr.ID)
+
+	for _, node := range expr.IdMap {
+		if node.Level != maxLevel {
+			continue
+		}
+
+		result, err := strconv.ParseFloat(node.Value, 64)
+		if err != nil {
+			schedulerLog().ErrorContext(ctx, "Failed to parse final result",
+				"value", node.Value,
+				"error", err)
+			break
+		}
+
+		expr.Result = result
+		expr.Status = StatusDone
+		o.removeFromQueue(expr)
+		o.finishExpression(expr)
+
+		schedulerLog().InfoContext(ctx, "Expression completed",
+			"expression_id", expr.ID,
+			"result", expr.Result)
+		break
+	}
+
+	return nil
+}
+
+// Класс ошибки задачи. Ошибки агентов, не сообщающих класс, считаются ошибками вычисления
+func errorClass(res models.TaskResult) models.ErrorClass {
+	if res.ErrorClass == "" {
+		return models.ErrorMath
+	}
+	return res.ErrorClass
+}
+
+// Контекст логов для выражения: идентификатор выражения и его сквозной идентификатор,
+// если вызывающая сторона его не передала
+func expressionContext(ctx context.Context, expr *Expression) context.Context {
+	ctx = logger.ContextWithExpressionID(ctx, expr.ID)
+	if logger.TraceIDFromContext(ctx) == "" && expr.TraceID != "" {
+		ctx = logger.ContextWithTraceID(ctx, expr.TraceID)
+	}
+	return ctx
+}
+
+// Учёт завершения вычисления выражения (вызывается под o.mu и expr.mu)
+func (o *Orchestrator) finishExpression(expr *Expression) {
+	if expr.timer != nil {
+		expr.timer.Stop()
+	}
+	o.metrics.expressionFinished(expr)
+	o.releaseActiveLocked(expr.OwnerID, expr.ownerIP)
+	o.releaseAdmission(expr)
+
+	expr.span.SetAttribute("status", string(expr.Status))
+	expr.span.RecordError(expr.Err)
+	expr.span.End()
+}
+
+// Копия задачи key, выданная агенту agentID (вызывается под o.mu)
+func (o *Orchestrator) leaseOf(key taskKey, agentID string) (*lease, error) {
+	l, ok := o.leases[key]
+	if !ok {
+		return nil, errLeaseNotFound
+	}
+	if l.agentID == agentID {
+		return l, nil
+	}
+	if l.duplicate != nil && l.duplicate.agentID == agentID {
+		return l.duplicate, nil
+	}
+	return nil, errLeaseNotOwned
+}
+
+// Снятие копии l задачи key с агента (вызывается под o.mu). Если у задачи есть другая копия,
+// она становится основной и возвращается
+func (o *Orchestrator) dropCopyLocked(key taskKey, l *lease) *lease {
+	rest := o.leases[key]
+	if rest == l {
+		rest = l.duplicate
+	}
+	if rest == nil {
+		delete(o.leases, key)
+		return nil
+	}
+
+	rest.duplicate = nil
+	o.leases[key] = rest
+	return rest
+}
+
+// Возврат в очередь копии задачи l, выданной агенту (вызывается под o.mu). Если задача
+// выполняется ещё одним агентом, в очередь она не возвращается
+func (o *Orchestrator) requeueTaskLocked(key taskKey, l *lease) {
+	rest := o.dropCopyLocked(key, l)
+
+	l.span.AddEvent("requeued", "agent_id", l.agentID)
+	l.span.End()
+	o.notifyTasksLocked()
+
+	if rest != nil {
+		schedulerLog().Info("Task copy released, task remains at another agent",
+			"task_id", key.NodeID,
+			"expression_id", key.ExpressionID,
+			"agent_id", l.agentID,
+			"remaining_agent_id", rest.agentID)
+		return
+	}
+
+	l.expr.mu.Lock()
+	defer l.expr.mu.Unlock()
+
+	node := l.expr.IdMap[key.NodeID]
+	if node == nil || node.Status != models.StatusAtWorker {
+		return
+	}
+
+	node.Status = models.StatusInQueue
+	delete(l.expr.startedAt, key.NodeID)
+	schedulerLog().Info("Task returned to queue",
+		"task_id", key.NodeID,
+		"expression_id", key.ExpressionID,
+		"agent_id", l.agentID)
+}
+
+// Возврат агентом agentID выданной ему задачи в очередь
+func (o *Orchestrator) releaseTask(key taskKey, agentID string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	l, err := o.leaseOf(key, agentID)
+	if err == errLeaseNotOwned {
+		schedulerLog().Warn("Agent tried to release task leased by another agent",
+			"task_id", key.NodeID,
+			"expression_id", key.ExpressionID,
+			"agent_id", agentID,
+			"lease_agent_id", o.leases[key].agentID)
+	}
+	if err != nil {
+		return err
+	}
+
+	o.requeueTaskLocked(key, l)
+	return nil
+}
+
+// Возврат в очередь всех задач, выданных агенту agentID
+func (o *Orchestrator) releaseAgentTasks(agentID string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for key, l := range o.leases {
+		if l.agentID == agentID {
+			o.requeueTaskLocked(key, l)
+		} else if l.duplicate != nil && l.duplicate.agentID == agentID {
+			o.requeueTaskLocked(key, l.duplicate)
+		}
+	}
+}
+
+// Идентификатор агента, подтверждённый requireAgent (иначе - из заголовка запроса или адрес клиента)
+func agentIDFromRequest(r *http.Request) string {
+	if id, ok := r.Context().Value(agentKey{}).(string); ok {
+		return id
+	}
+	if id := r.Header.Get(agentIDHeader); id != "" {
+		return id
+	}
+	return r.RemoteAddr
+}
@@ -0,0 +1,277 @@
+package orchestrator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"final3/internal/auth"
+	"final3/internal/logger"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	defaultTokenTTL  = 24 * time.Hour
+	maxLoginLen      = 64
+	minPasswordLen   = 8
+	maxPasswordBytes = 72 // Ограничение bcrypt
+)
+
+var (
+	errUserExists         = errors.New("user already exists")
+	errInvalidCredentials = errors.New("invalid login or password")
+)
+
+type User struct {
+	ID           int32
+	Login        string
+	PasswordHash []byte
+	createdAt    time.Time
+}
+
+type userKey struct{}
+
+// Пользователь, от имени которого выполняется запрос
+func contextWithUser(ctx context.Context, user *User) context.Context {
+	return context.WithValue(ctx, userKey{}, user)
+}
+
+func userFromContext(ctx context.Context) *User {
+	user, _ := ctx.Value(userKey{}).(*User)
+	return user
+}
+
+// Регистрация пользователя
+func (o *Orchestrator) createUser(login, password string) (*User, error) {
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	o.DataBase.mu.Lock()
+	defer o.DataBase.mu.Unlock()
+
+	if _, ok := o.DataBase.logins[login]; ok {
+		return nil, errUserExists
+	}
+
+	o.DataBase.PrevUserID++
+	user := &User{
+		ID:           o.DataBase.PrevUserID,
+		Login:        login,
+		PasswordHash: hash,
+		createdAt:    time.Now(),
+	}
+	o.DataBase.Users[user.ID] = user
+	o.DataBase.logins[login] = user
+	return user, nil
+}
+
+// Проверка логина и пароля
+func (o *Orchestrator) authenticate(login, password string) (*User, error) {
+	o.DataBase.mu.Lock()
+	user, ok := o.DataBase.logins[login]
+	o.DataBase.mu.Unlock()
+
+	if !ok {
+		return nil, errInvalidCredentials
+	}
+	if err := auth.CheckPassword(user.PasswordHash, password); err != nil {
+		return nil, errInvalidCredentials
+	}
+	return user, nil
+}
+
+func (o *Orchestrator) userByID(id int32) *User {
+	o.DataBase.mu.Lock()
+	defer o.DataBase.mu.Unlock()
+
+	return o.DataBase.Users[id]
+}
+
+type credentials struct {
+	Login    string `json:"login"`
+	Password string `json:"password"`
+}
+
+// Разбор и проверка тела запросов регистрации и входа
+func decodeCredentials(w http.ResponseWriter, r *http.Request) (credentials, bool) {
+	var creds credentials
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Wrong method, expected POST", http.StatusUnprocessableEntity)
+		return creds, false
+	}
+
+	if r.Header.Get("Content-Type") != "application/json" {
+		http.Error(w, "Wrong content-type, expected JSON", http.StatusUnprocessableEntity)
+		return creds, false
+	}
+	defer r.Body.Close()
+
+	if err := json.NewDecoder(r.Body).Decode(&creds); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return creds, false
+	}
+
+	creds.Login = strings.TrimSpace(creds.Login)
+	if creds.Login == "" || utf8.RuneCountInString(creds.Login) > maxLoginLen {
+		http.Error(w, "Invalid login", http.StatusUnprocessableEntity)
+		return creds, false
+	}
+	if len(creds.Password) == 0 || len(creds.Password) > maxPasswordBytes {
+		http.Error(w, "Invalid password", http.StatusUnprocessableEntity)
+		return creds, false
+	}
+	return creds, true
+}
+
+func (o *Orchestrator) RegisterHandler(w http.ResponseWriter, r *http.Request) {
+	logger.DebugContext(r.Context(), "Received register request",
+		"remote_addr", r.RemoteAddr,
+		"method", r.Method)
+
+	creds, ok := decodeCredentials(w, r)
+	if !ok {
+		return
+	}
+
+	if utf8.RuneCountInString(creds.Password) < minPasswordLen {
+		http.Error(w, "Password is too short", http.StatusUnprocessableEntity)
+		return
+	}
+
+	setAuditUser(r.Context(), creds.Login)
+	user, err := o.createUser(creds.Login, creds.Password)
+	if err == errUserExists {
+		http.Error(w, "User already exists", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		logger.ErrorContext(r.Context(), "Failed to create user", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	logger.InfoContext(r.Context(), "User registered",
+		"user_id", user.ID,
+		"login", user.Login)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		ID    int32  `json:"id"`
+		Login string `json:"login"`
+	}{
+		ID:    user.ID,
+		Login: user.Login,
+	})
+}
+
+func (o *Orchestrator) LoginHandler(w http.ResponseWriter, r *http.Request) {
+	logger.DebugContext(r.Context(), "Received login request",
+		"remote_addr", r.RemoteAddr,
+		"method", r.Method)
+
+	creds, ok := decodeCredentials(w, r)
+	if !ok {
+		return
+	}
+
+	setAuditUser(r.Context(), creds.Login)
+	user, err := o.authenticate(creds.Login, creds.Password)
+	if err != nil {
+		logger.WarnContext(r.Context(), "Failed login attempt",
+			"login", creds.Login,
+			"remote_addr", r.RemoteAddr)
+		http.Error(w, "Invalid login or password", http.StatusUnauthorized)
+		return
+	}
+
+	token, expiresAt, err := o.tokens.Issue(user.ID, user.Login)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "Failed to issue token", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	logger.InfoContext(r.Context(), "User logged in",
+		"user_id", user.ID,
+		"login", user.Login)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}{
+		Token:     token,
+		ExpiresAt: expiresAt,
+	})
+}
+
+// Токен из заголовка Authorization: Bearer <token>
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
+
+// Проверка токена доступа (JWT или API-ключа); пользователь запроса передаётся
+// обработчику через контекст. API-ключ должен иметь право scope, при пустом scope
+// API-ключи не принимаются (например, для управления самими ключами)
+func (o *Orchestrator) requireUser(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := bearerToken(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Authorization required", http.StatusUnauthorized)
+			return
+		}
+
+		if auth.IsAPIKey(token) {
+			apiKey, user, err := o.useAPIKey(token)
+			if err != nil {
+				logger.WarnContext(r.Context(), "Rejected api key",
+					"remote_addr", r.RemoteAddr,
+					"error", err)
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				http.Error(w, "Invalid API key", http.StatusUnauthorized)
+				return
+			}
+
+			setAuditUser(r.Context(), user.Login)
+			setAuditAPIKey(r.Context(), apiKey.ID)
+			if scope == "" || !apiKey.allows(scope) {
+				logger.WarnContext(r.Context(), "API key lacks required scope",
+					"api_key_id", apiKey.ID,
+					"scope", scope)
+				w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope"`)
+				http.Error(w, "API key is not allowed to perform this action", http.StatusForbidden)
+				return
+			}
+
+			next(w, r.WithContext(contextWithUser(r.Context(), user)))
+			return
+		}
+
+		claims, err := o.tokens.Parse(token)
+		var user *User
+		if err == nil {
+			user = o.userByID(claims.UserID)
+		}
+		if user == nil {
+			logger.WarnContext(r.Context(), "Rejected access token",
+				"remote_addr", r.RemoteAddr,
+				"error", err)
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			http.Error(w, "Invalid token", http.StatusUnauthorized)
+			return
+		}
+
+		setAuditUser(r.Context(), user.Login)
+		next(w, r.WithContext(contextWithUser(r.Context(), user)))
+	}
+}
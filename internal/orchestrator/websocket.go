@@ -0,0 +1,206 @@
+package orchestrator
+
+import (
+	"context"
+	"final3/internal/logger"
+	"final3/internal/models"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	wsWriteWait       = 10 * time.Second
+	wsPongWait        = 60 * time.Second
+	wsPingPeriod      = (wsPongWait * 9) / 10
+	wsRecheckInterval = agentActiveWindow // Проверка задач без оповещения, когда другие агенты становятся неактивными
+)
+
+var wsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// Обработчик WebSocket-подключения агента: задачи отправляются агенту по мере готовности,
+// результаты принимаются по тому же соединению. При отключении агента все выданные ему
+// задачи возвращаются в очередь
+func (o *Orchestrator) AgentWebSocketHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Debug("Received websocket connection request",
+		"remote_addr", r.RemoteAddr)
+
+	capacity := 1
+	if value := r.URL.Query().Get("capacity"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			logger.Warn("Invalid websocket capacity",
+				"capacity", value,
+				"remote_addr", r.RemoteAddr)
+			http.Error(w, "Invalid capacity", http.StatusBadRequest)
+			return
+		}
+		capacity = n
+	}
+
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		logger.Warn("Failed to upgrade connection to websocket",
+			"remote_addr", r.RemoteAddr,
+			"error", err)
+		return
+	}
+	defer conn.Close()
+
+	agentID := fmt.Sprintf("%s#ws%d", agentIDFromRequest(r), o.wsSessions.Add(1))
+	logger.Info("Agent connected via websocket",
+		"agent_id", agentID,
+		"capacity", capacity)
+
+	defer func() {
+		o.releaseAgentTasks(agentID)
+		logger.Info("Agent websocket disconnected",
+			"agent_id", agentID)
+	}()
+
+	slots := make(chan struct{}, capacity)
+	for i := 0; i < capacity; i++ {
+		slots <- struct{}{}
+	}
+
+	// Сигнал от читающей горутины об освободившемся слоте
+	slotFreed := make(chan struct{}, 1)
+	readDone := make(chan struct{})
+	go func() {
+		defer close(readDone)
+		o.readAgentResults(r.Context(), conn, agentID, slots, slotFreed)
+	}()
+
+	pingTicker := time.NewTicker(wsPingPeriod)
+	defer pingTicker.Stop()
+	recheckTicker := time.NewTicker(wsRecheckInterval)
+	defer recheckTicker.Stop()
+
+	for {
+		// Канал берётся до выдачи задач, чтобы не пропустить оповещение между ними
+		changed := o.tasksChangedChan()
+		if err := o.pushTasks(r.Context(), conn, agentID, slots); err != nil {
+			logger.Warn("Failed to push task to agent",
+				"agent_id", agentID,
+				"error", err)
+			return
+		}
+
+		select {
+		case <-r.Context().Done():
+			conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseGoingAway, "orchestrator shutting down"),
+				time.Now().Add(wsWriteWait))
+			return
+		case <-readDone:
+			return
+		case <-pingTicker.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait)); err != nil {
+				logger.Warn("Failed to send heartbeat to agent",
+					"agent_id", agentID,
+					"error", err)
+				return
+			}
+		case <-changed:
+		case <-slotFreed:
+		case <-recheckTicker.C:
+		}
+	}
+}
+
+// Канал, который закроется при следующем изменении набора готовых задач
+func (o *Orchestrator) tasksChangedChan() <-chan struct{} {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.tasksChanged == nil {
+		o.tasksChanged = make(chan struct{})
+	}
+	return o.tasksChanged
+}
+
+// Оповещение агентов, ожидающих задачи по WebSocket (вызывается под o.mu)
+func (o *Orchestrator) notifyTasksLocked() {
+	if o.tasksChanged != nil {
+		close(o.tasksChanged)
+		o.tasksChanged = nil
+	}
+}
+
+func (o *Orchestrator) notifyTasks() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.notifyTasksLocked()
+}
+
+// Отправка агенту готовых задач, пока у него есть свободные слоты
+func (o *Orchestrator) pushTasks(ctx context.Context, conn *websocket.Conn, agentID string, slots chan struct{}) error {
+	for {
+		select {
+		case <-slots:
+		default:
+			return nil
+		}
+
+		task, err := o.nextTask(ctx, agentID)
+		if err != nil {
+			slots <- struct{}{}
+			return nil
+		}
+
+		conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+		if err := conn.WriteJSON(task); err != nil {
+			return err
+		}
+	}
+}
+
+// Чтение результатов от агента до разрыва соединения
+func (o *Orchestrator) readAgentResults(ctx context.Context, conn *websocket.Conn, agentID string, slots, slotFreed chan struct{}) {
+	conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+
+	for {
+		var res models.TaskResult
+		if err := conn.ReadJSON(&res); err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				logger.Warn("Agent websocket read error",
+					"agent_id", agentID,
+					"error", err)
+			}
+			return
+		}
+
+		logger.Info("Received task result",
+			"task_id", res.ID,
+			"expression_id", res.ExpressionID,
+			"agent_id", agentID,
+			"result", res.Result,
+			"error", res.Error)
+
+		if err := o.completeTask(ctx, agentID, res); err != nil {
+			logger.Warn("Failed to process task result",
+				"task_id", res.ID,
+				"expression_id", res.ExpressionID,
+				"agent_id", agentID,
+				"error", err)
+		}
+
+		select {
+		case slots <- struct{}{}:
+		default:
+		}
+		select {
+		case slotFreed <- struct{}{}:
+		default:
+		}
+	}
+}
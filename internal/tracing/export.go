@@ -0,0 +1,84 @@
+package tracing
+
+import (
+	"context"
+	"final3/internal/config"
+	"final3/internal/logger"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// Инициализация глобального трассировщика сервиса service согласно конфигу
+func Init(cfg *config.Config, service string) error {
+	var exporter sdktrace.SpanExporter
+
+	switch cfg.Tracing.Exporter {
+	case config.TracingNone, "":
+	case config.TracingStdout:
+		exporter = NewWriterExporter(os.Stdout)
+	case config.TracingFile:
+		fileExporter, err := NewFileExporter(cfg.Tracing.File)
+		if err != nil {
+			return err
+		}
+		exporter = fileExporter
+	case config.TracingOTLP:
+		otlpExporter, err := NewOTLPExporter(cfg.Tracing.Endpoint)
+		if err != nil {
+			return err
+		}
+		exporter = otlpExporter
+	default:
+		return fmt.Errorf("unknown tracing exporter: %s", cfg.Tracing.Exporter)
+	}
+
+	SetTracer(NewTracer(service, exporter))
+	logger.Info("Tracing initialized",
+		"service", service,
+		"exporter", cfg.Tracing.Exporter)
+	return nil
+}
+
+// Экспортёр, записывающий спаны в w построчно в формате JSON
+func NewWriterExporter(w io.Writer) sdktrace.SpanExporter {
+	// stdouttrace.New возвращает ошибку только в сигнатуре
+	exporter, _ := stdouttrace.New(stdouttrace.WithWriter(w))
+	return exporter
+}
+
+// Экспортёр в файл, закрывающий его при остановке
+type fileExporter struct {
+	sdktrace.SpanExporter
+	file *os.File
+}
+
+// Экспортёр в файл path (записи дописываются в конец)
+func NewFileExporter(path string) (sdktrace.SpanExporter, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &fileExporter{SpanExporter: NewWriterExporter(file), file: file}, nil
+}
+
+func (e *fileExporter) Shutdown(ctx context.Context) error {
+	if err := e.SpanExporter.Shutdown(ctx); err != nil {
+		return err
+	}
+	return e.file.Close()
+}
+
+// Экспортёр по протоколу OTLP/HTTP; endpoint - адрес приёмника, например http://otel-collector:4318
+func NewOTLPExporter(endpoint string) (sdktrace.SpanExporter, error) {
+	url := strings.TrimRight(endpoint, "/")
+	if !strings.HasSuffix(url, "/v1/traces") {
+		url += "/v1/traces"
+	}
+	return otlptracehttp.New(context.Background(), otlptracehttp.WithEndpointURL(url))
+}
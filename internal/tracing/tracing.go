@@ -0,0 +1,260 @@
+// Трассировка жизненного цикла выражений на SDK OpenTelemetry с W3C-контекстом (traceparent)
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const TraceparentHeader = "traceparent"
+
+var errInvalidTraceparent = errors.New("invalid traceparent")
+
+var propagator = propagation.TraceContext{}
+
+// Разбор идентификатора трассировки из 32 шестнадцатеричных символов
+func ParseTraceID(s string) (trace.TraceID, error) {
+	return trace.TraceIDFromHex(s)
+}
+
+// Разбор заголовка traceparent
+func ParseTraceparent(s string) (trace.SpanContext, error) {
+	ctx := propagator.Extract(context.Background(), propagation.MapCarrier{TraceparentHeader: s})
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return trace.SpanContext{}, errInvalidTraceparent
+	}
+	return sc, nil
+}
+
+// Значение заголовка traceparent для контекста спана (пусто для недействительного контекста)
+func Traceparent(sc trace.SpanContext) string {
+	carrier := propagation.MapCarrier{}
+	propagator.Inject(trace.ContextWithSpanContext(context.Background(), sc), carrier)
+	return carrier.Get(TraceparentHeader)
+}
+
+// Выполняемая операция. Методы nil-спана ничего не делают
+type Span struct {
+	span trace.Span
+}
+
+func (s *Span) SpanContext() trace.SpanContext {
+	if s == nil {
+		return trace.SpanContext{}
+	}
+	return s.span.SpanContext()
+}
+
+func (s *Span) SetAttribute(key string, value any) {
+	if s == nil {
+		return
+	}
+	s.span.SetAttributes(attributeOf(key, value))
+}
+
+// Добавление события; attrs - пары ключ-значение
+func (s *Span) AddEvent(name string, attrs ...any) {
+	if s == nil {
+		return
+	}
+	s.span.AddEvent(name, trace.WithAttributes(pairs(attrs)...))
+}
+
+// Отметка спана как завершившегося ошибкой
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.span.RecordError(err)
+	s.span.SetStatus(codes.Error, err.Error())
+}
+
+// Завершение спана и передача его экспортёру. Повторные вызовы игнорируются
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.span.End()
+}
+
+// Атрибут OpenTelemetry из значения произвольного типа
+func attributeOf(key string, value any) attribute.KeyValue {
+	switch v := value.(type) {
+	case string:
+		return attribute.String(key, v)
+	case bool:
+		return attribute.Bool(key, v)
+	case int:
+		return attribute.Int(key, v)
+	case int8:
+		return attribute.Int64(key, int64(v))
+	case int16:
+		return attribute.Int64(key, int64(v))
+	case int32:
+		return attribute.Int64(key, int64(v))
+	case int64:
+		return attribute.Int64(key, v)
+	case uint8:
+		return attribute.Int64(key, int64(v))
+	case uint16:
+		return attribute.Int64(key, int64(v))
+	case uint32:
+		return attribute.Int64(key, int64(v))
+	case float32:
+		return attribute.Float64(key, float64(v))
+	case float64:
+		return attribute.Float64(key, v)
+	}
+	return attribute.String(key, fmt.Sprint(value))
+}
+
+func pairs(kv []any) []attribute.KeyValue {
+	attrs := make([]attribute.KeyValue, 0, len(kv)/2)
+	for i := 0; i+1 < len(kv); i += 2 {
+		attrs = append(attrs, attributeOf(fmt.Sprint(kv[i]), kv[i+1]))
+	}
+	return attrs
+}
+
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	if span == nil {
+		return ctx
+	}
+	return trace.ContextWithSpan(ctx, span.span)
+}
+
+func SpanFromContext(ctx context.Context) *Span {
+	span := trace.SpanFromContext(ctx)
+	if !span.SpanContext().IsValid() {
+		return nil
+	}
+	return &Span{span: span}
+}
+
+// Контекст с родительским спаном из другого сервиса (например, из traceparent)
+func ContextWithRemoteSpanContext(ctx context.Context, sc trace.SpanContext) context.Context {
+	return trace.ContextWithRemoteSpanContext(ctx, sc)
+}
+
+type startConfig struct {
+	traceID    trace.TraceID
+	attributes []attribute.KeyValue
+}
+
+type StartOption func(*startConfig)
+
+// Идентификатор трассировки для корневого спана (если в контексте нет родителя)
+func WithTraceID(id trace.TraceID) StartOption {
+	return func(c *startConfig) {
+		c.traceID = id
+	}
+}
+
+// Начальные атрибуты спана; kv - пары ключ-значение
+func WithAttributes(kv ...any) StartOption {
+	return func(c *startConfig) {
+		c.attributes = append(c.attributes, pairs(kv)...)
+	}
+}
+
+type traceIDKey struct{}
+
+// Генератор идентификаторов, использующий для корневого спана идентификатор из WithTraceID
+type idGenerator struct{}
+
+func (idGenerator) NewIDs(ctx context.Context) (trace.TraceID, trace.SpanID) {
+	traceID, ok := ctx.Value(traceIDKey{}).(trace.TraceID)
+	if !ok {
+		rand.Read(traceID[:])
+	}
+	var spanID trace.SpanID
+	rand.Read(spanID[:])
+	return traceID, spanID
+}
+
+func (idGenerator) NewSpanID(ctx context.Context, traceID trace.TraceID) trace.SpanID {
+	var spanID trace.SpanID
+	rand.Read(spanID[:])
+	return spanID
+}
+
+// Источник спанов одного сервиса
+type Tracer struct {
+	provider *sdktrace.TracerProvider
+	tracer   trace.Tracer
+}
+
+// Создание трассировщика; при exporter == nil спаны никуда не отправляются
+func NewTracer(service string, exporter sdktrace.SpanExporter) *Tracer {
+	opts := []sdktrace.TracerProviderOption{
+		sdktrace.WithSampler(sdktrace.AlwaysSample()),
+		sdktrace.WithIDGenerator(idGenerator{}),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", service))),
+	}
+	if exporter != nil {
+		opts = append(opts, sdktrace.WithBatcher(exporter))
+	}
+
+	provider := sdktrace.NewTracerProvider(opts...)
+	return &Tracer{provider: provider, tracer: provider.Tracer("final3/internal/tracing")}
+}
+
+// Начало спана name. Родитель берётся из спана в ctx или из удалённого контекста
+func (t *Tracer) Start(ctx context.Context, name string, opts ...StartOption) (context.Context, *Span) {
+	var cfg startConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	spanCtx := ctx
+	if cfg.traceID.IsValid() {
+		spanCtx = context.WithValue(ctx, traceIDKey{}, cfg.traceID)
+	}
+
+	_, span := t.tracer.Start(spanCtx, name, trace.WithAttributes(cfg.attributes...))
+	return trace.ContextWithSpan(ctx, span), &Span{span: span}
+}
+
+// Отправка накопленных спанов и остановка экспортёра
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	return t.provider.Shutdown(ctx)
+}
+
+var (
+	globalMu sync.RWMutex
+	global   = NewTracer("", nil)
+)
+
+// Установка глобального трассировщика
+func SetTracer(t *Tracer) {
+	globalMu.Lock()
+	defer globalMu.Unlock()
+	global = t
+}
+
+func getTracer() *Tracer {
+	globalMu.RLock()
+	defer globalMu.RUnlock()
+	return global
+}
+
+// Начало спана глобальным трассировщиком
+func Start(ctx context.Context, name string, opts ...StartOption) (context.Context, *Span) {
+	return getTracer().Start(ctx, name, opts...)
+}
+
+// Остановка глобального трассировщика
+func Shutdown(ctx context.Context) error {
+	return getTracer().Shutdown(ctx)
+}
@@ -0,0 +1,193 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	collectortrace "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	otlptrace "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		name        string
+		value       string
+		expectError bool
+	}{
+		{"Valid", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false},
+		{"NotSampled", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", false},
+		{"FutureVersion", "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false},
+		{"Empty", "", true},
+		{"ZeroTraceID", "00-00000000000000000000000000000000-00f067aa0ba902b7-01", true},
+		{"ZeroSpanID", "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", true},
+		{"UpperCase", "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", true},
+		{"ShortSpanID", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa-01", true},
+		{"InvalidVersion", "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true},
+		{"ExtraFieldsInVersion00", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc, err := ParseTraceparent(tt.value)
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("Expected error for %q", tt.value)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if got := sc.TraceID().String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+				t.Errorf("Unexpected trace ID %s", got)
+			}
+			if got := Traceparent(sc); !strings.HasPrefix(got, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-") {
+				t.Errorf("Unexpected traceparent %s", got)
+			}
+		})
+	}
+}
+
+// Экспортёр в память, сохраняющий спаны после остановки трассировщика
+type memoryExporter struct {
+	*tracetest.InMemoryExporter
+}
+
+func (memoryExporter) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+func TestTracer(t *testing.T) {
+	exporter := memoryExporter{tracetest.NewInMemoryExporter()}
+	tracer := NewTracer("test", exporter)
+
+	remote, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx := ContextWithRemoteSpanContext(context.Background(), remote)
+
+	ctx, root := tracer.Start(ctx, "root", WithAttributes("key", "value"))
+	_, child := tracer.Start(ctx, "child")
+	child.AddEvent("retry", "attempt", 2)
+	child.RecordError(errors.New("boom"))
+	child.End()
+	child.End()
+	root.End()
+
+	_, other := tracer.Start(context.Background(), "other", WithTraceID(remote.TraceID()))
+	other.End()
+
+	var nilSpan *Span
+	nilSpan.SetAttribute("ignored", true)
+	nilSpan.End()
+
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+
+	spans := make(map[string]tracetest.SpanStub)
+	for _, span := range exporter.GetSpans() {
+		spans[span.Name] = span
+	}
+	if len(spans) != 3 {
+		t.Fatalf("Expected 3 exported spans, got %d", len(spans))
+	}
+	rootSpan, childSpan, otherSpan := spans["root"], spans["child"], spans["other"]
+
+	if rootSpan.SpanContext.TraceID() != remote.TraceID() || rootSpan.Parent.SpanID() != remote.SpanID() {
+		t.Errorf("Root span is not a child of remote parent: %+v", rootSpan)
+	}
+	service, _ := rootSpan.Resource.Set().Value("service.name")
+	if len(rootSpan.Attributes) != 1 || rootSpan.Attributes[0] != attribute.String("key", "value") || service.AsString() != "test" {
+		t.Errorf("Unexpected root span: %+v", rootSpan)
+	}
+	if childSpan.SpanContext.TraceID() != remote.TraceID() || childSpan.Parent.SpanID() != rootSpan.SpanContext.SpanID() {
+		t.Errorf("Child span is not linked to root: %+v", childSpan)
+	}
+	if childSpan.Status.Code != codes.Error || childSpan.Status.Description != "boom" {
+		t.Errorf("Unexpected child span status: %+v", childSpan.Status)
+	}
+	// RecordError добавляет собственное событие exception
+	if len(childSpan.Events) != 2 || childSpan.Events[0].Name != "retry" {
+		t.Errorf("Unexpected child span events: %+v", childSpan.Events)
+	}
+	if otherSpan.SpanContext.TraceID() != remote.TraceID() || otherSpan.Parent.IsValid() {
+		t.Errorf("Unexpected span started with trace ID: %+v", otherSpan)
+	}
+}
+
+func TestOTLPExporter(t *testing.T) {
+	requests := make(chan *collectortrace.ExportTraceServiceRequest, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/x-protobuf" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		req := &collectortrace.ExportTraceServiceRequest{}
+		if err := proto.Unmarshal(body, req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		requests <- req
+	}))
+	defer server.Close()
+
+	exporter, err := NewOTLPExporter(server.URL)
+	if err != nil {
+		t.Fatalf("Failed to create exporter: %v", err)
+	}
+
+	tracer := NewTracer("orchestrator", exporter)
+	ctx, root := tracer.Start(context.Background(), "expression", WithAttributes("expression_id", int32(1), "ratio", 0.5))
+	_, child := tracer.Start(ctx, "task")
+	child.RecordError(errors.New("division by zero"))
+	child.End()
+	root.End()
+
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+
+	req := <-requests
+	if len(req.ResourceSpans) != 1 || len(req.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("Unexpected request structure: %v", req)
+	}
+
+	service := req.ResourceSpans[0].Resource.Attributes[0]
+	if service.Key != "service.name" || service.Value.GetStringValue() != "orchestrator" {
+		t.Errorf("Unexpected resource attribute: %v", service)
+	}
+
+	spans := req.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("Expected 2 spans, got %d", len(spans))
+	}
+
+	task, expression := spans[0], spans[1]
+	if string(task.ParentSpanId) != string(expression.SpanId) || string(task.TraceId) != string(expression.TraceId) {
+		t.Errorf("Task span is not linked to expression span")
+	}
+	if task.Status.Code != otlptrace.Status_STATUS_CODE_ERROR || task.Status.Message != "division by zero" {
+		t.Errorf("Unexpected task status: %v", task.Status)
+	}
+	if v := expression.Attributes[0].Value.GetIntValue(); v != 1 {
+		t.Errorf("Expected int attribute, got %v", expression.Attributes[0])
+	}
+	if v := expression.Attributes[1].Value.GetDoubleValue(); v != 0.5 {
+		t.Errorf("Expected double attribute, got %v", expression.Attributes[1])
+	}
+}